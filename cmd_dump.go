@@ -14,6 +14,7 @@ import (
 )
 
 type dumpCmd struct {
+	ProbePluginDir string
 }
 
 //
@@ -33,6 +34,7 @@ func (*dumpCmd) Usage() string {
 // Flag setup.
 //
 func (p *dumpCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.ProbePluginDir, "probe-plugin-dir", "", "The directory containing external probe plugins, to register as protocol-tests.")
 }
 
 //
@@ -49,6 +51,15 @@ func dumpTest(tst test.Test) error {
 //
 func (p *dumpCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	//
+	// Register the external probe plugins, so that their tests
+	// can be parsed.
+	//
+	if err := loadProbePlugins(p.ProbePluginDir); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
 	for _, file := range f.Args() {
 
 		//
@@ -24,6 +24,7 @@ type enqueueCmd struct {
 	RedisPassword    string
 	RedisSocket      string
 	RedisDialTimeout time.Duration
+	ProbePluginDir   string
 	_r               *redis.Client
 }
 
@@ -77,6 +78,8 @@ func (p *enqueueCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.RedisPassword, "redis-pass", defaults.RedisPassword, "Specify the password for the redis queue.")
 	f.StringVar(&p.RedisSocket, "redis-socket", defaults.RedisSocket, "If set, will be used for the redis connections.")
 	f.DurationVar(&p.RedisDialTimeout, "redis-timeout", defaults.RedisDialTimeout, "Redis connection timeout.")
+
+	f.StringVar(&p.ProbePluginDir, "probe-plugin-dir", defaults.ProbePluginDir, "The directory containing external probe plugins, to register as protocol-tests.")
 }
 
 //
@@ -122,6 +125,15 @@ func (p *enqueueCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		return subcommands.ExitFailure
 	}
 
+	//
+	// Register the external probe plugins, so that their tests
+	// can be parsed.
+	//
+	if err = loadProbePlugins(p.ProbePluginDir); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
 	//
 	// For each file on the command-line we can now parse and
 	// enqueue the jobs
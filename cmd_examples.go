@@ -15,6 +15,7 @@ import (
 )
 
 type examplesCmd struct {
+	ProbePluginDir string
 }
 
 //
@@ -32,6 +33,7 @@ func (*examplesCmd) Usage() string {
 // Flag setup.
 //
 func (p *examplesCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.ProbePluginDir, "probe-plugin-dir", "", "The directory containing external probe plugins, to show alongside the built-in protocol-tests.")
 }
 
 //
@@ -99,6 +101,11 @@ func showExamples(filter string) {
 //
 func (p *examplesCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	if err := loadProbePlugins(p.ProbePluginDir); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
 	if len(f.Args()) > 0 {
 		for _, name := range f.Args() {
 			showExamples(name)
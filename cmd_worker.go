@@ -89,6 +89,15 @@ type workerCmd struct {
 	// Default period test threshold percentage, if not overridden by specific test setting
 	PeriodTestThreshold float32
 
+	// The directory containing the plugins which the exec-test is allowed to run
+	ExecPluginDir string
+
+	// The directory containing the secrets which tests may read via "file:"
+	SecretsDir string
+
+	// The directory containing the external probe plugins to load
+	ProbePluginDir string
+
 	// The handle to our redis-server
 	_r *redis.Client
 
@@ -244,6 +253,15 @@ func (p *workerCmd) SetFlags(f *flag.FlagSet) {
 	// Period test
 	f.DurationVar(&p.PeriodTestSleep, "period-test-sleep", defaults.PeriodTestSleep, "The sleeping interval between subsequent tests in a period-test.")
 	f.Var(utils.NewPercentageValue(defaults.PeriodTestThreshold, &p.PeriodTestThreshold), "period-test-threshold", "The percentage of failures need to trigger an alert in a period-test.")
+
+	// Exec test
+	f.StringVar(&p.ExecPluginDir, "exec-plugin-dir", defaults.ExecPluginDir, "The directory containing the Nagios-compatible plugins the exec-test is allowed to run.")
+
+	// Secrets
+	f.StringVar(&p.SecretsDir, "secrets-dir", defaults.SecretsDir, "The directory containing the secret files which tests may read via 'file:NAME'.")
+
+	// Probe plugins
+	f.StringVar(&p.ProbePluginDir, "probe-plugin-dir", defaults.ProbePluginDir, "The directory containing external probe plugins, to register as protocol-tests.")
 }
 
 // notify is used to store the result of a test in our redis queue.
@@ -864,7 +882,18 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 	//
 	for _, target := range targets {
 		wg.Add(1)
-		go func() {
+		go func(target string) {
+
+			//
+			// Protocol-tests may record extra metrics of their own,
+			// which are kept apart for each target.
+			//
+			opts := opts
+			opts.Metric = func(name string, value float64) {
+				metricsLock.Lock()
+				metrics[p.formatMetrics(tst, p.alphaNumeric(target)+"."+name)] = fmt.Sprintf("%f", value)
+				metricsLock.Unlock()
+			}
 
 			// Is this a period test?
 			if tst.PeriodTestDuration != nil {
@@ -959,9 +988,10 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 			}
 
 			//
-			// The result of the test.
+			// The result of the test, and its details if any.
 			//
 			var result error
+			var details *string
 
 			//
 			// Record the start-time of the test.
@@ -989,7 +1019,7 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 				//
 				// Run the test
 				//
-				result = tmp.RunTest(tst, target, opts)
+				details, result = protocols.RunTest(tmp, tst, target, opts)
 
 				//
 				// If the test passed then we're good.
@@ -1022,9 +1052,9 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 				}
 			}
 
-			testEndFn(timeA, target, c, result, nil)
+			testEndFn(timeA, target, c, result, details)
 			wg.Done()
-		}()
+		}(target)
 	}
 
 	wg.Wait()
@@ -1042,6 +1072,9 @@ func (p *workerCmd) runTest(workerIdx uint, tst test.Test, opts test.Options) er
 	//  3.  The number of attempts (retries, really) before the
 	//      test was completed.
 	//
+	// Along with any measurements made by the test itself, such as
+	// the phases of a HTTP request.
+	//
 	if p._g != nil {
 		for key, val := range metrics {
 			v := os.Getenv("METRICS_VERBOSE")
@@ -1101,6 +1134,14 @@ func (p *workerCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 	//
 	p.MetricsFromEnvironment()
 
+	//
+	// Register the external probe plugins, if any
+	//
+	if err = loadProbePlugins(p.ProbePluginDir); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
 	//
 	// Setup the options passed to each test, by copying our
 	// global ones.
@@ -1108,6 +1149,10 @@ func (p *workerCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}
 	var opts test.Options
 	opts.Verbose = p.Verbose
 	opts.Timeout = p.Timeout
+	opts.ExecPluginDir = p.ExecPluginDir
+	opts.SecretsDir = p.SecretsDir
+	opts.IPv4 = p.IPv4
+	opts.IPv6 = p.IPv6
 
 	//
 	// Create a parser for our input
@@ -3,11 +3,15 @@ module github.com/cmaster11/overseer
 go 1.13
 
 require (
+	github.com/antchfx/xmlquery v1.2.4
+	github.com/antchfx/xpath v1.1.6
 	github.com/cmaster11/k8s-event-watcher v0.0.8
 	github.com/emersion/go-imap v1.0.0-beta.2
 	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/google/subcommands v1.0.1
+	github.com/gorilla/websocket v1.5.0
+	github.com/gosnmp/gosnmp v1.32.0
 	github.com/jlaffaye/ftp v0.0.0-20190126081051-8019e6774408
 	github.com/lib/pq v1.0.0
 	github.com/marpaia/graphite-golang v0.0.0-20171231172105-134b9af18cf3
@@ -18,8 +22,13 @@ require (
 	github.com/robfig/cron v0.0.0-20180505203441-b41be1df6967
 	github.com/simia-tech/go-pop3 v0.0.0-20150626094726-c9c20550a244
 	github.com/skx/golang-metrics v0.0.0-20180606065905-85a4b4e0641f
+	github.com/tidwall/gjson v1.14.3
+	github.com/xeipuuv/gojsonschema v1.2.0
+	go.starlark.net v0.0.0-20210223155950-e043a3d3c984
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	golang.org/x/lint v0.0.0-20191125180803-fdd1cda4f05f // indirect
-	golang.org/x/sys v0.0.0-20191010194322-b09406accb47 // indirect
+	golang.org/x/net v0.0.0-20200421231249-e086a090c8fd
+	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a
 	golang.org/x/text v0.3.2 // indirect
 	golang.org/x/tools v0.0.0-20200529172331-a64b76657301 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
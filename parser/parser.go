@@ -321,6 +321,20 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 	arguments := s.ParseArguments(input)
 	result.Arguments = make(map[string]string)
 
+	//
+	// Some arguments can be given more than once, in which case all
+	// their values are kept.
+	//
+	repeated := make(map[string][]string)
+	if withRepeatable, ok := handler.(protocols.ProtocolTestWithRepeatableArguments); ok {
+		values := s.ParseArgumentValues(input)
+		for _, name := range withRepeatable.RepeatableArguments() {
+			if len(values[name]) > 0 {
+				repeated[name] = values[name]
+			}
+		}
+	}
+
 	//
 	// See which arguments the object supports
 	//
@@ -457,13 +471,20 @@ func (s *Parser) ParseLine(input string, cb ParsedTest) (test.Test, error) {
 		// Otherwise we need to look for a match
 		//
 		expr := regexp.MustCompile(pattern)
-		match := expr.FindStringSubmatch(val)
 
-		if match == nil {
-			return result, fmt.Errorf("unsupported argument '%s' for test-type '%s' in input '%s' - did not match pattern '%s'", arg, testType, input, pattern)
+		values := []string{val}
+		if repeated[arg] != nil {
+			values = repeated[arg]
 		}
+		for _, value := range values {
+			match := expr.FindStringSubmatch(value)
 
-		result.Arguments[arg] = val
+			if match == nil {
+				return result, fmt.Errorf("unsupported argument '%s' for test-type '%s' in input '%s' - did not match pattern '%s'", arg, testType, input, pattern)
+			}
+		}
+
+		result.Arguments[arg] = strings.Join(values, "\n")
 	}
 
 	//
@@ -501,9 +522,28 @@ func (s *Parser) TrimQuotes(in string, c byte) string {
 //
 // Any option that is wrapped in matching quotes has them removed.
 //
+// If an option is given more than once the last non-empty value is kept.
+//
 func (s *Parser) ParseArguments(input string) map[string]string {
 	res := make(map[string]string)
 
+	for name, values := range s.ParseArgumentValues(input) {
+		res[name] = ""
+		for _, value := range values {
+			if value != "" {
+				res[name] = value
+			}
+		}
+	}
+	return res
+}
+
+// ParseArgumentValues extracts the values of the named options, like
+// ParseArguments, but returns all the values of the options which are
+// given more than once, in the order they appear.
+func (s *Parser) ParseArgumentValues(input string) map[string][]string {
+	res := make(map[string][]string)
+
 	//
 	// Look for each option
 	//
@@ -519,21 +559,11 @@ func (s *Parser) ParseArguments(input string) map[string]string {
 		value = s.TrimQuotes(value, '\'')
 		value = s.TrimQuotes(value, '"')
 
-		// Store the value in our map - unless there is already a value
-		// present.
-		//
-		// This works the way you'd expect because our regular expression
-		// is parsing "backwards". So parsing:
 		//
-		//   with foo bar with foo baz with foo steve
+		// Our regular expression is parsing "backwards", so each
+		// value is prepended to the ones we've already found.
 		//
-		// We first store "steve", then we would store "baz" and
-		// finally "bar".  We skip this because of the non-empty
-		// test here, which means the last value is kept.
-		//
-		if res[name] == "" {
-			res[name] = value
-		}
+		res[name] = append([]string{value}, res[name]...)
 
 		// Continue matching the tail of the string.
 		input = prefix
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cmaster11/overseer/protocols"
 	"github.com/cmaster11/overseer/test"
 )
 
@@ -484,11 +485,46 @@ func TestDuplicateArguments(t *testing.T) {
 	}
 }
 
+// Test arguments which may be given more than once
+func TestRepeatedArguments(t *testing.T) {
+	in := "http://example.com/ must run http with header 'X-One: 1' with status 200 with header 'X-Two: 2' with status 201"
+
+	p := New()
+
+	out, err := p.ParseLine(in, nil)
+	if err != nil {
+		t.Fatalf("Error parsing %s - %s", in, err.Error())
+	}
+
+	// Headers are repeatable, so both are kept
+	headers := protocols.ArgumentValues(out, "header")
+	if len(headers) != 2 || headers[0] != "X-One: 1" || headers[1] != "X-Two: 2" {
+		t.Errorf("Failed to get the repeated headers: %v", headers)
+	}
+
+	// The status isn't, so the last one wins
+	if out.Arguments["status"] != "201" {
+		t.Errorf("Failed to get the correct status-value")
+	}
+
+	// Both headers are shown when sanitized
+	safe := out.Sanitize()
+	if !strings.Contains(safe, "with header 'X-One: 1' with header 'X-Two: 2'") {
+		t.Errorf("Sanitized test lost the repeated headers: %s", safe)
+	}
+
+	// Each value must be valid
+	_, err = p.ParseLine("http://example.com/ must run http with header 'X-One: 1' with header 'invalid'", nil)
+	if err == nil {
+		t.Errorf("Expected an invalid repeated value to fail")
+	}
+}
+
 // Test some invalid options
 func TestInvalidOptions(t *testing.T) {
 	tests := []string{
 		"http://example.com/ must run http with CONTENT 'moi'",
-		"http://example.com/ must run http with header 'foo: bar'",
+		"http://example.com/ must run http with header 'foo bar'",
 		"http://example.com/ must run http with statsu 300 ",
 	}
 
@@ -713,3 +749,43 @@ http://example.com/ must run http with username 'steve' with password 'ke'mp'
 		t.Errorf("We see no evidence of censorship")
 	}
 }
+
+// Test that secrets other than passwords are censored too
+func TestSanitizeSecrets(t *testing.T) {
+	in := "https://api.example.com/ must run http with bearer-token 't0ken' with oauth2-token-url 'https://auth.example.com/token' with oauth2-client-id 'overseer' with oauth2-client-secret 's3cret'"
+
+	p := New()
+
+	out, err := p.ParseLine(in, nil)
+	if err != nil {
+		t.Fatalf("Error parsing %s - %s", in, err.Error())
+	}
+
+	safe := out.Sanitize()
+	if strings.Contains(safe, "t0ken") || strings.Contains(safe, "s3cret") {
+		t.Errorf("Secrets are still visible: %s", safe)
+	}
+	if !strings.Contains(safe, "with oauth2-client-id 'overseer'") {
+		t.Errorf("Sanitized test lost the client-id: %s", safe)
+	}
+}
+
+// Test that the values of the headers carrying credentials are censored
+func TestSanitizeHeaders(t *testing.T) {
+	in := "https://api.example.com/ must run http with header 'Authorization: Basic dXNlcjpwYXNz' with header 'X-Trace: 1' with header 'cookie: session=s3cret' with header 'Proxy-Authorization: Bearer t0ken'"
+
+	p := New()
+
+	out, err := p.ParseLine(in, nil)
+	if err != nil {
+		t.Fatalf("Error parsing %s - %s", in, err.Error())
+	}
+
+	safe := out.Sanitize()
+	if strings.Contains(safe, "dXNlcjpwYXNz") || strings.Contains(safe, "s3cret") || strings.Contains(safe, "t0ken") {
+		t.Errorf("Secret headers are still visible: %s", safe)
+	}
+	if !strings.Contains(safe, "with header 'Authorization: CENSORED' with header 'X-Trace: 1' with header 'cookie: CENSORED'") {
+		t.Errorf("Sanitized test lost the headers: %s", safe)
+	}
+}
@@ -7,6 +7,7 @@
 package protocols
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/cmaster11/overseer/test"
@@ -44,6 +45,47 @@ type ProtocolTest interface {
 	GetUniqueHashForTest(tst test.Test, opts test.Options) *string
 }
 
+// ProtocolTestWithDetails is an optional interface, which can be
+// implemented by protocol-tests that want to attach details, such as
+// measured values, to their results.
+type ProtocolTestWithDetails interface {
+	//
+	// RunTestWithDetails behaves like RunTest, but also returns a
+	// human-readable description of what has been measured, which
+	// may be present regardless of the test passing or failing.
+	//
+	RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error)
+}
+
+// ProtocolTestWithRepeatableArguments is an optional interface, which
+// can be implemented by protocol-tests accepting some arguments more
+// than once, such as HTTP headers.
+type ProtocolTestWithRepeatableArguments interface {
+	//
+	// RepeatableArguments returns the names of the arguments which
+	// may be given more than once.  All their values are kept, joined
+	// by newlines, and can be retrieved via ArgumentValues.
+	//
+	RepeatableArguments() []string
+}
+
+// ArgumentValues returns all the values of a repeatable argument.
+func ArgumentValues(tst test.Test, name string) []string {
+	if tst.Arguments[name] == "" {
+		return nil
+	}
+	return strings.Split(tst.Arguments[name], "\n")
+}
+
+// RunTest invokes the given protocol-test, returning the details of its
+// result if the protocol-test supports them.
+func RunTest(handler ProtocolTest, tst test.Test, target string, opts test.Options) (*string, error) {
+	if withDetails, ok := handler.(ProtocolTestWithDetails); ok {
+		return withDetails.RunTestWithDetails(tst, target, opts)
+	}
+	return nil, handler.RunTest(tst, target, opts)
+}
+
 // This is a map of known-tests.
 var handlers = struct {
 	m map[string]TestCtor
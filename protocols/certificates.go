@@ -0,0 +1,339 @@
+package protocols
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// parseExpirationPeriod returns the number of hours given by an
+// expiration setting, such as "7d" or "12h".  An empty setting means the
+// default of 14 days.
+func parseExpirationPeriod(expire string) (int64, error) {
+
+	//
+	// The default expiration-time 14 days.
+	//
+	if expire == "" {
+		return 14 * 24, nil
+	}
+
+	//
+	// How much to scale the given figure by
+	//
+	mul := int64(1)
+
+	// Days?
+	if strings.HasSuffix(expire, "d") {
+		expire = strings.TrimSuffix(expire, "d")
+		mul = 24
+	}
+
+	// Hours?
+	if strings.HasSuffix(expire, "h") {
+		expire = strings.TrimSuffix(expire, "h")
+	}
+
+	// Get the period.
+	period, err := strconv.ParseInt(expire, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return period * mul, nil
+}
+
+// loadCAFile returns a pool of the certificates in the given PEM file,
+// to be trusted instead of the system roots.
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return roots, nil
+}
+
+// certificateExpiration returns the number of hours remaining for the
+// given certificate chains, along with the common-name of the first
+// certificate to expire.
+func certificateExpiration(chains [][]*x509.Certificate, verbose bool) (int64, string) {
+
+	// Expiry time, in hours
+	var hours int64
+	hours = -1
+
+	// The common-name of the certificate involved.
+	cn := ""
+
+	timeNow := time.Now()
+	for _, chain := range chains {
+		for _, cert := range chain {
+
+			// Get the expiration time, in hours.
+			expiresIn := int64(cert.NotAfter.Sub(timeNow).Hours())
+
+			if verbose {
+				fmt.Printf("SSLExpiration - certificate: %s expires in %d hours (%d days)\n", cert.Subject.CommonName, expiresIn, expiresIn/24)
+			}
+
+			// Replace our result if the certificate is going to
+			// expire more recently than the current "winner".
+			if hours == -1 || expiresIn < hours {
+				hours = expiresIn
+				cn = cert.Subject.CommonName
+			}
+		}
+	}
+
+	return hours, cn
+}
+
+// checkCertificateExpiration fails if any certificate of the connection
+// expires within the given number of hours.
+//
+// The verified chains are used, or all the certificates sent by the
+// server if these weren't verified.
+func checkCertificateExpiration(state tls.ConnectionState, period int64, verbose bool) error {
+	chains := state.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{state.PeerCertificates}
+	}
+
+	hours, cn := certificateExpiration(chains, verbose)
+	if hours < period {
+		return fmt.Errorf("SSL certificate '%s' will expire in %d hours (%d days)", cn, hours, int(hours/24))
+	}
+	return nil
+}
+
+// verifyCertificateChain returns a function which verifies the chain
+// offered by a server against the given roots, or the system ones if
+// nil, without checking the hostname it is valid for.
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		var certs []*x509.Certificate
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return errors.New("no certificate offered")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
+
+// checkSTARTTLSExpiration makes a new connection to the address, upgrades
+// it to TLS via the STARTTLS dialog of the given protocol, and checks
+// the expiration of the certificates against the "expiration" setting.
+//
+// Unless insecure, the certificates are verified, and so is their
+// validity for tst.Target if verifyHostname is set.
+func checkSTARTTLSExpiration(protocol string, address string, tst test.Test, opts test.Options, insecure bool, verifyHostname bool) error {
+	period, err := parseExpirationPeriod(tst.Arguments["expiration"])
+	if err != nil {
+		return err
+	}
+
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err = startTLS(conn, protocol, tst.Target); err != nil {
+		return fmt.Errorf("STARTTLS failed: %s", err.Error())
+	}
+
+	config := &tls.Config{
+		ServerName:         tst.Target,
+		InsecureSkipVerify: insecure,
+	}
+	if !insecure && !verifyHostname {
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyCertificateChain(nil)
+	}
+
+	client := tls.Client(conn, config)
+	if err = client.Handshake(); err != nil {
+		return err
+	}
+
+	return checkCertificateExpiration(client.ConnectionState(), period, opts.Verbose)
+}
+
+// startTLS performs the protocol-specific dialog which asks the server
+// to upgrade the connection to TLS.  Once it returns the TLS handshake
+// can start.
+func startTLS(conn net.Conn, protocol string, hostname string) error {
+	r := bufio.NewReader(conn)
+
+	switch protocol {
+	case "smtp":
+		if _, err := expectReply(r, "220"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "EHLO overseer\r\n")
+		text, err := expectReply(r, "250")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(strings.ToUpper(text), "STARTTLS") {
+			return errors.New("STARTTLS not advertised")
+		}
+		fmt.Fprintf(conn, "STARTTLS\r\n")
+		_, err = expectReply(r, "220")
+		return err
+
+	case "ftp":
+		if _, err := expectReply(r, "220"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "AUTH TLS\r\n")
+		_, err := expectReply(r, "234")
+		return err
+
+	case "pop3":
+		if _, err := expectLine(r, "+OK"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "STLS\r\n")
+		_, err := expectLine(r, "+OK")
+		return err
+
+	case "imap":
+		if _, err := expectLine(r, "* OK"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "a1 STARTTLS\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a1 ") {
+				if !strings.HasPrefix(strings.ToUpper(line), "A1 OK") {
+					return fmt.Errorf("unexpected response '%s'", strings.TrimSpace(line))
+				}
+				return nil
+			}
+		}
+
+	case "xmpp":
+		fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", hostname)
+		features, err := readXML(r, "</stream:features>")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(features, "<starttls") {
+			return errors.New("STARTTLS not advertised")
+		}
+		fmt.Fprintf(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+		reply, err := readXML(r, ">")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(reply, "<proceed") {
+			return fmt.Errorf("unexpected response '%s'", reply)
+		}
+		return nil
+
+	case "psql":
+		// An SSLRequest message
+		if _, err := conn.Write([]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}); err != nil {
+			return err
+		}
+		reply, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if reply != 'S' {
+			return errors.New("TLS not supported by the server")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("STARTTLS is not supported for %s", protocol)
+}
+
+// expectReply reads a reply, which might span several lines as used by
+// SMTP and FTP, and ensures it has the given code.
+func expectReply(r *bufio.Reader, code string) (string, error) {
+	var text []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		text = append(text, line)
+
+		if len(line) < 4 || line[3] != '-' {
+			if !strings.HasPrefix(line, code) {
+				return "", fmt.Errorf("unexpected response '%s'", line)
+			}
+			return strings.Join(text, "\n"), nil
+		}
+	}
+}
+
+// expectLine reads a single line, ensuring it has the given prefix.
+func expectLine(r *bufio.Reader, prefix string) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("unexpected response '%s'", line)
+	}
+	return line, nil
+}
+
+// readXML reads from an XML stream until the given suffix is found.
+func readXML(r *bufio.Reader, suffix string) (string, error) {
+	var data string
+	for !strings.HasSuffix(data, suffix) {
+		chunk, err := r.ReadString('>')
+		if err != nil {
+			return "", err
+		}
+		data += chunk
+	}
+	return data, nil
+}
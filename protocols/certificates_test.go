@@ -0,0 +1,164 @@
+package protocols
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// startSTARTTLSServer starts a stand-in which performs the server side
+// of the STARTTLS dialog of the given protocol, and then the handshake.
+func startSTARTTLSServer(t *testing.T, protocol string, cfg *tls.Config) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting %s server: %s", protocol, err.Error())
+	}
+
+	dialog := func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		switch protocol {
+		case "smtp":
+			io.WriteString(conn, "220 ready\r\n")
+			r.ReadString('\n')
+			io.WriteString(conn, "250-overseer\r\n250 STARTTLS\r\n")
+			r.ReadString('\n')
+			io.WriteString(conn, "220 go ahead\r\n")
+		case "ftp":
+			io.WriteString(conn, "220-welcome\r\n220 ready\r\n")
+			r.ReadString('\n')
+			io.WriteString(conn, "234 go ahead\r\n")
+		case "pop3":
+			io.WriteString(conn, "+OK ready\r\n")
+			r.ReadString('\n')
+			io.WriteString(conn, "+OK go ahead\r\n")
+		case "imap":
+			io.WriteString(conn, "* OK ready\r\n")
+			r.ReadString('\n')
+			io.WriteString(conn, "a1 OK go ahead\r\n")
+		case "xmpp":
+			r.ReadString('>')
+			r.ReadString('>')
+			io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='example.com' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'><stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")
+			r.ReadString('>')
+			io.WriteString(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+		case "psql":
+			io.ReadFull(r, make([]byte, 8))
+			io.WriteString(conn, "S")
+		}
+		tls.Server(conn, cfg).Handshake()
+	}
+
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			dialog(conn)
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+// Test the STARTTLS upgrade and expiration check of every protocol
+func TestSTARTTLSExpiration(t *testing.T) {
+	pki := newTestPKI(t)
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{pki.leaf.cert.Raw, pki.intermediate.cert.Raw},
+			PrivateKey:  pki.leaf.key,
+		}},
+	}
+
+	for _, protocol := range []string{"smtp", "ftp", "pop3", "imap", "xmpp", "psql"} {
+		address, shutdown := startSTARTTLSServer(t, protocol, cfg)
+
+		tests := []struct {
+			Expiration string
+			Insecure   bool
+			Valid      bool
+		}{
+			{"30d", true, true},
+			{"100", true, true},
+			{"100d", true, false},
+			{"30d", false, false},
+		}
+
+		for _, tst := range tests {
+			err := checkSTARTTLSExpiration(protocol, address, test.Test{
+				Target:    "www.example.com",
+				Arguments: map[string]string{"expiration": tst.Expiration},
+			}, test.Options{Timeout: time.Second}, tst.Insecure, true)
+
+			if tst.Valid && err != nil {
+				t.Errorf("Expected %s test %v to pass, got error: %s", protocol, tst, err.Error())
+			}
+			if !tst.Valid && err == nil {
+				t.Errorf("Expected %s test %v to fail", protocol, tst)
+			}
+		}
+
+		shutdown()
+	}
+}
+
+// Test the parsing of expiration periods
+func TestParseExpirationPeriod(t *testing.T) {
+	tests := map[string]int64{
+		"":    14 * 24,
+		"7d":  7 * 24,
+		"12h": 12,
+		"36":  36,
+	}
+
+	for input, expected := range tests {
+		period, err := parseExpirationPeriod(input)
+		if err != nil {
+			t.Errorf("Unexpected error parsing '%s': %s", input, err.Error())
+		}
+		if period != expected {
+			t.Errorf("Expected '%s' to be %d hours, got %d", input, expected, period)
+		}
+	}
+
+	if _, err := parseExpirationPeriod("soon"); err == nil {
+		t.Errorf("Expected an invalid period to fail")
+	}
+}
+
+// Test verifying a chain without its hostname, as for verify-ca
+func TestVerifyCertificateChain(t *testing.T) {
+	pki := newTestPKI(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.root.cert)
+
+	tests := []struct {
+		Roots *x509.CertPool
+		Chain [][]byte
+		Valid bool
+	}{
+		{roots, [][]byte{pki.leaf.cert.Raw, pki.intermediate.cert.Raw}, true},
+		{roots, [][]byte{pki.leaf.cert.Raw}, false},
+		{roots, nil, false},
+		{x509.NewCertPool(), [][]byte{pki.leaf.cert.Raw, pki.intermediate.cert.Raw}, false},
+	}
+
+	for i, tst := range tests {
+		err := verifyCertificateChain(tst.Roots)(tst.Chain, nil)
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected chain %d to pass, got error: %s", i, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected chain %d to fail", i)
+		}
+	}
+}
@@ -8,15 +8,79 @@
 // This test ensures that the DNS lookup of an A record for `test.example.com`
 // returns the single value 1.2.3.4
 //
-// Lookups are supported for A, AAAA, MX, NS, and TXT records.
+// Lookups are supported for A, AAAA, CAA, CNAME, DS, MX, NS, PTR, SOA,
+// SRV, and TXT records.  For PTR lookups an IP address can be given, and
+// it will be converted to the matching reverse name.
+//
+// Only the first string of a TXT record is compared, unless txt-join
+// is set to join all of them into a single value.  A reply with an
+// error, such as SERVFAIL, is an empty result, unless fail-on-error is
+// set to fail the test instead, as errors always do for the DNSSEC and
+// serial checks:
+//
+//    ns.example.com must run dns with lookup example.com with type TXT with result 'v=spf1 -all' with txt-join true with fail-on-error true
+//
+// Rather than an exact result, the records can be matched against a
+// regular expression, or checked to include some values:
+//
+//    ns.example.com must run dns with lookup example.com with type MX with pattern 'mx[0-9]\.example\.com'
+//
+//    ns.example.com must run dns with lookup example.com with type NS with contains 'ns1.example.com.,ns2.example.com.'
+//
+// The SOA serial of a zone can be checked to be identical across all
+// of its authoritative nameservers, which are found via the target:
+//
+//    8.8.8.8 must run dns with lookup example.com with serial-consistency true
+//
+// The nameservers are reached over IPv4 and IPv6, unless either is
+// disabled on the worker.
+//
+// Queries can be made over TCP, with EDNS and a custom UDP buffer-size,
+// or to a different port:
+//
+//    ns.example.com must run dns with lookup example.com with type TXT with result 'v=spf1 -all' with tcp true
+//
+//    ns.example.com must run dns with lookup example.com with type TXT with result 'v=spf1 -all' with edns 4096
+//
+// Queries can also be made over DNS-over-TLS (port 853) or DNS-over-HTTPS
+// (RFC 8484, port 443), validating the certificate of the server against
+// its name:
+//
+//    dns.google must run dns with lookup example.com with type A with result '93.184.215.14' with transport dot
+//
+//    dns.google must run dns with lookup example.com with type A with result '93.184.215.14' with transport doh
+//
+// DNS-over-HTTPS queries are sent to the /dns-query path, unless another
+// is set via doh-path.  Certificate errors can be ignored via tls insecure.
+//
+// The DNSSEC chain of trust of the returned records can be validated, up
+// to the root-zone keys or to a custom trust anchor given as a DS record:
+//
+//    8.8.8.8 must run dns with lookup example.com with type A with dnssec true
+//
+//    8.8.8.8 must run dns with lookup example.com with type A with dnssec true with trust-anchor 'example.com. IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C'
+//
+// The test can also fail if any signature of the chain expires soon:
+//
+//    8.8.8.8 must run dns with lookup example.com with type A with dnssec true with rrsig-expiry 72h
 //
 
 package protocols
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,37 +92,220 @@ import (
 type DNSTest struct {
 }
 
-var (
-	localm *dns.Msg
-	localc *dns.Client
-)
-
-// lookup will perform a DNS query, using the servername-specified.
-// It returns an array of maps of the response.
-func (s *DNSTest) lookup(server string, name string, ltype string, timeout time.Duration) ([]string, error) {
+// dnsTypes maps the supported record-types to their values.
+var dnsTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CAA":   dns.TypeCAA,
+	"CNAME": dns.TypeCNAME,
+	"DS":    dns.TypeDS,
+	"MX":    dns.TypeMX,
+	"NS":    dns.TypeNS,
+	"PTR":   dns.TypePTR,
+	"SOA":   dns.TypeSOA,
+	"SRV":   dns.TypeSRV,
+	"TXT":   dns.TypeTXT,
+}
 
-	var results []string
+// dnsQuery holds the settings of the queries we make.
+type dnsQuery struct {
+	port      string
+	transport string
+	edns      uint16
+	dnssec    bool
+	timeout   time.Duration
+
+	// Whether a reply with an error, such as SERVFAIL, fails the
+	// query rather than being an empty result.
+	failOnError bool
+
+	// The settings of encrypted transports.
+	hostname string
+	insecure bool
+	dohPath  string
+}
 
-	var err error
-	localm = &dns.Msg{
+// exchange sends a single question to the given DNS-server, retrying
+// over TCP if the response was truncated.
+func (q *dnsQuery) exchange(server string, qname string, qtype uint16, recurse bool) (*dns.Msg, error) {
+	m := &dns.Msg{
 		MsgHdr: dns.MsgHdr{
-			RecursionDesired: true,
+			RecursionDesired: recurse,
 		},
-		Question: make([]dns.Question, 1),
 	}
-	localc = &dns.Client{
-		ReadTimeout: timeout,
+	m.SetQuestion(qname, qtype)
+
+	//
+	// For DNSSEC we want the signatures, and to validate them
+	// ourselves.
+	//
+	if q.dnssec {
+		size := q.edns
+		if size == 0 {
+			size = 4096
+		}
+		m.SetEdns0(size, true)
+		m.CheckingDisabled = true
+	} else if q.edns > 0 {
+		m.SetEdns0(q.edns, false)
+	}
+
+	address := net.JoinHostPort(server, q.port)
+
+	var r *dns.Msg
+	var err error
+	switch q.transport {
+	case "doh":
+		r, err = q.exchangeHTTPS(m, address)
+	case "dot":
+		c := &dns.Client{
+			Net:     "tcp-tls",
+			Timeout: q.timeout,
+			TLSConfig: &tls.Config{
+				ServerName:         q.hostname,
+				InsecureSkipVerify: q.insecure,
+			},
+		}
+		r, _, err = c.Exchange(m, address)
+	case "tcp":
+		c := &dns.Client{Net: "tcp", Timeout: q.timeout}
+		r, _, err = c.Exchange(m, address)
+	default:
+		c := &dns.Client{Timeout: q.timeout}
+		r, _, err = c.Exchange(m, address)
+		if err == nil && r.Truncated {
+			c.Net = "tcp"
+			r, _, err = c.Exchange(m, address)
+		}
 	}
-	r, err := s.localQuery(server, dns.Fqdn(name), ltype)
-	if err != nil || r == nil {
+	if err != nil {
 		return nil, err
 	}
+
 	if r.Rcode == dns.RcodeNameError {
-		return nil, fmt.Errorf("no such domain %s", dns.Fqdn(name))
+		return nil, fmt.Errorf("no such domain %s", qname)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		if !q.failOnError {
+			r.Answer = nil
+			return r, nil
+		}
+		return nil, fmt.Errorf("lookup of %s failed with %s", qname, dns.RcodeToString[r.Rcode])
+	}
+	return r, nil
+}
+
+// exchangeHTTPS sends the query via DNS-over-HTTPS, as described in
+// RFC 8484, to the given address.
+func (q *dnsQuery) exchangeHTTPS(m *dns.Msg, address string) (*dns.Msg, error) {
+	m.Id = 0
+	query, err := m.Pack()
+	if err != nil {
+		return nil, err
 	}
 
+	//
+	// Connect to the resolved address, while using the hostname
+	// for the request and the certificate validation.
+	//
+	dialer := &net.Dialer{Timeout: q.timeout}
+	client := &http.Client{
+		Timeout: q.timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSClientConfig: &tls.Config{
+				ServerName:         q.hostname,
+				InsecureSkipVerify: q.insecure,
+			},
+		},
+	}
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   net.JoinHostPort(q.hostname, q.port),
+		Path:   q.dohPath,
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS query failed with status code %d", response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(dns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, fmt.Errorf("invalid DNS-over-HTTPS response: %s", err.Error())
+	}
+	return r, nil
+}
+
+// lookup will perform a DNS query, using the servername-specified.
+// It returns the string form of the matching records.
+func (s *DNSTest) lookup(q *dnsQuery, server string, name string, ltype string) ([]string, error) {
+	r, err := s.query(q, server, name, ltype)
+	if err != nil {
+		return nil, err
+	}
+	return s.records(r, dnsTypes[ltype], false), nil
+}
+
+// query will perform a DNS query, using the servername-specified, and
+// return the response.
+func (s *DNSTest) query(q *dnsQuery, server string, name string, ltype string) (*dns.Msg, error) {
+	qtype := dnsTypes[ltype]
+	if qtype == 0 {
+		return nil, fmt.Errorf("unsupported record to lookup '%s'", ltype)
+	}
+
+	//
+	// Allow reverse lookups of IP addresses.
+	//
+	qname := dns.Fqdn(name)
+	if qtype == dns.TypePTR && net.ParseIP(name) != nil {
+		var err error
+		qname, err = dns.ReverseAddr(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return q.exchange(server, qname, qtype, true)
+}
+
+// records returns the string form of the records of the given type in
+// the response.  Of a TXT record only the first string is returned,
+// unless joinTXT is set.
+func (s *DNSTest) records(r *dns.Msg, qtype uint16, joinTXT bool) []string {
+	var results []string
+
 	for _, entry := range r.Answer {
 
+		//
+		// Skip the records of other types, such as the CNAMEs
+		// which led to the requested records.
+		//
+		if entry.Header().Rrtype != qtype {
+			continue
+		}
+
 		//
 		// Lookup the value
 		//
@@ -69,6 +316,12 @@ func (s *DNSTest) lookup(server string, name string, ltype string, timeout time.
 		case *dns.AAAA:
 			aaaa := ent.AAAA
 			results = append(results, aaaa.String())
+		case *dns.CAA:
+			results = append(results, fmt.Sprintf("%d %s %s", ent.Flag, ent.Tag, ent.Value))
+		case *dns.CNAME:
+			results = append(results, ent.Target)
+		case *dns.DS:
+			results = append(results, fmt.Sprintf("%d %d %d %s", ent.KeyTag, ent.Algorithm, ent.DigestType, strings.ToUpper(ent.Digest)))
 		case *dns.MX:
 			mxName := ent.Mx
 			mxPrio := ent.Preference
@@ -76,56 +329,130 @@ func (s *DNSTest) lookup(server string, name string, ltype string, timeout time.
 		case *dns.NS:
 			nameserver := ent.Ns
 			results = append(results, nameserver)
+		case *dns.PTR:
+			results = append(results, ent.Ptr)
+		case *dns.SOA:
+			results = append(results, fmt.Sprintf("%s %s %d %d %d %d %d", ent.Ns, ent.Mbox, ent.Serial, ent.Refresh, ent.Retry, ent.Expire, ent.Minttl))
+		case *dns.SRV:
+			results = append(results, fmt.Sprintf("%d %d %d %s", ent.Priority, ent.Weight, ent.Port, ent.Target))
 		case *dns.TXT:
-			txt := ent.Txt
-			results = append(results, txt[0])
+			// Long records are split in several strings
+			var txt string
+			if len(ent.Txt) > 0 {
+				txt = ent.Txt[0]
+			}
+			if joinTXT {
+				txt = strings.Join(ent.Txt, "")
+			}
+			results = append(results, txt)
 		}
 	}
-	return results, nil
+	return results
 }
 
-// Given a name & type to lookup perform the request against the named
-// DNS-server.
-func (s *DNSTest) localQuery(server string, qname string, lookupType string) (*dns.Msg, error) {
-
-	// Here we have a map of DNS type-names.
-	var StringToType = map[string]uint16{
-		"A":    dns.TypeA,
-		"AAAA": dns.TypeAAAA,
-		"MX":   dns.TypeMX,
-		"NS":   dns.TypeNS,
-		"TXT":  dns.TypeTXT,
+// checkSerials ensures that all the authoritative nameservers of the
+// zone return the same SOA serial, finding them via the given server.
+func (s *DNSTest) checkSerials(q *dnsQuery, server string, zone string, opts test.Options) error {
+	nameservers, err := s.lookup(q, server, zone, "NS")
+	if err != nil {
+		return err
 	}
 
-	qtype := StringToType[lookupType]
-	if qtype == 0 {
-		return nil, fmt.Errorf("unsupported record to lookup '%s'", lookupType)
+	if len(nameservers) == 0 {
+		return fmt.Errorf("no nameservers found for %s", zone)
 	}
-	localm.SetQuestion(qname, qtype)
+	sort.Strings(nameservers)
 
 	//
-	// Default to connecting to an IPv4-address
+	// The nameservers are queried directly, without encryption.
 	//
-	address := fmt.Sprintf("%s:%d", server, 53)
+	auth := *q
+	if auth.transport == "dot" || auth.transport == "doh" {
+		auth.transport = "udp"
+		auth.port = "53"
+	}
 
-	//
-	// If we find a ":" we know it is an IPv6 address though
-	//
-	if strings.Contains(server, ":") {
-		address = fmt.Sprintf("[%s]:%d", server, 53)
+	serials := make(map[uint32][]string)
+	for _, nameserver := range nameservers {
+		var addresses []string
+		for _, ltype := range s.addressTypes(opts) {
+			found, errLookup := s.lookup(q, server, nameserver, ltype)
+			if errLookup != nil {
+				return errLookup
+			}
+			addresses = append(addresses, found...)
+		}
+		if len(addresses) == 0 {
+			return fmt.Errorf("no addresses found for nameserver %s", nameserver)
+		}
+
+		//
+		// Every address of each nameserver must be in sync.
+		//
+		for _, address := range addresses {
+			r, errSOA := auth.exchange(address, dns.Fqdn(zone), dns.TypeSOA, false)
+			if errSOA != nil {
+				return fmt.Errorf("nameserver %s (%s): %s", nameserver, address, errSOA.Error())
+			}
+
+			var soa *dns.SOA
+			for _, entry := range r.Answer {
+				if found, ok := entry.(*dns.SOA); ok {
+					soa = found
+				}
+			}
+			if soa == nil || !r.Authoritative {
+				return fmt.Errorf("nameserver %s (%s) is not authoritative for %s", nameserver, address, zone)
+			}
+
+			id := fmt.Sprintf("%s (%s)", nameserver, address)
+			serials[soa.Serial] = append(serials[soa.Serial], id)
+		}
 	}
 
-	//
-	// Run the lookup
-	//
-	r, _, err := localc.Exchange(localm, address)
+	if len(serials) > 1 {
+		var found []string
+		for serial, ids := range serials {
+			found = append(found, fmt.Sprintf("%d on %s", serial, strings.Join(ids, ", ")))
+		}
+		sort.Strings(found)
+		return fmt.Errorf("SOA serials of %s differ: %s", zone, strings.Join(found, "; "))
+	}
+
+	return nil
+}
+
+// addressTypes returns the types of the address records to lookup, for
+// the IP-protocols enabled on the worker.
+func (s *DNSTest) addressTypes(opts test.Options) []string {
+	if opts.IPv4 == opts.IPv6 {
+		return []string{"A", "AAAA"}
+	}
+	if opts.IPv6 {
+		return []string{"AAAA"}
+	}
+	return []string{"A"}
+}
+
+// checkDNSSEC validates the records of the response, and ensures that
+// none of the signatures involved expires within the given duration.
+func (s *DNSTest) checkDNSSEC(q *dnsQuery, server string, r *dns.Msg, anchor string, expiry time.Duration) error {
+	v, err := newDNSSECValidator(q, server, anchor)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if err = v.validate(r); err != nil {
+		return fmt.Errorf("DNSSEC validation failed: %s", err.Error())
 	}
-	if r == nil || r.Rcode == dns.RcodeNameError || r.Rcode == dns.RcodeSuccess {
-		return r, err
+
+	if expiry > 0 {
+		sig, when := v.earliestExpiry()
+		if sig != nil && when.Before(time.Now().Add(expiry)) {
+			return fmt.Errorf("RRSIG for %s/%s by %s expires at %s, within %s", sig.Header().Name, dns.TypeToString[sig.TypeCovered], sig.SignerName, when.UTC().Format(time.RFC3339), expiry)
+		}
 	}
-	return nil, nil
+	return nil
 }
 
 // Arguments returns the names of arguments which this protocol-test
@@ -134,9 +461,23 @@ func (s *DNSTest) localQuery(server string, qname string, lookupType string) (*d
 func (s *DNSTest) Arguments() map[string]string {
 
 	known := map[string]string{
-		"type":   "A|AAAA|MX|NS|TXT",
-		"lookup": ".*",
-		"result": ".*",
+		"type":               "^(A|AAAA|CAA|CNAME|DS|MX|NS|PTR|SOA|SRV|TXT)$",
+		"lookup":             ".*",
+		"result":             ".*",
+		"pattern":            ".*",
+		"contains":           ".*",
+		"serial-consistency": "^(true|false)$",
+		"port":               "^[0-9]+$",
+		"tcp":                "^(true|false)$",
+		"edns":               "^[0-9]+$",
+		"transport":          "^(udp|tcp|dot|doh)$",
+		"doh-path":           "^/.*$",
+		"tls":                "insecure",
+		"dnssec":             "^(true|false)$",
+		"trust-anchor":       ".*",
+		"rrsig-expiry":       `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"txt-join":           "^(true|false)$",
+		"fail-on-error":      "^(true|false)$",
 	}
 	return known
 }
@@ -159,11 +500,67 @@ DNS Tester
  This test ensures that the DNS lookup of an A record for 'test.example.com'
  returns the single value 1.2.3.4
 
- Lookups are supported for A, AAAA, MX, NS, and TXT records.  If you expect
- there to be zero returning records, perhaps because you're ensuring that a
- service is IPv4-only you can specify that you require an empty result:
+ Lookups are supported for A, AAAA, CAA, CNAME, DS, MX, NS, PTR, SOA,
+ SRV, and TXT records.  For PTR lookups an IP address can be given, and
+ it will be converted to the matching reverse name.
+
+ Only the first string of a TXT record is compared, unless txt-join
+ is set to join all of them into a single value.  A reply with an
+ error, such as SERVFAIL, is an empty result, unless fail-on-error is
+ set to fail the test instead, as errors always do for the DNSSEC and
+ serial checks:
+
+    ns.example.com must run dns with lookup example.com with type TXT with result 'v=spf1 -all' with txt-join true with fail-on-error true
+
+ If you expect there to be zero returning records, perhaps because you're
+ ensuring that a service is IPv4-only you can specify that you require an
+ empty result:
 
     rache.ns.cloudflare.com must run dns with lookup alert.steve.fi with type AAAA with result ''
+
+ Rather than an exact result, the records can be matched against a
+ regular expression, or checked to include some values:
+
+    ns.example.com must run dns with lookup example.com with type MX with pattern 'mx[0-9]\.example\.com'
+
+    ns.example.com must run dns with lookup example.com with type NS with contains 'ns1.example.com.,ns2.example.com.'
+
+ The SOA serial of a zone can be checked to be identical across all
+ of its authoritative nameservers, which are found via the target:
+
+    8.8.8.8 must run dns with lookup example.com with serial-consistency true
+
+ The nameservers are reached over IPv4 and IPv6, unless either is
+ disabled on the worker.
+
+ Queries can be made over TCP, with EDNS and a custom UDP buffer-size,
+ or to a different port:
+
+    ns.example.com must run dns with lookup example.com with type TXT with result 'v=spf1 -all' with tcp true
+
+    ns.example.com must run dns with lookup example.com with type TXT with result 'v=spf1 -all' with edns 4096
+
+ Queries can also be made over DNS-over-TLS (port 853) or DNS-over-HTTPS
+ (RFC 8484, port 443), validating the certificate of the server against
+ its name:
+
+    dns.google must run dns with lookup example.com with type A with result '93.184.215.14' with transport dot
+
+    dns.google must run dns with lookup example.com with type A with result '93.184.215.14' with transport doh
+
+ DNS-over-HTTPS queries are sent to the /dns-query path, unless another
+ is set via doh-path.  Certificate errors can be ignored via tls insecure.
+
+ The DNSSEC chain of trust of the returned records can be validated, up
+ to the root-zone keys or to a custom trust anchor given as a DS record:
+
+    8.8.8.8 must run dns with lookup example.com with type A with dnssec true
+
+    8.8.8.8 must run dns with lookup example.com with type A with dnssec true with trust-anchor 'example.com. IN DS 370 13 2 BE74359954660069D5C63D200C39F5603827D7DD02B56F120EE9F3A86764247C'
+
+ The test can also fail if any signature of the chain expires soon:
+
+    8.8.8.8 must run dns with lookup example.com with type A with dnssec true with rrsig-expiry 72h
 `
 	return str
 }
@@ -173,38 +570,146 @@ DNS Tester
 //
 // In this case we make a DNS-lookup against the named host, and compare
 // the result with what the user specified.
-// look for a response which appears to be an FTP-server.
 func (s *DNSTest) RunTest(tst test.Test, target string, opts test.Options) error {
 
 	if tst.Arguments["lookup"] == "" {
 		return errors.New("no value to lookup specified")
 	}
-	if tst.Arguments["type"] == "" {
-		return errors.New("no record-type to lookup")
+
+	q := &dnsQuery{
+		port:      "53",
+		transport: "udp",
+		timeout:   opts.Timeout,
+		hostname:  tst.Target,
+		insecure:  tst.Arguments["tls"] == "insecure",
+		dohPath:   "/dns-query",
+	}
+	if tst.Timeout != nil {
+		q.timeout = *tst.Timeout
+	}
+	if tst.Arguments["tcp"] == "true" {
+		q.transport = "tcp"
+	}
+	if tst.Arguments["transport"] != "" {
+		q.transport = tst.Arguments["transport"]
+	}
+	switch q.transport {
+	case "dot":
+		q.port = "853"
+	case "doh":
+		q.port = "443"
+	}
+	if tst.Arguments["port"] != "" {
+		q.port = tst.Arguments["port"]
+	}
+	if tst.Arguments["doh-path"] != "" {
+		q.dohPath = tst.Arguments["doh-path"]
+	}
+	if q.hostname == "" {
+		q.hostname = target
+	}
+	if tst.Arguments["edns"] != "" {
+		size, err := strconv.ParseUint(tst.Arguments["edns"], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid edns buffer-size: %s", err.Error())
+		}
+		q.edns = uint16(size)
 	}
 
+	var expiry time.Duration
+	if tst.Arguments["rrsig-expiry"] != "" {
+		var err error
+		expiry, err = time.ParseDuration(tst.Arguments["rrsig-expiry"])
+		if err != nil {
+			return err
+		}
+	}
+	q.dnssec = tst.Arguments["dnssec"] == "true" || expiry > 0
+
 	//
-	// NOTE:
-	// "result" must also be specified, but it is valid to set that
-	// to be empty.
+	// The checks of DNSSEC and serials can't be made against an
+	// empty result, so an error always fails them.
 	//
+	q.failOnError = tst.Arguments["fail-on-error"] == "true" || q.dnssec || tst.Arguments["serial-consistency"] == "true"
+
+	if tst.Arguments["serial-consistency"] == "true" {
+		return s.checkSerials(q, target, tst.Arguments["lookup"], opts)
+	}
+
+	if tst.Arguments["type"] == "" {
+		return errors.New("no record-type to lookup")
+	}
 
 	//
 	// Run the lookup
 	//
-	res, err := s.lookup(target, tst.Arguments["lookup"], tst.Arguments["type"], opts.Timeout)
+	r, err := s.query(q, target, tst.Arguments["lookup"], tst.Arguments["type"])
 	if err != nil {
 		return err
 	}
+	res := s.records(r, dnsTypes[tst.Arguments["type"]], tst.Arguments["txt-join"] == "true")
 
 	//
-	// If the results differ that's an error
+	// Validate the chain of trust of the records.
+	//
+	if q.dnssec {
+		if err = s.checkDNSSEC(q, target, r, tst.Arguments["trust-anchor"], expiry); err != nil {
+			return err
+		}
+	}
+
 	//
 	// Sort the results and comma-join for comparison
 	//
 	sort.Strings(res)
 	found := strings.Join(res, ",")
 
+	if opts.Verbose {
+		fmt.Printf("\tDNS result: '%s'\n", found)
+	}
+
+	//
+	// Is the user looking for records matching a regular expression?
+	//
+	if tst.Arguments["pattern"] != "" {
+		re, errCompile := regexp.Compile(tst.Arguments["pattern"])
+		if errCompile != nil {
+			return errCompile
+		}
+		if !re.MatchString(found) {
+			return fmt.Errorf("DNS result '%s' didn't match the regular expression '%s'", found, tst.Arguments["pattern"])
+		}
+	}
+
+	//
+	// Is the user looking for some records to be present?
+	//
+	if tst.Arguments["contains"] != "" {
+		for _, expected := range strings.Split(tst.Arguments["contains"], ",") {
+			present := false
+			for _, record := range res {
+				if record == expected {
+					present = true
+				}
+			}
+			if !present {
+				return fmt.Errorf("expected DNS result to contain '%s', but found '%s'", expected, found)
+			}
+		}
+	}
+
+	//
+	// NOTE:
+	// "result" must be specified, unless another matching mode was
+	// used, but it is valid to set it to be empty.
+	//
+	if _, ok := tst.Arguments["result"]; !ok && (tst.Arguments["pattern"] != "" || tst.Arguments["contains"] != "") {
+		return nil
+	}
+
+	//
+	// If the results differ that's an error
+	//
 	if found != tst.Arguments["result"] {
 		return fmt.Errorf("expected DNS result to be '%s', but found '%s'", tst.Arguments["result"], found)
 	}
@@ -0,0 +1,433 @@
+package protocols
+
+import (
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/miekg/dns"
+)
+
+// testZone contains the records served by our DNS stand-in.
+const testZone = `
+example.com.          300 IN SOA   ns1.example.com. hostmaster.example.com. 2024010101 3600 600 86400 300
+example.com.          300 IN NS    ns1.example.com.
+example.com.          300 IN NS    ns2.example.com.
+example.com.          300 IN MX    10 mx1.example.com.
+example.com.          300 IN MX    20 mx2.example.com.
+example.com.          300 IN TXT   "v=spf1 " "-all"
+example.com.          300 IN CAA   0 issue "letsencrypt.org"
+example.com.          300 IN DS    12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF
+www.example.com.      300 IN CNAME web.example.com.
+web.example.com.      300 IN A     192.0.2.10
+ns1.example.com.      300 IN A     127.0.0.1
+ns2.example.com.      300 IN A     127.0.0.2
+_sip._tcp.example.com. 300 IN SRV  10 60 5060 sip.example.com.
+10.2.0.192.in-addr.arpa. 300 IN PTR web.example.com.
+stale.example.com.    300 IN NS    ns1.example.com.
+stale.example.com.    300 IN NS    ns2.example.com.
+`
+
+// testZoneHandler returns a handler serving testZone.  The SOA of
+// stale.example.com has a different serial on 127.0.0.2.
+func testZoneHandler(t *testing.T) dns.Handler {
+	names := make(map[string]bool)
+	records := make(map[string][]dns.RR)
+	parser := dns.NewZoneParser(strings.NewReader(testZone), "", "")
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		key := fmt.Sprintf("%s/%d", rr.Header().Name, rr.Header().Rrtype)
+		records[key] = append(records[key], rr)
+		names[rr.Header().Name] = true
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("Error parsing zone: %s", err.Error())
+	}
+
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+
+		q := r.Question[0]
+		if !names[q.Name] {
+			m.Rcode = dns.RcodeNameError
+		}
+
+		switch {
+		case q.Name == "broken.example.com.":
+			m.Rcode = dns.RcodeServerFailure
+		case q.Name == "stale.example.com." && q.Qtype == dns.TypeSOA:
+			serial := 1
+			if local, _, _ := net.SplitHostPort(w.LocalAddr().String()); local == "127.0.0.2" {
+				serial = 2
+			}
+			soa, _ := dns.NewRR(fmt.Sprintf("stale.example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. %d 3600 600 86400 300", serial))
+			m.Answer = append(m.Answer, soa)
+		case q.Qtype != dns.TypeCNAME && len(records[fmt.Sprintf("%s/%d", q.Name, dns.TypeCNAME)]) > 0:
+			// Follow the CNAME, like a resolver
+			cname := records[fmt.Sprintf("%s/%d", q.Name, dns.TypeCNAME)][0]
+			m.Answer = append(m.Answer, cname)
+			m.Answer = append(m.Answer, records[fmt.Sprintf("%s/%d", cname.(*dns.CNAME).Target, q.Qtype)]...)
+		default:
+			m.Answer = append(m.Answer, records[fmt.Sprintf("%s/%d", q.Name, q.Qtype)]...)
+		}
+
+		w.WriteMsg(m)
+	})
+}
+
+// startDNSServer starts a DNS stand-in on the given address, over UDP
+// and TCP, serving testZone.
+//
+// The port the stand-in listens on is returned, along with a function
+// to shut it down.
+func startDNSServer(t *testing.T, host string, port string) (string, func()) {
+	return serveDNS(t, host, port, testZoneHandler(t))
+}
+
+// serveDNS serves the handler on the given address, over UDP and TCP.
+func serveDNS(t *testing.T, host string, port string, handler dns.Handler) (string, func()) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		t.Fatalf("Error starting DNS server: %s", err.Error())
+	}
+	_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		conn.Close()
+		t.Fatalf("Error starting DNS server: %s", err.Error())
+	}
+
+	servers := []*dns.Server{
+		{PacketConn: conn, Handler: handler},
+		{Listener: listener, Handler: handler},
+	}
+	for _, server := range servers {
+		started := make(chan bool)
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+	}
+
+	return port, func() {
+		for _, server := range servers {
+			server.Shutdown()
+		}
+	}
+}
+
+// Test the DNS-probe against our stand-in
+func TestDNS(t *testing.T) {
+	port, shutdown := startDNSServer(t, "127.0.0.1", "0")
+	defer shutdown()
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{"lookup": "example.com", "type": "MX", "result": "10 mx1.example.com.,20 mx2.example.com."}, true},
+		{map[string]string{"lookup": "example.com", "type": "MX", "result": "10 mx1.example.com."}, false},
+		{map[string]string{"lookup": "example.com", "type": "TXT", "result": "v=spf1 "}, true},
+		{map[string]string{"lookup": "example.com", "type": "TXT", "result": "v=spf1 -all"}, false},
+		{map[string]string{"lookup": "example.com", "type": "TXT", "result": "v=spf1 -all", "txt-join": "true"}, true},
+		{map[string]string{"lookup": "example.com", "type": "TXT", "result": "v=spf1 -all", "txt-join": "true", "tcp": "true"}, true},
+		{map[string]string{"lookup": "example.com", "type": "TXT", "result": "v=spf1 -all", "txt-join": "true", "edns": "4096"}, true},
+		{map[string]string{"lookup": "broken.example.com", "type": "A", "result": ""}, true},
+		{map[string]string{"lookup": "broken.example.com", "type": "A", "result": "", "fail-on-error": "true"}, false},
+		{map[string]string{"lookup": "example.com", "type": "CAA", "result": "0 issue letsencrypt.org"}, true},
+		{map[string]string{"lookup": "example.com", "type": "DS", "pattern": "^12345 13 2 "}, true},
+		{map[string]string{"lookup": "example.com", "type": "SOA", "pattern": " 2024010101 "}, true},
+		{map[string]string{"lookup": "www.example.com", "type": "CNAME", "result": "web.example.com."}, true},
+		{map[string]string{"lookup": "www.example.com", "type": "A", "result": "192.0.2.10"}, true},
+		{map[string]string{"lookup": "_sip._tcp.example.com", "type": "SRV", "result": "10 60 5060 sip.example.com."}, true},
+		{map[string]string{"lookup": "192.0.2.10", "type": "PTR", "result": "web.example.com."}, true},
+		{map[string]string{"lookup": "example.com", "type": "NS", "contains": "ns2.example.com."}, true},
+		{map[string]string{"lookup": "example.com", "type": "NS", "contains": "ns1.example.com.,ns3.example.com."}, false},
+		{map[string]string{"lookup": "example.com", "type": "MX", "pattern": "mx[0-9]\\.example\\.org"}, false},
+		{map[string]string{"lookup": "missing.example.com", "type": "A", "result": ""}, false},
+		{map[string]string{"lookup": "example.com", "type": "AAAA", "result": ""}, true},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["port"] = port
+
+		s := &DNSTest{}
+		err := s.RunTest(test.Test{Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+	}
+}
+
+// Test the SOA-serial consistency check, with two nameservers
+func TestDNSSerialConsistency(t *testing.T) {
+	port, shutdown := startDNSServer(t, "127.0.0.1", "0")
+	defer shutdown()
+
+	_, shutdownSecond := startDNSServer(t, "127.0.0.2", port)
+	defer shutdownSecond()
+
+	// The nameservers only have IPv4 addresses
+	tests := []struct {
+		Zone  string
+		IPv4  bool
+		IPv6  bool
+		Valid bool
+	}{
+		{"example.com", false, false, true},
+		{"example.com", true, true, true},
+		{"example.com", true, false, true},
+		{"example.com", false, true, false},
+		{"stale.example.com", false, false, false},
+	}
+
+	for _, tst := range tests {
+		s := &DNSTest{}
+		err := s.RunTest(test.Test{Arguments: map[string]string{
+			"lookup":             tst.Zone,
+			"serial-consistency": "true",
+			"port":               port,
+		}}, "127.0.0.1", test.Options{Timeout: time.Second, IPv4: tst.IPv4, IPv6: tst.IPv6})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected zone %s to be consistent over %v, got error: %s", tst.Zone, s.addressTypes(test.Options{IPv4: tst.IPv4, IPv6: tst.IPv6}), err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected zone %s over %v to fail", tst.Zone, s.addressTypes(test.Options{IPv4: tst.IPv4, IPv6: tst.IPv6}))
+		}
+	}
+}
+
+// dohResponseWriter collects the reply of a handler to a DNS-over-HTTPS
+// query.
+type dohResponseWriter struct {
+	dns.ResponseWriter
+	reply *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.reply = m
+	return nil
+}
+
+// Test DNS-over-TLS and DNS-over-HTTPS, against stand-ins with a
+// self-signed certificate
+func TestDNSEncrypted(t *testing.T) {
+	handler := testZoneHandler(t)
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path != "/dns-query" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		m := new(dns.Msg)
+		if err := m.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rw := &dohResponseWriter{}
+		handler.ServeDNS(rw, m)
+
+		reply, _ := rw.reply.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(reply)
+	}))
+	defer doh.Close()
+	_, dohPort, _ := net.SplitHostPort(doh.Listener.Addr().String())
+
+	//
+	// DNS-over-TLS uses the certificate of the DNS-over-HTTPS stand-in.
+	//
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", doh.TLS)
+	if err != nil {
+		t.Fatalf("Error starting DNS-over-TLS server: %s", err.Error())
+	}
+	dot := &dns.Server{Listener: listener, Handler: handler}
+	started := make(chan bool)
+	dot.NotifyStartedFunc = func() { close(started) }
+	go dot.ActivateAndServe()
+	<-started
+	defer dot.Shutdown()
+	_, dotPort, _ := net.SplitHostPort(listener.Addr().String())
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{"transport": "dot", "port": dotPort, "tls": "insecure"}, true},
+		{map[string]string{"transport": "dot", "port": dotPort}, false},
+		{map[string]string{"transport": "doh", "port": dohPort, "tls": "insecure"}, true},
+		{map[string]string{"transport": "doh", "port": dohPort}, false},
+		{map[string]string{"transport": "doh", "port": dohPort, "tls": "insecure", "doh-path": "/missing"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["lookup"] = "www.example.com"
+		tst.Arguments["type"] = "A"
+		tst.Arguments["result"] = "192.0.2.10"
+
+		s := &DNSTest{}
+		err = s.RunTest(test.Test{Target: "dns.example.com", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+	}
+}
+
+// signedZone holds the records and signatures served by a DNSSEC-signed
+// stand-in, for the zones example. and sub.example.
+type signedZone struct {
+	records map[string][]dns.RR
+	anchor  string
+}
+
+// newSignedZone signs the records of example. and sub.example., with
+// keys generated on the fly.
+func newSignedZone(t *testing.T) *signedZone {
+	z := &signedZone{records: make(map[string][]dns.RR)}
+
+	add := func(text string) dns.RR {
+		rr, err := dns.NewRR(text)
+		if err != nil {
+			t.Fatalf("Error parsing record: %s", err.Error())
+		}
+		key := fmt.Sprintf("%s/%d", rr.Header().Name, rr.Header().Rrtype)
+		z.records[key] = append(z.records[key], rr)
+		return rr
+	}
+
+	type zoneKey struct {
+		key    *dns.DNSKEY
+		signer crypto.Signer
+	}
+	keys := make(map[string]zoneKey)
+	for _, zone := range []string{"example.", "sub.example."} {
+		key := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		private, err := key.Generate(256)
+		if err != nil {
+			t.Fatalf("Error generating key: %s", err.Error())
+		}
+		keys[zone] = zoneKey{key, private.(crypto.Signer)}
+		z.records[fmt.Sprintf("%s/%d", zone, dns.TypeDNSKEY)] = []dns.RR{key}
+	}
+
+	z.anchor = keys["example."].key.ToDS(dns.SHA256).String()
+	z.records[fmt.Sprintf("sub.example./%d", dns.TypeDS)] = []dns.RR{keys["sub.example."].key.ToDS(dns.SHA256)}
+
+	add("www.sub.example. 300 IN A 192.0.2.1")
+	add("soon.sub.example. 300 IN A 192.0.2.2")
+	add("bogus.sub.example. 300 IN A 192.0.2.3")
+	add("unsigned.sub.example. 300 IN A 192.0.2.4")
+
+	sign := func(name string, rrtype uint16, zone string, expiry time.Duration) {
+		key := keys[zone]
+		sig := &dns.RRSIG{
+			Algorithm:  key.key.Algorithm,
+			KeyTag:     key.key.KeyTag(),
+			SignerName: zone,
+			Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+			Expiration: uint32(time.Now().Add(expiry).Unix()),
+		}
+		rrset := z.records[fmt.Sprintf("%s/%d", name, rrtype)]
+		if err := sig.Sign(key.signer, rrset); err != nil {
+			t.Fatalf("Error signing %s: %s", name, err.Error())
+		}
+		z.records[fmt.Sprintf("%s/%d", name, dns.TypeRRSIG)] = append(z.records[fmt.Sprintf("%s/%d", name, dns.TypeRRSIG)], sig)
+	}
+
+	month := 30 * 24 * time.Hour
+	sign("example.", dns.TypeDNSKEY, "example.", month)
+	sign("sub.example.", dns.TypeDS, "example.", month)
+	sign("sub.example.", dns.TypeDNSKEY, "sub.example.", month)
+	sign("www.sub.example.", dns.TypeA, "sub.example.", month)
+	sign("soon.sub.example.", dns.TypeA, "sub.example.", time.Hour)
+	sign("bogus.sub.example.", dns.TypeA, "sub.example.", month)
+
+	// Tamper with the record after signing
+	z.records[fmt.Sprintf("bogus.sub.example./%d", dns.TypeA)][0].(*dns.A).A = net.ParseIP("192.0.2.33")
+
+	return z
+}
+
+// ServeDNS answers with the records of the given type and their
+// signatures.
+func (z *signedZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	q := r.Question[0]
+	m.Answer = append(m.Answer, z.records[fmt.Sprintf("%s/%d", q.Name, q.Qtype)]...)
+	for _, rr := range z.records[fmt.Sprintf("%s/%d", q.Name, dns.TypeRRSIG)] {
+		if rr.(*dns.RRSIG).TypeCovered == q.Qtype {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+
+	w.WriteMsg(m)
+}
+
+// Test the DNSSEC validation against a signed stand-in
+func TestDNSSEC(t *testing.T) {
+	zone := newSignedZone(t)
+	port, shutdown := serveDNS(t, "127.0.0.1", "0", zone)
+	defer shutdown()
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{"lookup": "www.sub.example", "trust-anchor": zone.anchor}, true},
+		{map[string]string{"lookup": "www.sub.example", "trust-anchor": zone.anchor, "rrsig-expiry": "72h"}, true},
+		{map[string]string{"lookup": "soon.sub.example", "trust-anchor": zone.anchor}, true},
+		{map[string]string{"lookup": "soon.sub.example", "trust-anchor": zone.anchor, "rrsig-expiry": "72h"}, false},
+		{map[string]string{"lookup": "bogus.sub.example", "trust-anchor": zone.anchor}, false},
+		{map[string]string{"lookup": "unsigned.sub.example", "trust-anchor": zone.anchor}, false},
+		{map[string]string{"lookup": "www.sub.example", "trust-anchor": "example. IN DS 1 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"}, false},
+		{map[string]string{"lookup": "www.sub.example"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["type"] = "A"
+		tst.Arguments["pattern"] = "."
+		tst.Arguments["dnssec"] = "true"
+		tst.Arguments["port"] = port
+
+		s := &DNSTest{}
+		err := s.RunTest(test.Test{Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnssecRootAnchors are the DS records of the root-zone KSKs, which are
+// trusted by default.
+var dnssecRootAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// dnssecValidator validates the DNSSEC chain of trust of the records
+// returned by a DNS-server, from their zone up to a trust anchor.
+//
+// All the queries are made via the same server, with checking disabled
+// so that we get to see the bogus records too.
+type dnssecValidator struct {
+	query   *dnsQuery
+	server  string
+	anchors []*dns.DS
+
+	// The validated DNSKEY records, by zone.
+	keys map[string][]*dns.DNSKEY
+
+	// All the signatures which have been validated.
+	signatures []*dns.RRSIG
+}
+
+// newDNSSECValidator returns a validator trusting the given anchor, in
+// the presentation format of a DS record, or the root KSKs if empty.
+func newDNSSECValidator(query *dnsQuery, server string, anchor string) (*dnssecValidator, error) {
+	v := &dnssecValidator{
+		query:  query,
+		server: server,
+		keys:   make(map[string][]*dns.DNSKEY),
+	}
+
+	anchors := dnssecRootAnchors
+	if anchor != "" {
+		anchors = []string{anchor}
+	}
+	for _, text := range anchors {
+		rr, err := dns.NewRR(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor '%s': %s", text, err.Error())
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("invalid trust anchor '%s': not a DS record", text)
+		}
+		v.anchors = append(v.anchors, ds)
+	}
+
+	return v, nil
+}
+
+// validate validates all the records in the answer of the given response.
+func (v *dnssecValidator) validate(r *dns.Msg) error {
+	if len(r.Answer) == 0 {
+		return errors.New("no records to validate")
+	}
+
+	//
+	// Group the answer in RRsets, along with their signatures.
+	//
+	var keys []string
+	rrsets := make(map[string][]dns.RR)
+	sigs := make(map[string][]*dns.RRSIG)
+	for _, rr := range r.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := fmt.Sprintf("%s/%s", strings.ToLower(sig.Header().Name), dns.TypeToString[sig.TypeCovered])
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s", strings.ToLower(rr.Header().Name), dns.TypeToString[rr.Header().Rrtype])
+		if rrsets[key] == nil {
+			keys = append(keys, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	for _, key := range keys {
+		if err := v.verify(rrsets[key], sigs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks that the RRset has a valid signature, made by a key of
+// a trusted zone.
+func (v *dnssecValidator) verify(rrset []dns.RR, sigs []*dns.RRSIG) error {
+	name := rrset[0].Header().Name
+	rrtype := dns.TypeToString[rrset[0].Header().Rrtype]
+
+	if len(sigs) == 0 {
+		return fmt.Errorf("no RRSIG found for %s/%s", name, rrtype)
+	}
+
+	err := fmt.Errorf("no valid RRSIG found for %s/%s", name, rrtype)
+	for _, sig := range sigs {
+		if !dns.IsSubDomain(sig.SignerName, name) {
+			continue
+		}
+
+		keys, errKeys := v.zoneKeys(sig.SignerName)
+		if errKeys != nil {
+			return errKeys
+		}
+
+		if errSig := v.check(sig, keys, rrset); errSig != nil {
+			err = fmt.Errorf("invalid RRSIG for %s/%s: %s", name, rrtype, errSig.Error())
+			continue
+		}
+		return nil
+	}
+	return err
+}
+
+// check verifies the signature of the RRset with the matching key.
+func (v *dnssecValidator) check(sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, rrset); err != nil {
+			return err
+		}
+		if !sig.ValidityPeriod(time.Now()) {
+			return fmt.Errorf("signature is not valid between %s and %s", dns.TimeToString(sig.Inception), dns.TimeToString(sig.Expiration))
+		}
+		v.signatures = append(v.signatures, sig)
+		return nil
+	}
+	return fmt.Errorf("no DNSKEY found with key-tag %d", sig.KeyTag)
+}
+
+// zoneKeys returns the DNSKEY records of the given zone, once they've
+// been validated via the DS records of the parent zone, or via one of
+// our trust anchors.
+func (v *dnssecValidator) zoneKeys(zone string) ([]*dns.DNSKEY, error) {
+	zone = dns.Fqdn(strings.ToLower(zone))
+	if keys, ok := v.keys[zone]; ok {
+		return keys, nil
+	}
+
+	r, err := v.query.exchange(v.server, zone, dns.TypeDNSKEY, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*dns.DNSKEY
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range r.Answer {
+		switch record := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, record)
+			rrset = append(rrset, record)
+		case *dns.RRSIG:
+			if record.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, record)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no DNSKEY found for %s", zone)
+	}
+
+	//
+	// The DNSKEY RRset must be signed by one of its own keys, which
+	// are those which can be trusted via a DS record.
+	//
+	var signers []*dns.DNSKEY
+	for _, sig := range sigs {
+		if v.check(sig, keys, rrset) != nil {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm {
+				signers = append(signers, key)
+			}
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no valid RRSIG found for %s/DNSKEY", zone)
+	}
+
+	//
+	// Is this zone trusted directly?
+	//
+	if v.matchDS(zone, v.anchors, signers) {
+		v.keys[zone] = keys
+		return keys, nil
+	}
+	if zone == "." {
+		return nil, errors.New("no DNSKEY of the root zone matches the trust anchors")
+	}
+
+	//
+	// Otherwise the parent must vouch for the keys via signed DS records.
+	//
+	r, err = v.query.exchange(v.server, zone, dns.TypeDS, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var dsset []dns.RR
+	var delegations []*dns.DS
+	var dssigs []*dns.RRSIG
+	for _, rr := range r.Answer {
+		switch record := rr.(type) {
+		case *dns.DS:
+			dsset = append(dsset, record)
+			delegations = append(delegations, record)
+		case *dns.RRSIG:
+			if record.TypeCovered == dns.TypeDS && !strings.EqualFold(record.SignerName, zone) {
+				dssigs = append(dssigs, record)
+			}
+		}
+	}
+	if len(dsset) == 0 {
+		return nil, fmt.Errorf("no DS found for %s, the delegation is insecure", zone)
+	}
+
+	if err = v.verify(dsset, dssigs); err != nil {
+		return nil, err
+	}
+
+	if !v.matchDS(zone, delegations, signers) {
+		return nil, fmt.Errorf("no DNSKEY of %s matches its DS records", zone)
+	}
+
+	v.keys[zone] = keys
+	return keys, nil
+}
+
+// matchDS returns true if one of the keys matches one of the DS records.
+func (v *dnssecValidator) matchDS(zone string, delegations []*dns.DS, keys []*dns.DNSKEY) bool {
+	for _, ds := range delegations {
+		if !strings.EqualFold(dns.Fqdn(ds.Header().Name), zone) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			digest := key.ToDS(ds.DigestType)
+			if digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// earliestExpiry returns the validated signature which expires first.
+func (v *dnssecValidator) earliestExpiry() (*dns.RRSIG, time.Time) {
+	var earliest *dns.RRSIG
+	var expiry time.Time
+	for _, sig := range v.signatures {
+		t := dnssecTime(sig.Expiration)
+		if earliest == nil || t.Before(expiry) {
+			earliest = sig
+			expiry = t
+		}
+	}
+	return earliest, expiry
+}
+
+// dnssecTime converts the serial-arithmetic time of an RRSIG to a time,
+// in the same way as RRSIG.ValidityPeriod.
+func dnssecTime(value uint32) time.Time {
+	const year68 = 1 << 31
+	now := time.Now().UTC().Unix()
+	seconds := int64(value) + ((int64(value)-now)/year68)*year68
+	return time.Unix(seconds, 0)
+}
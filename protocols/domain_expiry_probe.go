@@ -0,0 +1,296 @@
+// Domain Expiry Tester
+//
+// The domain-expiry tester checks that the registration of a domain is
+// not about to lapse, via the RDAP service of its registry.
+//
+// This test is invoked via input like so:
+//
+//    example.com must run domain-expiry
+//
+// The target must be the registered domain, rather than a name within
+// it.  The test fails if the registration expires within the next 30
+// days, or if the domain is on hold, in its redemption period, or
+// pending deletion.  A different period can be given in days, or hours:
+//
+//    example.com must run domain-expiry with expiration 60d
+//
+// The RDAP service is found via a list of the common TLDs, falling back
+// to the rdap.org redirector.  A copy of the bootstrap file of IANA,
+// from https://data.iana.org/rdap/dns.json, can be used instead:
+//
+//    example.com must run domain-expiry with bootstrap /etc/overseer/dns.json
+//
+// Or the base URL of the RDAP service to query can be set directly:
+//
+//    example.com must run domain-expiry with rdap-url 'https://rdap.verisign.com/com/v1/'
+//
+
+package protocols
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// DomainExpiryTest is our object.
+type DomainExpiryTest struct {
+}
+
+// rdapBadStatuses are the statuses of a domain, as registered for RDAP
+// in RFC 8056, which mean that it is lapsing.
+var rdapBadStatuses = []string{
+	"client hold",
+	"server hold",
+	"redemption period",
+	"pending restore",
+	"pending delete",
+}
+
+// rdapDomain is the part of an RDAP domain object we care about.
+type rdapDomain struct {
+	LDHName string   `json:"ldhName"`
+	Status  []string `json:"status"`
+	Events  []struct {
+		Action string `json:"eventAction"`
+		Date   string `json:"eventDate"`
+	} `json:"events"`
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *DomainExpiryTest) Arguments() map[string]string {
+	known := map[string]string{
+		"expiration": "^([0-9]+[hd]?)$",
+		"bootstrap":  ".*",
+		"rdap-url":   "^https?://.*$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *DomainExpiryTest) ShouldResolveHostname() bool {
+	return false
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *DomainExpiryTest) Example() string {
+	str := `
+Domain Expiry Tester
+--------------------
+ The domain-expiry tester checks that the registration of a domain is
+ not about to lapse, via the RDAP service of its registry.
+
+ This test is invoked via input like so:
+
+    example.com must run domain-expiry
+
+ The target must be the registered domain, rather than a name within
+ it.  The test fails if the registration expires within the next 30
+ days, or if the domain is on hold, in its redemption period, or
+ pending deletion.  A different period can be given in days, or hours:
+
+    example.com must run domain-expiry with expiration 60d
+
+ The RDAP service is found via a list of the common TLDs, falling back
+ to the rdap.org redirector.  A copy of the bootstrap file of IANA,
+ from https://data.iana.org/rdap/dns.json, can be used instead:
+
+    example.com must run domain-expiry with bootstrap /etc/overseer/dns.json
+
+ Or the base URL of the RDAP service to query can be set directly:
+
+    example.com must run domain-expiry with rdap-url 'https://rdap.verisign.com/com/v1/'
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+func (s *DomainExpiryTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// expiry date and statuses of the domain alongside the result.
+//
+// In this case we look the domain up via RDAP, and check its expiration
+// event and statuses.
+func (s *DomainExpiryTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
+	domain := strings.ToLower(strings.TrimSuffix(tst.Target, "."))
+	if domain == "" {
+		domain = strings.ToLower(strings.TrimSuffix(target, "."))
+	}
+
+	//
+	// The expiration period, in days unless stated.
+	//
+	expire := tst.Arguments["expiration"]
+	if expire == "" {
+		expire = "30d"
+	}
+	if !strings.HasSuffix(expire, "h") && !strings.HasSuffix(expire, "d") {
+		expire += "d"
+	}
+	period, err := parseExpirationPeriod(expire)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := s.baseURL(domain, tst.Arguments["rdap-url"], tst.Arguments["bootstrap"])
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	info, err := s.lookup(base, domain, timeout, opts.Verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	var expiry time.Time
+	for _, event := range info.Events {
+		if event.Action == "expiration" {
+			expiry, err = time.Parse(time.RFC3339, event.Date)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiration date '%s': %s", event.Date, err.Error())
+			}
+		}
+	}
+
+	status := strings.Join(info.Status, ", ")
+	details := fmt.Sprintf("status: %s", status)
+	if !expiry.IsZero() {
+		details = fmt.Sprintf("expiry: %s, status: %s", expiry.UTC().Format(time.RFC3339), status)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tRDAP %s: %s\n", domain, details)
+	}
+
+	for _, found := range info.Status {
+		for _, bad := range rdapBadStatuses {
+			if strings.EqualFold(found, bad) {
+				return &details, fmt.Errorf("domain %s has the status '%s'", domain, found)
+			}
+		}
+	}
+
+	if expiry.IsZero() {
+		return &details, fmt.Errorf("no expiration date found for domain %s", domain)
+	}
+
+	hours := int64(time.Until(expiry).Hours())
+	if hours < period {
+		return &details, fmt.Errorf("domain %s will expire in %d hours (%d days)", domain, hours, int(hours/24))
+	}
+
+	return &details, nil
+}
+
+// baseURL returns the base URL of the RDAP service for the domain.
+func (s *DomainExpiryTest) baseURL(domain string, rdapURL string, bootstrapFile string) (string, error) {
+	if rdapURL != "" {
+		return rdapURL, nil
+	}
+
+	bootstrap := rdapBootstrap
+	if bootstrapFile != "" {
+		var err error
+		bootstrap, err = loadRDAPBootstrap(bootstrapFile)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	//
+	// Find the longest matching entry, as some registries serve
+	// second-level domains too.
+	//
+	labels := strings.Split(domain, ".")
+	for i := 1; i < len(labels); i++ {
+		if base, ok := bootstrap[strings.Join(labels[i:], ".")]; ok {
+			return base, nil
+		}
+	}
+
+	if bootstrapFile != "" {
+		return "", fmt.Errorf("no RDAP service found for %s in %s", domain, bootstrapFile)
+	}
+	return rdapFallback, nil
+}
+
+// lookup fetches the RDAP domain object from the given service.
+func (s *DomainExpiryTest) lookup(base string, domain string, timeout time.Duration, verbose bool) (*rdapDomain, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/domain/" + domain
+
+	if verbose {
+		fmt.Printf("\tRDAP query: %s\n", u.String())
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("domain %s is not registered", domain)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RDAP query for %s failed with status code %d", domain, response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &rdapDomain{}
+	if err = json.Unmarshal(body, info); err != nil {
+		return nil, fmt.Errorf("invalid RDAP response: %s", err.Error())
+	}
+	if info.LDHName == "" {
+		return nil, errors.New("invalid RDAP response: not a domain object")
+	}
+	return info, nil
+}
+
+func (s *DomainExpiryTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("domain-expiry", func() ProtocolTest {
+		return &DomainExpiryTest{}
+	})
+}
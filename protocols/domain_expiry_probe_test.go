@@ -0,0 +1,120 @@
+package protocols
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// startRDAPServer starts an RDAP stand-in, serving a few domains which
+// expire at different times.
+func startRDAPServer() *httptest.Server {
+	domains := map[string]string{
+		"example.test":   `{"ldhName": "example.test", "status": ["active"], "events": [{"eventAction": "expiration", "eventDate": "%s"}]}`,
+		"soon.test":      `{"ldhName": "soon.test", "status": ["client transfer prohibited"], "events": [{"eventAction": "registration", "eventDate": "2000-01-01T00:00:00Z"}, {"eventAction": "expiration", "eventDate": "%s"}]}`,
+		"held.test":      `{"ldhName": "held.test", "status": ["client hold"], "events": [{"eventAction": "expiration", "eventDate": "%s"}]}`,
+		"redeeming.test": `{"ldhName": "redeeming.test", "status": ["redemption period"], "events": [{"eventAction": "expiration", "eventDate": "%s"}]}`,
+	}
+	expiries := map[string]time.Duration{
+		"example.test":   365 * 24 * time.Hour,
+		"soon.test":      10 * 24 * time.Hour,
+		"held.test":      365 * 24 * time.Hour,
+		"redeeming.test": -10 * 24 * time.Hour,
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domain := strings.TrimPrefix(r.URL.Path, "/rdap/domain/")
+		body, ok := domains[domain]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		fmt.Fprintf(w, body, time.Now().Add(expiries[domain]).UTC().Format(time.RFC3339))
+	}))
+}
+
+// Test the domain-expiry probe against our stand-in
+func TestDomainExpiry(t *testing.T) {
+	server := startRDAPServer()
+	defer server.Close()
+
+	base := server.URL + "/rdap/"
+
+	tests := []struct {
+		Domain    string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{"example.test", map[string]string{}, true},
+		{"example.test", map[string]string{"expiration": "400"}, false},
+		{"soon.test", map[string]string{}, false},
+		{"soon.test", map[string]string{"expiration": "7d"}, true},
+		{"soon.test", map[string]string{"expiration": "240h"}, false},
+		{"held.test", map[string]string{}, false},
+		{"redeeming.test", map[string]string{}, false},
+		{"missing.test", map[string]string{}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["rdap-url"] = base
+
+		s := &DomainExpiryTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: tst.Domain, Arguments: tst.Arguments}, "", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.Domain, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.Domain, tst.Arguments)
+		}
+		if tst.Valid && (details == nil || !strings.HasPrefix(*details, "expiry: ")) {
+			t.Errorf("Unexpected details for %s: %v", tst.Domain, details)
+		}
+	}
+}
+
+// Test finding the RDAP service via a bootstrap file
+func TestDomainExpiryBootstrap(t *testing.T) {
+	server := startRDAPServer()
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "overseer-rdap")
+	if err != nil {
+		t.Fatalf("Error creating bootstrap file: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	fmt.Fprintf(file, `{"version": "1.0", "services": [[["other"], ["https://rdap.example.org/"]], [["test", "example"], ["http://%s/rdap/"]]]}`, server.Listener.Addr().String())
+	file.Close()
+
+	s := &DomainExpiryTest{}
+	err = s.RunTest(test.Test{Target: "example.test", Arguments: map[string]string{"bootstrap": file.Name()}}, "", test.Options{Timeout: time.Second})
+	if err != nil {
+		t.Errorf("Expected the bootstrap lookup to pass, got error: %s", err.Error())
+	}
+
+	err = s.RunTest(test.Test{Target: "example.unknown", Arguments: map[string]string{"bootstrap": file.Name()}}, "", test.Options{Timeout: time.Second})
+	if err == nil {
+		t.Errorf("Expected a TLD missing from the bootstrap file to fail")
+	}
+
+	//
+	// The shipped list falls back to rdap.org.
+	//
+	base, _ := s.baseURL("example.unknown", "", "")
+	if base != rdapFallback {
+		t.Errorf("Expected the fallback service, got %s", base)
+	}
+	base, _ = s.baseURL("www.example.co.uk", "", "")
+	if base != rdapBootstrap["uk"] {
+		t.Errorf("Expected the service of .uk, got %s", base)
+	}
+}
@@ -0,0 +1,311 @@
+// Exec Tester
+//
+// The exec tester runs a Nagios/Icinga-compatible plugin, and maps its
+// exit-code to the result of the test.
+//
+// Only the plugins found in the directory configured on the worker, via
+// the `-exec-plugin-dir` flag, can be run.  If the flag is not set, the
+// exec tester is disabled.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run exec with command check_http with args '-H $HOSTNAME$ -I $HOSTADDRESS$ -S'
+//
+// Arguments are split on whitespace, unless double-quoted, and support
+// the following macros:
+//
+//    $HOSTADDRESS$ - the resolved IP address of the target
+//    $HOSTNAME$    - the target, as written in the test
+//
+// Exit-codes are mapped like so:
+//
+//    0 - OK, the test passes
+//    1 - WARNING, the test fails unless "with ignore-warning true" is used
+//    2 - CRITICAL, the test fails
+//    3 - UNKNOWN, the test fails
+//
+// The plugin, and any process it started, is killed if it runs for
+// longer than the test timeout.
+//
+// The first line of the plugin output, and its performance data, are
+// reported in the details of the test result.
+//
+
+package protocols
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// ExecTest is our object.
+type ExecTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *ExecTest) Arguments() map[string]string {
+	known := map[string]string{
+		"command":        `^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`,
+		"args":           ".*",
+		"ignore-warning": "^(true|false)$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *ExecTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *ExecTest) Example() string {
+	str := `
+Exec Tester
+-----------
+ The exec tester runs a Nagios/Icinga-compatible plugin, and maps its
+ exit-code to the result of the test.
+
+ Only the plugins found in the directory configured on the worker, via
+ the '-exec-plugin-dir' flag, can be run.  If the flag is not set, the
+ exec tester is disabled.
+
+ This test is invoked via input like so:
+
+    host.example.com must run exec with command check_http with args '-H $HOSTNAME$ -I $HOSTADDRESS$ -S'
+
+ Arguments are split on whitespace, unless double-quoted, and support
+ the following macros:
+
+    $HOSTADDRESS$ - the resolved IP address of the target
+    $HOSTNAME$    - the target, as written in the test
+
+ Exit-codes are mapped like so:
+
+    0 - OK, the test passes
+    1 - WARNING, the test fails unless "with ignore-warning true" is used
+    2 - CRITICAL, the test fails
+    3 - UNKNOWN, the test fails
+
+ The plugin, and any process it started, is killed if it runs for
+ longer than the test timeout.
+
+ The first line of the plugin output, and its performance data, are
+ reported in the details of the test result.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+func (s *ExecTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// plugin output alongside the result.
+func (s *ExecTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
+
+	if opts.ExecPluginDir == "" {
+		return nil, errors.New("exec tests are disabled on this worker, no plugin directory is configured")
+	}
+
+	//
+	// The command must be a plain file in the plugin directory.
+	//
+	name := tst.Arguments["command"]
+	if name == "" {
+		return nil, errors.New("no command specified")
+	}
+	if strings.ContainsRune(name, filepath.Separator) || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid command '%s'", name)
+	}
+	path := filepath.Join(opts.ExecPluginDir, name)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin '%s' not found in the plugin directory", name)
+	}
+	if !stat.Mode().IsRegular() || stat.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("plugin '%s' is not an executable file", name)
+	}
+
+	//
+	// Expand the macros in the arguments.
+	//
+	replacer := strings.NewReplacer(
+		"$HOSTADDRESS$", target,
+		"$HOSTNAME$", tst.Target,
+	)
+	var args []string
+	for _, arg := range splitArgs(tst.Arguments["args"]) {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	//
+	// Run the plugin, killing it if it takes too long.
+	//
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Dir = opts.ExecPluginDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stdout
+	startProcessGroup(cmd)
+
+	if opts.Verbose {
+		fmt.Printf("\tExec running: %s %s\n", path, strings.Join(args, " "))
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	//
+	// We don't wait for the plugin to be reaped after killing it,
+	// because any child-process may keep its output open.
+	//
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-expired:
+		killProcessGroup(cmd)
+		return nil, fmt.Errorf("UNKNOWN: plugin '%s' timed out after %s", name, timeout)
+	}
+
+	exitCode := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, err
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	//
+	// The first line of output is in the form "TEXT | PERFDATA", and
+	// further performance data may follow a pipe in the later lines.
+	//
+	output, perfdata := s.parseOutput(stdout.String())
+	details := fmt.Sprintf("output: %s", output)
+	if perfdata != "" {
+		details += fmt.Sprintf("\nperfdata: %s", perfdata)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tExec exit-code %d, %s\n", exitCode, details)
+	}
+
+	switch exitCode {
+	case 0:
+		return &details, nil
+	case 1:
+		if tst.Arguments["ignore-warning"] == "true" {
+			return &details, nil
+		}
+		return &details, fmt.Errorf("WARNING: %s", output)
+	case 2:
+		return &details, fmt.Errorf("CRITICAL: %s", output)
+	case 3:
+		return &details, fmt.Errorf("UNKNOWN: %s", output)
+	}
+
+	return &details, fmt.Errorf("UNKNOWN: plugin exited with code %d: %s", exitCode, output)
+}
+
+// splitArgs splits the given string on whitespace, keeping double-quoted
+// sections together.
+func splitArgs(input string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	hasArg := false
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// parseOutput returns the first line of the plugin output, and all of
+// its performance data.
+func (s *ExecTest) parseOutput(output string) (string, string) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+
+	var perfdata []string
+	first := strings.SplitN(lines[0], "|", 2)
+	text := strings.TrimSpace(first[0])
+	if len(first) == 2 {
+		perfdata = append(perfdata, strings.TrimSpace(first[1]))
+	}
+
+	//
+	// Long output may contain more performance data, after a pipe,
+	// which continues until the end of the output.
+	//
+	for i, line := range lines[1:] {
+		if idx := strings.Index(line, "|"); idx != -1 {
+			perfdata = append(perfdata, strings.TrimSpace(line[idx+1:]))
+			for _, more := range lines[i+2:] {
+				perfdata = append(perfdata, strings.TrimSpace(more))
+			}
+			break
+		}
+	}
+
+	return text, strings.Join(perfdata, " ")
+}
+
+func (s *ExecTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("exec", func() ProtocolTest {
+		return &ExecTest{}
+	})
+}
@@ -0,0 +1,141 @@
+package protocols
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// testExecPlugin prints its arguments, and exits with the code given
+// as the first one.
+var testExecPlugin = `#!/bin/sh
+if [ "$1" = "sleep" ]; then
+  sleep 30 &
+  wait
+fi
+echo "STATUS $* | time=1s"
+echo "more output"
+exit $1
+`
+
+// Test splitting the arguments of a plugin
+func TestExecSplitArgs(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"-H host", []string{"-H", "host"}},
+		{"  -H\thost   -S ", []string{"-H", "host", "-S"}},
+		{`-s "a quoted string" -x`, []string{"-s", "a quoted string", "-x"}},
+		{`-s ""`, []string{"-s", ""}},
+		{`pre"fix suf"fix`, []string{"prefix suffix"}},
+	}
+
+	for _, tst := range tests {
+		out := splitArgs(tst.Input)
+		if !reflect.DeepEqual(out, tst.Output) {
+			t.Errorf("Expected %q to split into %q, got %q", tst.Input, tst.Output, out)
+		}
+	}
+}
+
+// Test extracting the text and performance data of the plugin output
+func TestExecParseOutput(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Text     string
+		Perfdata string
+	}{
+		{"OK", "OK", ""},
+		{"OK - all good | time=0.1s;1;2\n", "OK - all good", "time=0.1s;1;2"},
+		{"WARNING - slow\nlong output\nmore output", "WARNING - slow", ""},
+		{"OK | a=1\nlong output | b=2\nc=3\n", "OK", "a=1 b=2 c=3"},
+		{"", "", ""},
+	}
+
+	s := &ExecTest{}
+	for _, tst := range tests {
+		text, perfdata := s.parseOutput(tst.Input)
+		if text != tst.Text || perfdata != tst.Perfdata {
+			t.Errorf("Expected %q to give %q/%q, got %q/%q", tst.Input, tst.Text, tst.Perfdata, text, perfdata)
+		}
+	}
+}
+
+// Test mapping the exit-code of a plugin to the result of the test
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exec test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "overseer-exec")
+	if err != nil {
+		t.Fatalf("Error creating plugin directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "check_test"), []byte(testExecPlugin), 0755)
+	if err != nil {
+		t.Fatalf("Error writing plugin: %s", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "README"), []byte("Not a plugin"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %s", err.Error())
+	}
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+		Error     string
+	}{
+		{map[string]string{"command": "check_test", "args": "0"}, true, ""},
+		{map[string]string{"command": "check_test", "args": "1"}, false, "WARNING: STATUS 1"},
+		{map[string]string{"command": "check_test", "args": "1", "ignore-warning": "true"}, true, ""},
+		{map[string]string{"command": "check_test", "args": "2"}, false, "CRITICAL: STATUS 2"},
+		{map[string]string{"command": "check_test", "args": "3"}, false, "UNKNOWN: STATUS 3"},
+		{map[string]string{"command": "check_test", "args": "4"}, false, "UNKNOWN: plugin exited with code 4"},
+		{map[string]string{"command": "check_test", "args": "sleep"}, false, "timed out"},
+		{map[string]string{"command": "check_missing"}, false, "not found"},
+		{map[string]string{"command": "README"}, false, "not an executable"},
+		{map[string]string{"command": ".."}, false, "invalid command"},
+	}
+
+	for _, tst := range tests {
+		s := &ExecTest{}
+		err = s.RunTest(test.Test{Target: "host.example.com", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: 500 * time.Millisecond, ExecPluginDir: dir})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && (err == nil || !strings.Contains(err.Error(), tst.Error)) {
+			t.Errorf("Expected %v to fail with '%s', got %v", tst.Arguments, tst.Error, err)
+		}
+	}
+
+	//
+	// The macros are expanded, and the output is reported in the details.
+	//
+	s := &ExecTest{}
+	details, err := s.RunTestWithDetails(test.Test{Target: "host.example.com", Arguments: map[string]string{"command": "check_test", "args": `0 "$HOSTNAME$ at" $HOSTADDRESS$`}}, "127.0.0.1", test.Options{Timeout: time.Second, ExecPluginDir: dir})
+	if err != nil {
+		t.Errorf("Expected macros to pass, got error: %s", err.Error())
+	}
+	expected := "output: STATUS 0 host.example.com at 127.0.0.1\nperfdata: time=1s"
+	if details == nil || *details != expected {
+		t.Errorf("Expected details %q, got %v", expected, details)
+	}
+
+	// Disabled without a plugin directory
+	if err = s.RunTest(test.Test{Arguments: map[string]string{"command": "check_test"}}, "127.0.0.1", test.Options{}); err == nil {
+		t.Errorf("Expected exec without a plugin directory to fail")
+	}
+}
@@ -0,0 +1,21 @@
+// +build !windows
+
+package protocols
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup makes the plugin the leader of a new process group,
+// so that any child-process it spawns can be killed alongside it.
+func startProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the plugin, and every process in its group.
+func killProcessGroup(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
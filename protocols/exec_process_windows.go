@@ -0,0 +1,15 @@
+package protocols
+
+import (
+	"os/exec"
+)
+
+// startProcessGroup does nothing, process groups are not available on
+// Windows.
+func startProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills the plugin, its child-processes are left alone.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
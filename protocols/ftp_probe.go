@@ -27,6 +27,16 @@
 // "content" parameter:
 //
 //    ftp://ftp.example.com/path/to/README.md must run ftp with content '2018'
+//
+// The certificate offered via STARTTLS can be checked to not expire
+// within the given period, as for the ssl test:
+//
+//    host.example.com must run ftp with expiration 14d
+//
+// If the certificate is self-signed or otherwise non-trusted, only its
+// expiration is checked when using `with tls insecure`, and neither
+// its chain nor its hostname.
+//
 
 package protocols
 
@@ -50,10 +60,12 @@ type FTPTest struct {
 // their values.
 func (s *FTPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"content":  ".*",
-		"password": ".*",
-		"port":     "^[0-9]+$",
-		"username": ".*",
+		"content":    ".*",
+		"password":   ".*",
+		"port":       "^[0-9]+$",
+		"username":   ".*",
+		"expiration": "^([0-9]+[hd]?)$",
+		"tls":        "insecure",
 	}
 	return known
 }
@@ -95,6 +107,15 @@ FTP Tester
  "content" parameter:
 
     ftp://ftp.example.com/path/to/README.md must run ftp with content '2018'
+
+ The certificate offered via STARTTLS can be checked to not expire
+ within the given period, as for the ssl test:
+
+    host.example.com must run ftp with expiration 14d
+
+ If the certificate is self-signed or otherwise non-trusted, only its
+ expiration is checked when using 'with tls insecure', and neither
+ its chain nor its hostname.
 `
 	return str
 }
@@ -250,6 +271,15 @@ func (s *FTPTest) RunTest(tst test.Test, target string, opts test.Options) error
 
 	}
 
+	//
+	// Check the certificate offered via STARTTLS.
+	//
+	if tst.Arguments["expiration"] != "" {
+		if err = checkSTARTTLSExpiration("ftp", address, tst, opts, tst.Arguments["tls"] == "insecure", true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,414 @@
+package protocols
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/tidwall/gjson"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// httpAssertions are the tests made of a HTTP response, which are
+// shared by the http and http-flow protocol-tests.
+type httpAssertions struct {
+	Status      string   `yaml:"status"`
+	Content     string   `yaml:"content"`
+	NotContent  string   `yaml:"not-content"`
+	Pattern     string   `yaml:"pattern"`
+	NotPattern  string   `yaml:"not-pattern"`
+	HeaderMatch []string `yaml:"header-match"`
+	JSONPath    []string `yaml:"json-path"`
+	XPath       []string `yaml:"xpath"`
+	JSONSchema  string   `yaml:"json-schema"`
+}
+
+// check tests the status-code, headers and body of a response against
+// the assertions, returning the first which failed.
+func (a *httpAssertions) check(status int, header http.Header, body []byte) error {
+
+	//
+	// The default status-code we accept as OK
+	//
+	var allowedStatuses []int
+
+	//
+	// Did the user want to look for a specific status-code?
+	//
+	if a.Status != "" && a.Status != "any" {
+
+		split := strings.Split(a.Status, ",")
+		for _, statusString := range split {
+			allowedStatus, errConv := strconv.Atoi(strings.TrimSpace(statusString))
+			if errConv != nil {
+				return errConv
+			}
+
+			allowedStatuses = append(allowedStatuses, allowedStatus)
+		}
+
+	} else {
+
+		allowedStatuses = append(allowedStatuses, http.StatusOK)
+
+	}
+
+	//
+	// See if the status-code matched our expectation(s).
+	//
+	// If they mis-match that means the test failed, unless the user
+	// said "with status any".
+	//
+	if a.Status != "any" {
+
+		found := false
+		for _, allowedStatus := range allowedStatuses {
+			if status == allowedStatus {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			if len(allowedStatuses) == 1 {
+				return fmt.Errorf("status code was %d not %d", status, allowedStatuses[0])
+			}
+
+			return fmt.Errorf("status code was %d not one of %v", status, allowedStatuses)
+		}
+
+	}
+
+	//
+	// Is the user looking for a literal body-match?
+	//
+	if a.Content != "" {
+		if !strings.Contains(string(body), a.Content) {
+			return fmt.Errorf("body didn't contain '%s'", a.Content)
+		}
+	}
+
+	//
+	// Is the user NOT looking for a literal body-match?
+	//
+	if a.NotContent != "" {
+		if strings.Contains(string(body), a.NotContent) {
+			return fmt.Errorf("body contains '%s'", a.NotContent)
+		}
+	}
+
+	//
+	// Is the user expecting a regular expression to match the content?
+	//
+	if a.Pattern != "" {
+		re, err := regexp.Compile("(?ms)" + a.Pattern)
+		if err != nil {
+			return err
+		}
+
+		// Skip unless this handler matches the filter.
+		match := re.FindAllStringSubmatch(string(body), -1)
+		if len(match) < 1 {
+			return fmt.Errorf("body didn't match the regular expression '%s'", a.Pattern)
+		}
+	}
+
+	//
+	// Is the user NOT expecting a regular expression to match the content?
+	//
+	if a.NotPattern != "" {
+		re, err := regexp.Compile("(?ms)" + a.NotPattern)
+		if err != nil {
+			return err
+		}
+
+		// Skip unless this handler matches the filter.
+		match := re.FindAllStringSubmatch(string(body), -1)
+		if len(match) > 0 {
+			return fmt.Errorf("body matched the regular expression '%s'", a.NotPattern)
+		}
+	}
+
+	//
+	// Are there assertions on the response headers?
+	//
+	for _, assertion := range a.HeaderMatch {
+		if err := checkHeaderMatch(header, assertion); err != nil {
+			return err
+		}
+	}
+
+	//
+	// Or on the structure of the body?
+	//
+	for _, assertion := range a.JSONPath {
+		if err := checkJSONPath(body, assertion); err != nil {
+			return err
+		}
+	}
+	for _, expression := range a.XPath {
+		if err := checkXPath(body, expression); err != nil {
+			return err
+		}
+	}
+	if a.JSONSchema != "" {
+		if err := checkJSONSchema(body, a.JSONSchema); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate ensures the assertions are well-formed, so that mistakes are
+// reported before any request is made.
+func (a *httpAssertions) validate() error {
+	if a.Status != "" && a.Status != "any" {
+		for _, statusString := range strings.Split(a.Status, ",") {
+			if _, err := strconv.Atoi(strings.TrimSpace(statusString)); err != nil {
+				return fmt.Errorf("invalid status '%s'", a.Status)
+			}
+		}
+	}
+
+	for _, pattern := range []string{a.Pattern, a.NotPattern} {
+		if _, err := regexp.Compile("(?ms)" + pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", pattern, err.Error())
+		}
+	}
+
+	for _, assertion := range a.HeaderMatch {
+		if _, _, err := parseHeaderMatch(assertion); err != nil {
+			return err
+		}
+	}
+
+	for _, expression := range a.XPath {
+		if _, err := xpath.Compile(expression); err != nil {
+			return fmt.Errorf("invalid xpath '%s': %s", expression, err.Error())
+		}
+	}
+	return nil
+}
+
+// jsonPathAssertion splits a json-path setting into the path, and the
+// optional comparison of its value, such as "$.queue.depth < 100".
+var jsonPathAssertion = regexp.MustCompile(`^(\S+)\s+(==|!=|<=|>=|=~|<|>)\s+(.+)$`)
+
+// jsonPathIndex matches the array indexes of a JSONPath expression.
+var jsonPathIndex = regexp.MustCompile(`\[([0-9]+)\]`)
+
+// gjsonPath converts a simple JSONPath expression, such as
+// "$.items[0].name", to the GJSON syntax.  Paths not starting with "$"
+// are assumed to be GJSON already.
+func gjsonPath(path string) string {
+	if !strings.HasPrefix(path, "$") {
+		return path
+	}
+	path = jsonPathIndex.ReplaceAllString(path, ".$1")
+	path = strings.TrimPrefix(path, "$")
+	return strings.TrimPrefix(path, ".")
+}
+
+// checkJSONPath tests a json-path assertion against the body.
+//
+// Without a comparison the value must exist.  Otherwise it's compared
+// numerically if the expected value is a number, as a regular expression
+// with "=~", or as a string.
+func checkJSONPath(body []byte, assertion string) error {
+	if !gjson.ValidBytes(body) {
+		return errors.New("body is not valid JSON")
+	}
+
+	path := strings.TrimSpace(assertion)
+	op := ""
+	expected := ""
+	if match := jsonPathAssertion.FindStringSubmatch(path); match != nil {
+		path, op, expected = match[1], match[2], strings.TrimSpace(match[3])
+	}
+
+	result := gjson.GetBytes(body, gjsonPath(path))
+	if !result.Exists() {
+		return fmt.Errorf("json-path '%s' not found", path)
+	}
+	if op == "" {
+		return nil
+	}
+
+	actual := result.String()
+	if result.Type == gjson.Null {
+		actual = "null"
+	}
+
+	failed := fmt.Errorf("json-path '%s' was '%s', expected %s %s", path, actual, op, expected)
+
+	if op == "=~" {
+		re, err := regexp.Compile(unquoteLiteral(expected))
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(actual) {
+			return failed
+		}
+		return nil
+	}
+
+	//
+	// Numeric comparison?
+	//
+	if number, err := strconv.ParseFloat(expected, 64); err == nil {
+		if result.Type != gjson.Number {
+			return fmt.Errorf("json-path '%s' was '%s', not a number", path, actual)
+		}
+		if !compareNumbers(result.Float(), op, number) {
+			return failed
+		}
+		return nil
+	}
+
+	switch op {
+	case "==":
+		if actual != unquoteLiteral(expected) {
+			return failed
+		}
+	case "!=":
+		if actual == unquoteLiteral(expected) {
+			return failed
+		}
+	default:
+		return fmt.Errorf("json-path '%s' can only be compared with %s to a number", path, op)
+	}
+	return nil
+}
+
+// compareNumbers applies the comparison operator to the given values.
+func compareNumbers(actual float64, op string, expected float64) bool {
+	switch op {
+	case "==":
+		return actual == expected
+	case "!=":
+		return actual != expected
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	}
+	return false
+}
+
+// unquoteLiteral removes the single, or double, quotes around a value.
+func unquoteLiteral(value string) string {
+	if len(value) >= 2 {
+		first := value[0]
+		if (first == '"' || first == '\'') && value[len(value)-1] == first {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
+
+// checkXPath tests an XPath expression against the body, which must be
+// XML.
+//
+// An expression selecting nodes must match at least one, otherwise the
+// result must be true, a non-zero number, or a non-empty string.
+func checkXPath(body []byte, expression string) error {
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("body is not valid XML: %s", err.Error())
+	}
+
+	expr, err := xpath.Compile(expression)
+	if err != nil {
+		return fmt.Errorf("invalid xpath '%s': %s", expression, err.Error())
+	}
+
+	ok := false
+	switch result := expr.Evaluate(xmlquery.CreateXPathNavigator(doc)).(type) {
+	case bool:
+		ok = result
+	case float64:
+		ok = result != 0
+	case string:
+		ok = result != ""
+	case *xpath.NodeIterator:
+		ok = result.MoveNext()
+	}
+
+	if !ok {
+		return fmt.Errorf("body didn't match the xpath '%s'", expression)
+	}
+	return nil
+}
+
+// parseHeaderMatch splits a "Name: pattern" assertion into the name of
+// the header, and the compiled regular expression.
+func parseHeaderMatch(assertion string) (string, *regexp.Regexp, error) {
+	parts := strings.SplitN(assertion, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", nil, fmt.Errorf("invalid header-match '%s', expected 'Name: pattern'", assertion)
+	}
+
+	re, err := regexp.Compile(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid header-match '%s': %s", assertion, err.Error())
+	}
+	return strings.TrimSpace(parts[0]), re, nil
+}
+
+// checkHeaderMatch tests a "Name: pattern" assertion against the
+// response headers, one of the values of the header must match the
+// regular expression.
+func checkHeaderMatch(header http.Header, assertion string) error {
+	name, re, err := parseHeaderMatch(assertion)
+	if err != nil {
+		return err
+	}
+
+	values := header[http.CanonicalHeaderKey(name)]
+	if len(values) == 0 {
+		return fmt.Errorf("response header %s not found", name)
+	}
+	for _, value := range values {
+		if re.MatchString(value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("response header %s was '%s', didn't match '%s'", name, strings.Join(values, ", "), re.String())
+}
+
+// checkJSONSchema validates the body against the JSON Schema in the
+// given file.
+func checkJSONSchema(body []byte, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	result, err := gojsonschema.Validate(
+		gojsonschema.NewReferenceLoader("file://"+filepath.ToSlash(abs)),
+		gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return fmt.Errorf("failed to validate against the schema %s: %s", path, err.Error())
+	}
+
+	if !result.Valid() {
+		var problems []string
+		for _, problem := range result.Errors() {
+			problems = append(problems, problem.String())
+		}
+		return fmt.Errorf("body doesn't match the schema %s: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
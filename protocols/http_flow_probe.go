@@ -0,0 +1,494 @@
+// HTTP Flow Tester
+//
+// The HTTP flow tester runs a sequence of HTTP requests, such as the
+// steps of a login, sharing cookies between them, and fails if any of
+// them fails.
+//
+// This test is invoked via input like so:
+//
+//    https://example.com/ must run http-flow with file /etc/overseer/flows/login.yaml
+//
+// The steps are defined in a YAML file.  Relative URLs are resolved
+// against the target, and values can be extracted from each response
+// to be used by the following steps, as ${name}.  Secrets can be read
+// on the worker via ${env:NAME}, or ${file:NAME} from the directory
+// given by -secrets-dir, as for the http test.
+//
+//    steps:
+//      - name: login page
+//        url: /login
+//        extract:
+//          - name: csrf
+//            pattern: 'name="csrf" value="([^"]+)"'
+//
+//      - name: login
+//        method: POST
+//        url: /login
+//        headers:
+//          Content-Type: application/x-www-form-urlencoded
+//        body: user=monitor&password=${env:LOGIN_PASSWORD}&csrf=${csrf}
+//        status: 302
+//
+//      - name: token
+//        method: POST
+//        url: /api/token
+//        extract:
+//          - name: jwt
+//            json-path: $.token
+//
+//      - name: profile
+//        url: /api/profile
+//        headers:
+//          Authorization: Bearer ${jwt}
+//        json-path:
+//          - $.user == "monitor"
+//        max-response-time: 500ms
+//
+// Values are extracted via pattern, using the first group of the
+// regular expression, json-path, or header.  Each step accepts the
+// assertions of the http protocol-test: status, content, not-content,
+// pattern, not-pattern, header-match, json-path, xpath and json-schema,
+// along with the max-response-time, max-connect-time, max-tls-time and
+// max-ttfb thresholds.  As with the http protocol-test redirects are not
+// followed, unless the step sets follow-redirect.
+//
+// The time taken by each step is reported with the result, and
+// submitted to the metrics-host.
+//
+// If you need to disable failures due to expired, broken, or
+// otherwise bogus SSL certificates you can do so via the tls setting:
+//
+//    https://example.com/ must run http-flow with file /etc/overseer/flows/login.yaml with tls insecure
+//
+
+package protocols
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+)
+
+// HTTPFlowTest is our object.
+type HTTPFlowTest struct {
+}
+
+// httpFlow is the definition of a flow, as read from its file.
+type httpFlow struct {
+	Steps []httpFlowStep `yaml:"steps"`
+}
+
+// httpFlowStep is a single request of a flow.
+type httpFlowStep struct {
+	Name           string               `yaml:"name"`
+	Method         string               `yaml:"method"`
+	URL            string               `yaml:"url"`
+	Headers        map[string]string    `yaml:"headers"`
+	Body           string               `yaml:"body"`
+	FollowRedirect bool                 `yaml:"follow-redirect"`
+	Extract        []httpFlowExtraction `yaml:"extract"`
+	Thresholds     map[string]string    `yaml:",inline"`
+
+	httpAssertions `yaml:",inline"`
+}
+
+// httpFlowExtraction names a value to extract from a response.
+type httpFlowExtraction struct {
+	Name     string `yaml:"name"`
+	Pattern  string `yaml:"pattern"`
+	JSONPath string `yaml:"json-path"`
+	Header   string `yaml:"header"`
+}
+
+// httpFlowVariable matches the references to values in a step.
+var httpFlowVariable = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *HTTPFlowTest) Arguments() map[string]string {
+	known := map[string]string{
+		"file": ".*",
+		"tls":  "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *HTTPFlowTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *HTTPFlowTest) Example() string {
+	str := `
+HTTP Flow Tester
+----------------
+ The HTTP flow tester runs a sequence of HTTP requests, such as the
+ steps of a login, sharing cookies between them, and fails if any of
+ them fails.
+
+ This test is invoked via input like so:
+
+    https://example.com/ must run http-flow with file /etc/overseer/flows/login.yaml
+
+ The steps are defined in a YAML file.  Relative URLs are resolved
+ against the target, and values can be extracted from each response
+ to be used by the following steps, as ${name}.  Secrets can be read
+ on the worker via ${env:NAME}, or ${file:NAME} from the directory
+ given by -secrets-dir, as for the http test.
+
+    steps:
+      - name: login page
+        url: /login
+        extract:
+          - name: csrf
+            pattern: 'name="csrf" value="([^"]+)"'
+
+      - name: login
+        method: POST
+        url: /login
+        headers:
+          Content-Type: application/x-www-form-urlencoded
+        body: user=monitor&password=${env:LOGIN_PASSWORD}&csrf=${csrf}
+        status: 302
+
+      - name: token
+        method: POST
+        url: /api/token
+        extract:
+          - name: jwt
+            json-path: $.token
+
+      - name: profile
+        url: /api/profile
+        headers:
+          Authorization: Bearer ${jwt}
+        json-path:
+          - $.user == "monitor"
+        max-response-time: 500ms
+
+ Values are extracted via pattern, using the first group of the
+ regular expression, json-path, or header.  Each step accepts the
+ assertions of the http protocol-test: status, content, not-content,
+ pattern, not-pattern, header-match, json-path, xpath and json-schema,
+ along with the max-response-time, max-connect-time, max-tls-time and
+ max-ttfb thresholds.  As with the http protocol-test redirects are not
+ followed, unless the step sets follow-redirect.
+
+ The time taken by each step is reported with the result, and
+ submitted to the metrics-host.
+
+ If you need to disable failures due to expired, broken, or
+ otherwise bogus SSL certificates you can do so via the tls setting:
+
+    https://example.com/ must run http-flow with file /etc/overseer/flows/login.yaml with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+func (s *HTTPFlowTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the time
+// taken by each step alongside the result.
+//
+// As with the http protocol-test the requests made to the host of the
+// target are sent to the address we've been given.
+func (s *HTTPFlowTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
+	if tst.Arguments["file"] == "" {
+		return nil, errors.New("no flow file specified")
+	}
+
+	flow, err := s.load(tst.Arguments["file"])
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(tst.Target)
+	if err != nil {
+		return nil, err
+	}
+	port := base.Port()
+	if port == "" {
+		port = "80"
+		if base.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	//
+	// Connections to the host of the target go to the address we've
+	// been given, others are made as requested.
+	//
+	dialer := &net.Dialer{}
+	targetAddr := net.JoinHostPort(base.Hostname(), port)
+	dial := func(ctx context.Context, network, requested string) (net.Conn, error) {
+		if requested == targetAddr && target != "" {
+			requested = net.JoinHostPort(target, port)
+		}
+		return dialer.DialContext(ctx, network, requested)
+	}
+
+	tr := &http.Transport{
+		DialContext: dial,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	var timings []string
+
+	for i, step := range flow.Steps {
+		name := step.Name
+		if name == "" {
+			name = step.URL
+		}
+
+		duration, errStep := s.runStep(step, base, values, opts.SecretsDir, &http.Client{
+			Timeout:   timeout,
+			Transport: tr,
+			Jar:       jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if step.FollowRedirect && len(via) < 10 {
+					return nil
+				}
+				return http.ErrUseLastResponse
+			},
+		})
+
+		if duration > 0 {
+			timings = append(timings, fmt.Sprintf("%s: %s", name, formatMilliseconds(duration)))
+			if opts.Metric != nil {
+				opts.Metric(fmt.Sprintf("step-%d-time", i+1), milliseconds(duration))
+			}
+		}
+
+		details := strings.Join(timings, ", ")
+		if opts.Verbose {
+			fmt.Printf("\tHTTP flow step %d (%s): %s\n", i+1, name, formatMilliseconds(duration))
+		}
+		if errStep != nil {
+			return &details, fmt.Errorf("step %d (%s) failed: %s", i+1, name, errStep.Error())
+		}
+	}
+
+	details := strings.Join(timings, ", ")
+	return &details, nil
+}
+
+// runStep makes the request of a step, tests the response, and extracts
+// the values it defines, returning the time the request took.
+func (s *HTTPFlowTest) runStep(step httpFlowStep, base *url.URL, values map[string]string, secretsDir string, client *http.Client) (time.Duration, error) {
+	ref, err := expandFlowValues(step.URL, values, secretsDir)
+	if err != nil {
+		return 0, err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := expandFlowValues(step.Body, values, secretsDir)
+	if err != nil {
+		return 0, err
+	}
+
+	method := step.Method
+	if method == "" {
+		method = "GET"
+		if body != "" {
+			method = "POST"
+		}
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewBufferString(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "overseer/probe")
+	for header, value := range step.Headers {
+		value, err = expandFlowValues(value, values, secretsDir)
+		if err != nil {
+			return 0, err
+		}
+		if strings.EqualFold(header, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(header, value)
+	}
+
+	timings := newHTTPTimings()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.trace()))
+
+	response, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	timings.done()
+
+	if err = step.httpAssertions.check(response.StatusCode, response.Header, data); err != nil {
+		return timings.Total, err
+	}
+	if err = timings.check(step.Thresholds); err != nil {
+		return timings.Total, err
+	}
+
+	for _, extraction := range step.Extract {
+		value, errExtract := extractFlowValue(extraction, response.Header, data)
+		if errExtract != nil {
+			return timings.Total, errExtract
+		}
+		values[extraction.Name] = value
+	}
+
+	return timings.Total, nil
+}
+
+// load reads the definition of a flow.
+func (s *HTTPFlowTest) load(path string) (*httpFlow, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	flow := &httpFlow{}
+	if err = yaml.Unmarshal(data, flow); err != nil {
+		return nil, fmt.Errorf("invalid flow file %s: %s", path, err.Error())
+	}
+	if len(flow.Steps) == 0 {
+		return nil, fmt.Errorf("no steps found in %s", path)
+	}
+
+	for i, step := range flow.Steps {
+		if step.URL == "" {
+			return nil, fmt.Errorf("step %d of %s has no url", i+1, path)
+		}
+		for name := range step.Thresholds {
+			switch name {
+			case "max-response-time", "max-connect-time", "max-tls-time", "max-ttfb":
+			default:
+				return nil, fmt.Errorf("step %d of %s has an unknown setting '%s'", i+1, path, name)
+			}
+		}
+		for _, extraction := range step.Extract {
+			if extraction.Name == "" {
+				return nil, fmt.Errorf("step %d of %s extracts a value without a name", i+1, path)
+			}
+		}
+		if err = step.httpAssertions.validate(); err != nil {
+			return nil, fmt.Errorf("step %d of %s: %s", i+1, path, err.Error())
+		}
+	}
+	return flow, nil
+}
+
+// expandFlowValues replaces the references to values, and secrets, in
+// the given string.  Secret files are read from the given directory.
+func expandFlowValues(input string, values map[string]string, secretsDir string) (string, error) {
+	var err error
+	output := httpFlowVariable.ReplaceAllStringFunc(input, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+
+		if strings.HasPrefix(name, "env:") || strings.HasPrefix(name, "file:") {
+			secret, errSecret := resolveSecret(name, secretsDir)
+			if errSecret != nil {
+				err = errSecret
+			}
+			return secret
+		}
+
+		value, ok := values[name]
+		if !ok {
+			err = fmt.Errorf("unknown value '%s'", name)
+		}
+		return value
+	})
+	return output, err
+}
+
+// extractFlowValue extracts a value from a response.
+func extractFlowValue(extraction httpFlowExtraction, header http.Header, body []byte) (string, error) {
+	switch {
+	case extraction.Pattern != "":
+		re, err := regexp.Compile("(?ms)" + extraction.Pattern)
+		if err != nil {
+			return "", err
+		}
+		match := re.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("failed to extract '%s': body didn't match the regular expression '%s'", extraction.Name, extraction.Pattern)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+
+	case extraction.JSONPath != "":
+		result := gjson.GetBytes(body, gjsonPath(extraction.JSONPath))
+		if !result.Exists() {
+			return "", fmt.Errorf("failed to extract '%s': json-path '%s' not found", extraction.Name, extraction.JSONPath)
+		}
+		return result.String(), nil
+
+	case extraction.Header != "":
+		value := header.Get(extraction.Header)
+		if value == "" {
+			return "", fmt.Errorf("failed to extract '%s': response header %s not found", extraction.Name, extraction.Header)
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("no pattern, json-path or header given to extract '%s'", extraction.Name)
+}
+
+func (s *HTTPFlowTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("http-flow", func() ProtocolTest {
+		return &HTTPFlowTest{}
+	})
+}
@@ -0,0 +1,156 @@
+package protocols
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// startLoginServer starts a stand-in for a site with a login form,
+// protected by a CSRF token and a session cookie, and an API using
+// bearer tokens.
+func startLoginServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "c5rf"})
+			fmt.Fprintf(w, `<form><input type="hidden" name="csrf" value="c5rf"></form>`)
+			return
+		}
+		cookie, err := r.Cookie("csrf")
+		if err != nil || cookie.Value != r.FormValue("csrf") || r.FormValue("password") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3ss10n"})
+		http.Redirect(w, r, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "s3ss10n" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, "Welcome back")
+	})
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "s3ss10n" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token": "jwt.token.here"}`)
+	})
+	mux.HandleFunc("/api/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer jwt.token.here" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"user": "monitor", "roles": ["read"]}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+// writeTestFlow writes a flow definition to a temporary file.
+func writeTestFlow(t *testing.T, flow string) string {
+	file, err := ioutil.TempFile("", "overseer-flow")
+	if err != nil {
+		t.Fatalf("Error creating flow file: %s", err.Error())
+	}
+	defer file.Close()
+
+	file.WriteString(flow)
+	return file.Name()
+}
+
+// Test a login flow
+func TestHTTPFlow(t *testing.T) {
+	server := startLoginServer()
+	defer server.Close()
+
+	os.Setenv("OVERSEER_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("OVERSEER_TEST_PASSWORD")
+
+	login := `
+steps:
+  - name: login page
+    url: /login
+    extract:
+      - name: csrf
+        pattern: 'name="csrf" value="([^"]+)"'
+  - name: login
+    method: POST
+    url: /login
+    headers:
+      Content-Type: application/x-www-form-urlencoded
+    body: password=%s&csrf=${csrf}
+    status: 302
+    header-match:
+      - 'Location: ^/home$'
+  - name: home
+    url: /home
+    content: Welcome back
+  - name: token
+    method: POST
+    url: /api/token
+    extract:
+      - name: jwt
+        json-path: $.token
+  - name: profile
+    url: /api/profile
+    headers:
+      Authorization: Bearer ${jwt}
+    json-path:
+      - $.user == "monitor"
+      - $.roles[0] == "read"
+    max-response-time: 1s
+`
+
+	tests := []struct {
+		Flow  string
+		Valid bool
+		Step  string
+	}{
+		{fmt.Sprintf(login, "${env:OVERSEER_TEST_PASSWORD}"), true, ""},
+		{fmt.Sprintf(login, "wrong"), false, "step 2 (login)"},
+		{fmt.Sprintf(login, "${env:OVERSEER_TEST_MISSING}"), false, "step 2 (login)"},
+		{strings.Replace(fmt.Sprintf(login, "secret"), "$.roles[0]", "$.roles[1]", 1), false, "step 5 (profile)"},
+		{strings.Replace(fmt.Sprintf(login, "secret"), "${jwt}", "${token}", 1), false, "step 5 (profile)"},
+		{"steps:\n  - url: /home\n    follow-redirect: true\n    content: csrf\n", true, ""},
+		{"steps:\n  - url: /home\n    content: Welcome\n", false, "step 1 (/home)"},
+		{"steps:\n  - url: /home\n    max-reponse-time: 1s\n", false, ""},
+		{"steps: []\n", false, ""},
+		{"steps:\n  - url: /home\n    header-match:\n      - Location\n", false, "step 1 of "},
+		{"steps:\n  - url: /home\n    status: 2xx\n", false, "step 1 of "},
+		{"steps:\n  - url: /home\n    not-pattern: '(unclosed'\n", false, "step 1 of "},
+		{"steps:\n  - url: /home\n    xpath:\n      - '//['\n", false, "step 1 of "},
+	}
+
+	for _, tst := range tests {
+		file := writeTestFlow(t, tst.Flow)
+
+		s := &HTTPFlowTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: server.URL, Arguments: map[string]string{"file": file}}, "127.0.0.1", test.Options{Timeout: time.Second})
+		os.Remove(file)
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected flow to pass, got error: %s\n%s", err.Error(), tst.Flow)
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected flow to fail:\n%s", tst.Flow)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), tst.Step) {
+			t.Errorf("Expected the failure of %s, got %s", tst.Step, err.Error())
+		}
+		if tst.Valid && (details == nil || !strings.Contains(*details, "ms")) {
+			t.Errorf("Unexpected details: %v", details)
+		}
+	}
+}
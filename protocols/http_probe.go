@@ -49,17 +49,88 @@
 // (The regular expression will be assumed to be multi-line, and
 // will also allow newlines to be matched with ".".)
 //
+// JSON responses can be tested via JSONPath expressions, such as
+// "$.items[0].name", or the GJSON syntax.  Without a comparison the
+// value must exist, otherwise it is compared to a number, a quoted
+// string, or with =~ to a regular expression:
+//
+//    https://example.com/health must run http with json-path '$.status == "ok"' with json-path '$.queue.depth < 100'
+//
+// XML responses can be tested via XPath expressions, which must select
+// at least one node, or be true:
+//
+//    https://example.com/health.xml must run http with xpath '/health/status[.="ok"]' with xpath 'count(//error) = 0'
+//
+// The json-path and xpath settings, along with header-match which tests
+// a response header against a regular expression, may be given more
+// than once:
+//
+//    https://example.com/ must run http with header-match 'Content-Type: ^application/json' with header-match 'Cache-Control: no-cache'
+//
+// Finally a JSON response can be validated against a JSON Schema:
+//
+//    https://example.com/health must run http with json-schema /etc/overseer/health.schema.json
+//
 // If your URL requires the use of HTTP basic authentication this is
 // supported by adding a username and password parameter to your test,
 // for example:
 //
 //    https://jigsaw.w3.org/HTTP/Basic/ must run http with username 'guest' with password 'guest' with content "Your browser made it"
 //
+// A bearer token can be sent instead.  Rather than writing the token
+// into your tests it can be read, on the worker, from an environment
+// variable or from a file in the directory given by -secrets-dir:
+//
+//    https://api.example.com/ must run http with bearer-token 'env:API_TOKEN'
+//
+//    https://api.example.com/ must run http with bearer-token 'file:api.token'
+//
+// No other files can be read, as anybody who can enqueue tests could
+// otherwise send any file the worker can read to a server of their
+// choosing.  Without -secrets-dir no files can be read at all.
+//
+// APIs protected by OAuth2 can be sent a token obtained via the client
+// credentials grant, which is reused until it expires.  The client
+// secret can be read in the same way as a bearer token:
+//
+//    https://api.example.com/ must run http with oauth2-token-url 'https://auth.example.com/oauth/token' with oauth2-client-id 'overseer' with oauth2-client-secret 'env:OAUTH2_SECRET' with oauth2-scopes 'read:status'
+//
+// A failure to obtain the token is reported as such, rather than as a
+// failure of the API.
+//
+// Extra request headers can be sent via the header setting, which may
+// be given more than once:
+//
+//    https://api.example.com/ must run http with header 'X-Api-Key: 1234' with header 'Accept: application/json'
+//
+// To test a virtual-hosted backend directly by its IP address, the Host
+// header can be overridden:
+//
+//    http://10.0.0.5/ must run http with host-header 'www.example.com'
+//
 // If you need to disable failures due to expired, broken, or
 // otherwise bogus SSL certificates you can do so via the tls setting:
 //
 //    https://expired.badssl.com/ must run http with tls insecure
 //
+// Certificates are validated against the system roots, or against the
+// certificates of a PEM file set via ca-file.  Services which require
+// mutual TLS can be sent a client certificate, with its key either in
+// the same PEM file or a separate one:
+//
+//    https://internal.example.com/ must run http with ca-file /etc/overseer/ca.pem with client-cert /etc/overseer/client.pem with client-key /etc/overseer/client.key
+//
+// The name sent via SNI, and validated against the certificate, is the
+// hostname of the URL unless overridden:
+//
+//    https://10.0.0.5/ must run http with sni 'www.example.com'
+//
+// Requests can be made via a HTTP, HTTPS or SOCKS5 proxy, bypassing it
+// for the hosts and domains listed in no-proxy, as with the NO_PROXY
+// environment variable:
+//
+//    https://internal.example.com/ must run http with proxy 'socks5://proxy.example.com:1080' with no-proxy '.local,10.0.0.0/8'
+//
 // By default tests will fail if you're probing an SSL-site which has
 // a certificate which will expire within the next 14 days.  To change
 // the time-period specify it explicitly like so, if not stated the
@@ -88,6 +159,11 @@
 //
 //    https://steve.fi/Security/XSS/Tutorial/filter.cgi must run http with method PUT with data "text=test%20me" with content "test me"
 //
+// Larger payloads can be read from a file, instead of given inline, and
+// the type of the data set via content-type:
+//
+//    https://api.example.com/items must run http with data-file /etc/overseer/item.json with content-type 'application/json' with status 201
+//
 //
 // NOTE: This test deliberately does not follow redirections, to allow
 // enhanced testing.
@@ -98,6 +174,29 @@
 //
 //    with follow-redirect 20 <- max 20 follows
 //
+// The time taken by DNS lookups, to connect, to complete the TLS
+// handshake, to receive the first byte of the response, and in total, is
+// reported with the result and submitted to the metrics-host.  The
+// target itself is resolved by the worker before the test runs, so the
+// DNS phase covers the other names looked up, such as that of a proxy.
+// A slow response can be regarded as a failure via:
+//
+//    https://example.com/ must run http with max-response-time 2s
+//
+// Or per phase, via max-connect-time, max-tls-time and max-ttfb:
+//
+//    https://example.com/ must run http with max-connect-time 200ms with max-ttfb 1s
+//
+// The version of HTTP spoken, which is reported with the result, can
+// be forced via http-version.  The test then fails if the server, or a
+// CDN or load-balancer in front of it, negotiates another one:
+//
+//    https://example.com/ must run http with http-version 2
+//
+// HTTP/2 is only negotiated over https.  HTTP/3 is not supported: the
+// pure-Go QUIC clients need a newer Go than overseer is built with, so
+// it is left to a separate change.
+//
 
 package protocols
 
@@ -105,18 +204,20 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"golang.org/x/net/http/httpproxy"
 )
 
 // HTTPTest is our object.
@@ -128,27 +229,57 @@ type HTTPTest struct {
 // their values.
 func (s *HTTPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"user-agent":          ".*",
-		"content":             ".*",
-		"not-content":         ".*",
-		"data":                ".*",
-		"expiration":          "^(any|[0-9]+[hd]?)$",
-		"method":              "^(GET|HEAD|POST|PUT|PATCH|DELETE)$",
-		"password":            ".*",
-		"pattern":             ".*",
-		"not-pattern":         ".*",
-		"status":              "^(any|[0-9]{3}(?:,[0-9]{3})*)$",
-		"tls":                 "insecure",
-		"username":            ".*",
-		"connect-timeout":     `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
-		"connect-retries":     `^\d+$`,
-		"tls-timeout":         `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
-		"resp-header-timeout": `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
-		"follow-redirect":     `^true|false|(\d+)$`,
+		"user-agent":           ".*",
+		"bearer-token":         ".*",
+		"content":              ".*",
+		"content-type":         ".*",
+		"not-content":          ".*",
+		"data":                 ".*",
+		"data-file":            ".*",
+		"header":               `^[A-Za-z0-9-]+:\s*.*$`,
+		"header-match":         `^[A-Za-z0-9-]+:\s*.*$`,
+		"host-header":          ".*",
+		"http-version":         `^(1\.1|2)$`,
+		"json-path":            `^\S+(\s+(==|!=|<=|>=|=~|<|>)\s+.+)?$`,
+		"json-schema":          ".*",
+		"xpath":                ".+",
+		"expiration":           "^(any|[0-9]+[hd]?)$",
+		"method":               "^(GET|HEAD|POST|PUT|PATCH|DELETE)$",
+		"password":             ".*",
+		"pattern":              ".*",
+		"not-pattern":          ".*",
+		"status":               "^(any|[0-9]{3}(?:,[0-9]{3})*)$",
+		"tls":                  "insecure",
+		"username":             ".*",
+		"connect-timeout":      `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"connect-retries":      `^\d+$`,
+		"tls-timeout":          `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"resp-header-timeout":  `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"follow-redirect":      `^true|false|(\d+)$`,
+		"ca-file":              ".*",
+		"client-cert":          ".*",
+		"client-key":           ".*",
+		"proxy":                `^(https?|socks5)://.+$`,
+		"no-proxy":             ".*",
+		"oauth2-client-id":     ".*",
+		"oauth2-client-secret": ".*",
+		"oauth2-scopes":        ".*",
+		"oauth2-token-url":     "^https?://.+$",
+		"sni":                  ".*",
+		"max-connect-time":     `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"max-response-time":    `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"max-tls-time":         `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"max-ttfb":             `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
 	}
 	return known
 }
 
+// RepeatableArguments returns the names of the arguments which may be
+// given more than once.
+func (s *HTTPTest) RepeatableArguments() []string {
+	return []string{"header", "header-match", "json-path", "xpath"}
+}
+
 // ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
 func (s *HTTPTest) ShouldResolveHostname() bool {
 	return true
@@ -208,17 +339,88 @@ HTTP Tester
  (The regular expression will be assumed to be multi-line, and
  will also allow newlines to be matched with ".".)
 
+ JSON responses can be tested via JSONPath expressions, such as
+ "$.items[0].name", or the GJSON syntax.  Without a comparison the
+ value must exist, otherwise it is compared to a number, a quoted
+ string, or with =~ to a regular expression:
+
+   https://example.com/health must run http with json-path '$.status == "ok"' with json-path '$.queue.depth < 100'
+
+ XML responses can be tested via XPath expressions, which must select
+ at least one node, or be true:
+
+   https://example.com/health.xml must run http with xpath '/health/status[.="ok"]' with xpath 'count(//error) = 0'
+
+ The json-path and xpath settings, along with header-match which tests
+ a response header against a regular expression, may be given more
+ than once:
+
+   https://example.com/ must run http with header-match 'Content-Type: ^application/json' with header-match 'Cache-Control: no-cache'
+
+ Finally a JSON response can be validated against a JSON Schema:
+
+   https://example.com/health must run http with json-schema /etc/overseer/health.schema.json
+
  If your URL requires the use of HTTP basic authentication this is
  supported by adding a username and password parameter to your test,
  for example:
 
    https://jigsaw.w3.org/HTTP/Basic/ must run http with username 'guest' with password 'guest' with content "Your browser made it"
 
+ A bearer token can be sent instead.  Rather than writing the token
+ into your tests it can be read, on the worker, from an environment
+ variable or from a file in the directory given by -secrets-dir:
+
+   https://api.example.com/ must run http with bearer-token 'env:API_TOKEN'
+
+   https://api.example.com/ must run http with bearer-token 'file:api.token'
+
+ No other files can be read, as anybody who can enqueue tests could
+ otherwise send any file the worker can read to a server of their
+ choosing.  Without -secrets-dir no files can be read at all.
+
+ APIs protected by OAuth2 can be sent a token obtained via the client
+ credentials grant, which is reused until it expires.  The client
+ secret can be read in the same way as a bearer token:
+
+   https://api.example.com/ must run http with oauth2-token-url 'https://auth.example.com/oauth/token' with oauth2-client-id 'overseer' with oauth2-client-secret 'env:OAUTH2_SECRET' with oauth2-scopes 'read:status'
+
+ A failure to obtain the token is reported as such, rather than as a
+ failure of the API.
+
+ Extra request headers can be sent via the header setting, which may
+ be given more than once:
+
+   https://api.example.com/ must run http with header 'X-Api-Key: 1234' with header 'Accept: application/json'
+
+ To test a virtual-hosted backend directly by its IP address, the Host
+ header can be overridden:
+
+   http://10.0.0.5/ must run http with host-header 'www.example.com'
+
  If you need to disable failures due to expired, broken, or
  otherwise bogus SSL certificates you can do so via the tls setting:
 
    https://expired.badssl.com/ must run http with tls insecure
 
+ Certificates are validated against the system roots, or against the
+ certificates of a PEM file set via ca-file.  Services which require
+ mutual TLS can be sent a client certificate, with its key either in
+ the same PEM file or a separate one:
+
+   https://internal.example.com/ must run http with ca-file /etc/overseer/ca.pem with client-cert /etc/overseer/client.pem with client-key /etc/overseer/client.key
+
+ The name sent via SNI, and validated against the certificate, is the
+ hostname of the URL unless overridden:
+
+   https://10.0.0.5/ must run http with sni 'www.example.com'
+
+ Requests can be made via a HTTP, HTTPS or SOCKS5 proxy, bypassing it
+ for the hosts and domains listed in no-proxy, as with the NO_PROXY
+ environment variable:
+
+   https://internal.example.com/ must run http with proxy 'socks5://proxy.example.com:1080' with no-proxy '.local,10.0.0.0/8'
+
  By default tests will fail if you're probing an SSL-site which has
  a certificate which will expire within the next 14 days.  To change
  the time-period specify it explicitly like so, if not stated the
@@ -247,6 +449,11 @@ HTTP Tester
 
     https://steve.fi/Security/XSS/Tutorial/filter.cgi must run http with method PUT with data "text=test%20me" with content "test me"
 
+ Larger payloads can be read from a file, instead of given inline, and
+ the type of the data set via content-type:
+
+    https://api.example.com/items must run http with data-file /etc/overseer/item.json with content-type 'application/json' with status 201
+
  Do note that the HTTP-probe never follow redirections, to allow enhanced
  testing.
 
@@ -255,12 +462,42 @@ HTTP Tester
     with follow-redirect true <- max 10 follows (default)
 
     with follow-redirect 20 <- max 20 follows
+
+ The time taken by DNS lookups, to connect, to complete the TLS
+ handshake, to receive the first byte of the response, and in total, is
+ reported with the result and submitted to the metrics-host.  The
+ target itself is resolved by the worker before the test runs, so the
+ DNS phase covers the other names looked up, such as that of a proxy.
+ A slow response can be regarded as a failure via:
+
+    https://example.com/ must run http with max-response-time 2s
+
+ Or per phase, via max-connect-time, max-tls-time and max-ttfb:
+
+    https://example.com/ must run http with max-connect-time 200ms with max-ttfb 1s
+
+ The version of HTTP spoken, which is reported with the result, can
+ be forced via http-version.  The test then fails if the server, or a
+ CDN or load-balancer in front of it, negotiates another one:
+
+    https://example.com/ must run http with http-version 2
+
+ HTTP/2 is only negotiated over https.  HTTP/3 is not supported: the
+ pure-Go QUIC clients need a newer Go than overseer is built with, so
+ it is left to a separate change.
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // HTTP-test against the given URL.
+func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning how long
+// each phase of the request took alongside the result.
 //
 // For the purposes of clarity this test makes a HTTP-fetch.  The `test.Test`
 // structure contains our raw test, and the `target` variable contains the
@@ -272,7 +509,7 @@ HTTP Tester
 //
 //    target => "176.9.183.100"
 //
-func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+func (s *HTTPTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
 
 	//
 	// Determine the port to connect to, initially via the protocol
@@ -287,7 +524,7 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	port := "80"
 	u, err := url.Parse(tst.Target)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if u.Scheme == "http" {
 		port = "80"
@@ -317,16 +554,36 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	if connectTimeoutString := tst.Arguments["connect-timeout"]; connectTimeoutString != "" {
 		connectTimeout, errParse := time.ParseDuration(connectTimeoutString)
 		if errParse != nil {
-			return errParse
+			return nil, errParse
 		}
 		dialer.Timeout = connectTimeout
 	}
 
+	//
+	// Are we going via a proxy?
+	//
+	var proxy func(*url.URL) (*url.URL, error)
+	proxied := ""
+	if tst.Arguments["proxy"] != "" {
+		proxyURL, errParse := url.Parse(tst.Arguments["proxy"])
+		if errParse != nil {
+			return nil, errParse
+		}
+		proxied = proxyAddress(proxyURL)
+
+		cfg := httpproxy.Config{
+			HTTPProxy:  tst.Arguments["proxy"],
+			HTTPSProxy: tst.Arguments["proxy"],
+			NoProxy:    tst.Arguments["no-proxy"],
+		}
+		proxy = cfg.ProxyFunc()
+	}
+
 	maxConnectRetries := 0
 	if retriesString := tst.Arguments["connect-retries"]; retriesString != "" {
 		_maxDialerRetries, errParse := strconv.ParseInt(retriesString, 10, 0)
 		if errParse != nil {
-			return errParse
+			return nil, errParse
 		}
 		maxConnectRetries = int(_maxDialerRetries)
 	}
@@ -344,7 +601,10 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	// we don't rewrite anything, don't do anything manually, and
 	// instead just connect to the right IP by magic.
 	//
-	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+	// The exception is a connection to a proxy, which is made as
+	// requested, as it is the proxy which connects to the target.
+	//
+	dial := func(ctx context.Context, network, requested string) (net.Conn, error) {
 		//
 		// Assume an IPv4 address by default.
 		//
@@ -357,6 +617,10 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 			addr = fmt.Sprintf("[%s]:%s", address, port)
 		}
 
+		if proxied != "" && requested == proxied {
+			addr = requested
+		}
+
 		var conn net.Conn
 		var errDial error
 		for retryCount := 0; retryCount <= maxConnectRetries; retryCount++ {
@@ -388,11 +652,16 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	tr := &http.Transport{
 		DialContext: dial,
 	}
+	if proxy != nil {
+		tr.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
 
 	if tlsTimeoutString := tst.Arguments["tls-timeout"]; tlsTimeoutString != "" {
 		tlsTimeout, errParse := time.ParseDuration(tlsTimeoutString)
 		if errParse != nil {
-			return errParse
+			return nil, errParse
 		}
 		tr.TLSHandshakeTimeout = tlsTimeout
 	}
@@ -400,7 +669,7 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	if headerTimeoutString := tst.Arguments["resp-header-timeout"]; headerTimeoutString != "" {
 		headerTimeout, errParse := time.ParseDuration(headerTimeoutString)
 		if errParse != nil {
-			return errParse
+			return nil, errParse
 		}
 		tr.ResponseHeaderTimeout = headerTimeout
 	}
@@ -408,8 +677,53 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	//
 	// If we're running insecurely then ignore SSL errors
 	//
-	if tst.Arguments["tls"] == "insecure" {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		ServerName:         tst.Arguments["sni"],
+	}
+	tr.TLSClientConfig = tlsConfig
+
+	//
+	// The roots we trust, if not the system ones.
+	//
+	if tst.Arguments["ca-file"] != "" {
+		tlsConfig.RootCAs, err = loadCAFile(tst.Arguments["ca-file"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// The certificate we present, if the server asks for one.
+	//
+	if tst.Arguments["client-cert"] != "" {
+		key := tst.Arguments["client-key"]
+		if key == "" {
+			key = tst.Arguments["client-cert"]
+		}
+		cert, errLoad := tls.LoadX509KeyPair(tst.Arguments["client-cert"], key)
+		if errLoad != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %s", errLoad.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if tst.Arguments["client-key"] != "" {
+		return nil, fmt.Errorf("client-key requires client-cert")
+	}
+
+	//
+	// The version of HTTP we're to speak.
+	//
+	// As we dial our own connections HTTP/2 is only attempted when
+	// asked for, but we make sure it isn't for HTTP/1.1 too.
+	//
+	switch tst.Arguments["http-version"] {
+	case "1.1":
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	case "2":
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("http-version 2 requires a https URL")
+		}
+		tr.ForceAttemptHTTP2 = true
 	}
 
 	// Total request timeout
@@ -462,10 +776,28 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		method = tst.Arguments["method"]
 	}
 
+	//
+	// The data might be read from a file.
+	//
+	data := tst.Arguments["data"]
+	if tst.Arguments["data-file"] != "" {
+		if data != "" {
+			return nil, fmt.Errorf("data and data-file are mutually exclusive")
+		}
+		contents, errRead := ioutil.ReadFile(tst.Arguments["data-file"])
+		if errRead != nil {
+			return nil, errRead
+		}
+		if len(contents) == 0 {
+			return nil, fmt.Errorf("data-file %s is empty", tst.Arguments["data-file"])
+		}
+		data = string(contents)
+	}
+
 	//
 	// If we have no data then make a GET request
 	//
-	if tst.Arguments["data"] == "" {
+	if data == "" {
 		req, err = http.NewRequest(method, target, nil)
 	} else {
 
@@ -474,10 +806,23 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		// the specified data.
 		//
 		req, err = http.NewRequest(method, target,
-			bytes.NewBuffer([]byte(tst.Arguments["data"])))
+			bytes.NewBuffer([]byte(data)))
 	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	//
+	// Only one way of authenticating can be used.
+	//
+	methods := 0
+	for _, name := range []string{"username", "bearer-token", "oauth2-token-url"} {
+		if tst.Arguments[name] != "" {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return nil, fmt.Errorf("username, bearer-token and oauth2-token-url are mutually exclusive")
 	}
 
 	//
@@ -488,6 +833,47 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 			tst.Arguments["password"])
 	}
 
+	//
+	// Or a bearer token?
+	//
+	if tst.Arguments["bearer-token"] != "" {
+		token, errToken := resolveSecret(tst.Arguments["bearer-token"], opts.SecretsDir)
+		if errToken != nil {
+			return nil, fmt.Errorf("failed to read the bearer-token: %s", errToken.Error())
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	//
+	// Or a token obtained via OAuth2?
+	//
+	// The token is requested with our TLS and proxy settings, but
+	// the name sent via SNI is that of the token endpoint.
+	//
+	if tst.Arguments["oauth2-token-url"] != "" {
+		if tst.Arguments["oauth2-client-id"] == "" {
+			return nil, fmt.Errorf("oauth2-token-url requires oauth2-client-id")
+		}
+
+		tokenTLSConfig := tlsConfig.Clone()
+		tokenTLSConfig.ServerName = ""
+		token, errToken := oauth2Token(tst.Arguments, opts.SecretsDir, &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:           tr.Proxy,
+				TLSClientConfig: tokenTLSConfig,
+			},
+		})
+		if errToken != nil {
+			return nil, fmt.Errorf("failed to obtain an OAuth2 token from %s: %s", tst.Arguments["oauth2-token-url"], errToken.Error())
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if tst.Arguments["content-type"] != "" {
+		req.Header.Set("Content-Type", tst.Arguments["content-type"])
+	}
+
 	//
 	// Set a suitable user-agent
 	//
@@ -498,11 +884,34 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	}
 
 	//
-	// Perform the request
+	// Add any extra headers, replacing the ones we've set above.
+	//
+	headers := http.Header{}
+	for _, header := range ArgumentValues(tst, "header") {
+		parts := strings.SplitN(header, ":", 2)
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	for name, values := range headers {
+		if name == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+
+	if tst.Arguments["host-header"] != "" {
+		req.Host = tst.Arguments["host-header"]
+	}
+
+	//
+	// Perform the request, timing each phase of it.
 	//
+	timings := newHTTPTimings()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.trace()))
+
 	response, err := netClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	//
@@ -511,112 +920,55 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	defer response.Body.Close()
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	status := response.StatusCode
 
 	//
-	// The default status-code we accept as OK
+	// A token which has been rejected shouldn't be used again.
 	//
-	var allowedStatuses []int
-
-	//
-	// Did the user want to look for a specific status-code?
-	//
-	if tst.Arguments["status"] != "" && tst.Arguments["status"] != "any" {
-
-		split := strings.Split(tst.Arguments["status"], ",")
-		for _, statusString := range split {
-			allowedStatus, errConv := strconv.Atoi(statusString)
-			if errConv != nil {
-				return errConv
-			}
-
-			allowedStatuses = append(allowedStatuses, allowedStatus)
-		}
-
-	} else {
-
-		allowedStatuses = append(allowedStatuses, http.StatusOK)
-
+	if status == http.StatusUnauthorized && tst.Arguments["oauth2-token-url"] != "" {
+		forgetOAuth2Token(tst.Arguments, opts.SecretsDir)
 	}
 
-	//
-	// See if the status-code matched our expectation(s).
-	//
-	// If they mis-match that means the test failed, unless the user
-	// said "with status any".
-	//
-	if tst.Arguments["status"] != "any" {
-
-		found := false
-		for _, allowedStatus := range allowedStatuses {
-			if status == allowedStatus {
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			if len(allowedStatuses) == 1 {
-				return fmt.Errorf("status code was %d not %d", status, allowedStatuses[0])
-			}
-
-			return fmt.Errorf("status code was %d not one of %v", status, allowedStatuses)
-		}
+	timings.done()
+	timings.submit(opts)
+	details := fmt.Sprintf("%s, protocol: %s", timings.String(), response.Proto)
 
+	if opts.Verbose {
+		fmt.Printf("\tHTTP timings: %s\n", details)
 	}
 
 	//
-	// Is the user looking for a literal body-match?
+	// Did we speak the version of HTTP we wanted?
 	//
-	if tst.Arguments["content"] != "" {
-		if !strings.Contains(string(body), tst.Arguments["content"]) {
-			return fmt.Errorf("body didn't contain '%s'", tst.Arguments["content"])
-		}
+	if err = checkHTTPVersion(response, tst.Arguments["http-version"]); err != nil {
+		return &details, err
 	}
 
 	//
-	// Is the user NOT looking for a literal body-match?
+	// Test the response against the assertions we've been given.
 	//
-	if tst.Arguments["not-content"] != "" {
-		if strings.Contains(string(body), tst.Arguments["not-content"]) {
-			return fmt.Errorf("body contains '%s'", tst.Arguments["not-content"])
-		}
+	assertions := httpAssertions{
+		Status:      tst.Arguments["status"],
+		Content:     tst.Arguments["content"],
+		NotContent:  tst.Arguments["not-content"],
+		Pattern:     tst.Arguments["pattern"],
+		NotPattern:  tst.Arguments["not-pattern"],
+		HeaderMatch: ArgumentValues(tst, "header-match"),
+		JSONPath:    ArgumentValues(tst, "json-path"),
+		XPath:       ArgumentValues(tst, "xpath"),
+		JSONSchema:  tst.Arguments["json-schema"],
 	}
-
-	//
-	// Is the user expecting a regular expression to match the content?
-	//
-	if tst.Arguments["pattern"] != "" {
-		var re *regexp.Regexp
-		re, err = regexp.Compile("(?ms)" + tst.Arguments["pattern"])
-		if err != nil {
-			return err
-		}
-
-		// Skip unless this handler matches the filter.
-		match := re.FindAllStringSubmatch(string(body), -1)
-		if len(match) < 1 {
-			return fmt.Errorf("body didn't match the regular expression '%s'", tst.Arguments["pattern"])
-		}
+	if err = assertions.check(status, response.Header, body); err != nil {
+		return &details, err
 	}
 
 	//
-	// Is the user NOT expecting a regular expression to match the content?
+	// Was the response too slow?
 	//
-	if tst.Arguments["not-pattern"] != "" {
-		var re *regexp.Regexp
-		re, err = regexp.Compile("(?ms)" + tst.Arguments["not-pattern"])
-		if err != nil {
-			return err
-		}
-
-		// Skip unless this handler matches the filter.
-		match := re.FindAllStringSubmatch(string(body), -1)
-		if len(match) > 0 {
-			return fmt.Errorf("body matched the regular expression '%s'", tst.Arguments["not-pattern"])
-		}
+	if err = timings.check(tst.Arguments); err != nil {
+		return &details, err
 	}
 
 	//
@@ -626,153 +978,108 @@ func (s *HTTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	// certificate expiration date for any SSL sites.  We'll
 	// do that now.
 	//
-	if strings.HasPrefix(tst.Target, "https:") {
-
-		//
-		// The default expiration-time 14 days.
-		//
-		period := 14 * 24
+	if response.TLS != nil {
 
 		//
 		// If the validity was set to `any` that means we just
 		// don't care, so we don't even need to test the result.
 		//
 		if tst.Arguments["expiration"] == "any" {
-			return nil
+			return &details, nil
 		}
 
 		//
-		// The user might have specified a different period
-		// in hours / days.
+		// The expiration period, in hours.
 		//
-		expire := tst.Arguments["expiration"]
-		if expire != "" {
-
-			//
-			// How much to scale the given figure by
-			//
-			// By default if no units are specified we'll
-			// assume the figure is in days, so no scaling
-			// is required.
-			//
-			mul := 1
-
-			// Days?
-			if strings.HasSuffix(expire, "d") {
-				expire = strings.Replace(expire, "d", "", -1)
-				mul = 24
-			}
-
-			// Hours?
-			if strings.HasSuffix(expire, "h") {
-				expire = strings.Replace(expire, "h", "", -1)
-				mul = 1
-			}
-
-			// Get the period.
-			period, err = strconv.Atoi(expire)
-			if err != nil {
-				return err
-			}
-
-			//
-			// Multiply by our multiplier.
-			//
-			period *= mul
+		period, errPeriod := parseExpirationPeriod(tst.Arguments["expiration"])
+		if errPeriod != nil {
+			return &details, errPeriod
 		}
 
 		//
-		// Check the expiration
+		// Check the expiration of the certificates of the
+		// connection we've made.
 		//
-		hours, cn, errExpire := s.SSLExpiration(tst.Target, opts.Verbose)
-		if errExpire == nil {
-			// Is the age too short?
-			if int64(hours) < int64(period) {
-
-				return fmt.Errorf("SSL certificate '%s' will expire in %d hours (%d days)", cn, hours, int(hours/24))
+		// If we're running insecurely they've not been verified,
+		// so we do that now, and skip the check of any which are
+		// bogus.
+		//
+		state := *response.TLS
+		if len(state.VerifiedChains) == 0 {
+			serverName := tlsConfig.ServerName
+			if serverName == "" {
+				serverName = response.Request.URL.Hostname()
+			}
+			state.VerifiedChains = verifyPeerCertificates(state.PeerCertificates, tlsConfig.RootCAs, serverName)
+		}
+		if len(state.VerifiedChains) > 0 {
+			if err = checkCertificateExpiration(state, period, opts.Verbose); err != nil {
+				return &details, err
 			}
 		}
-
 	}
 
 	//
 	// If we reached here all is OK
 	//
-	return nil
+	return &details, nil
 }
 
-// SSLExpiration returns the number of hours remaining for a given
-// SSL certificate chain.
-func (s *HTTPTest) SSLExpiration(host string, verbose bool) (int64, string, error) {
-
-	// Expiry time, in hours
-	var hours int64
-	hours = -1
-
-	// The common-name of the certificate involved.
-	cn := ""
-
-	//
-	// If the string matches https://, then strip it off
-	//
-	re, err := regexp.Compile(`^https:\/\/([^\/]+)`)
-	if err != nil {
-		return 0, "", err
+// proxyAddress returns the address of a proxy, with the default port of
+// its scheme if none is given.
+func proxyAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
 	}
-	res := re.FindAllStringSubmatch(host, -1)
-	for _, v := range res {
-		host = v[1]
+	port := "80"
+	switch u.Scheme {
+	case "https":
+		port = "443"
+	case "socks5":
+		port = "1080"
 	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
 
-	//
-	// If no port is specified default to :443
-	//
-	p := strings.Index(host, ":")
-	if p == -1 {
-		host += ":443"
+// verifyPeerCertificates verifies the certificates sent by a server,
+// returning the verified chains, if any.
+func verifyPeerCertificates(certs []*x509.Certificate, roots *x509.CertPool, serverName string) [][]*x509.Certificate {
+	if len(certs) == 0 {
+		return nil
 	}
 
-	//
-	// Show what we're doing.
-	//
-	if verbose {
-		fmt.Printf("SSLExpiration testing: %s\n", host)
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
 	}
 
-	conn, err := tls.Dial("tcp", host, nil)
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       serverName,
+	})
 	if err != nil {
-		return 0, "", err
+		return nil
 	}
-	defer conn.Close()
-
-	timeNow := time.Now()
-	for _, chain := range conn.ConnectionState().VerifiedChains {
-		for _, cert := range chain {
-
-			// Get the expiration time, in hours.
-			expiresIn := int64(cert.NotAfter.Sub(timeNow).Hours())
-
-			if verbose {
-				fmt.Printf("SSLExpiration - certificate: %s expires in %d hours (%d days)\n", cert.Subject.CommonName, expiresIn, expiresIn/24)
-			}
+	return chains
+}
 
-			// If we've not checked anything this is the benchmark
-			if hours == -1 {
-				hours = expiresIn
-				cn = cert.Subject.CommonName
-			} else {
-				// Otherwise replace our result if the
-				// certificate is going to expire more
-				// recently than the current "winner".
-				if expiresIn < hours {
-					hours = expiresIn
-					cn = cert.Subject.CommonName
-				}
-			}
+// checkHTTPVersion tests that the response was made via the given
+// version of HTTP, if any.
+func checkHTTPVersion(response *http.Response, version string) error {
+	switch version {
+	case "1.1":
+		if response.ProtoAtLeast(1, 1) && !response.ProtoAtLeast(2, 0) {
+			return nil
 		}
+	case "2":
+		if response.ProtoMajor == 2 {
+			return nil
+		}
+	default:
+		return nil
 	}
-
-	return hours, cn, nil
+	return fmt.Errorf("negotiated %s, not HTTP/%s", response.Proto, version)
 }
 
 func (s *HTTPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
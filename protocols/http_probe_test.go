@@ -0,0 +1,695 @@
+package protocols
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"golang.org/x/net/http2"
+)
+
+// Test the request headers, tokens and bodies we send
+func TestHTTPRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if r.URL.Path == "/data" {
+			if r.Header.Get("Content-Type") != "application/json" || string(body) != `{"id": 1}` {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			return
+		}
+
+		switch {
+		case r.Header.Get("Authorization") == "Bearer secret":
+		case r.Header.Get("X-Api-Key") == "1234" && r.Header.Get("Accept") == "application/json":
+		case r.Host == "www.example.com":
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "overseer-http")
+	if err != nil {
+		t.Fatalf("Error creating data file: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"id": 1}`)
+	file.Close()
+
+	os.Setenv("OVERSEER_TEST_TOKEN", "secret")
+	defer os.Unsetenv("OVERSEER_TEST_TOKEN")
+
+	tests := []struct {
+		Path      string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{"/", map[string]string{}, false},
+		{"/", map[string]string{"bearer-token": "secret"}, true},
+		{"/", map[string]string{"bearer-token": "env:OVERSEER_TEST_TOKEN"}, true},
+		{"/", map[string]string{"bearer-token": "env:OVERSEER_TEST_MISSING"}, false},
+		{"/", map[string]string{"bearer-token": "wrong"}, false},
+		{"/", map[string]string{"header": "X-Api-Key: 1234\nAccept: application/json"}, true},
+		{"/", map[string]string{"header": "X-Api-Key: 1234"}, false},
+		{"/", map[string]string{"host-header": "www.example.com"}, true},
+		{"/", map[string]string{"header": "Host: www.example.com"}, true},
+		{"/data", map[string]string{"data-file": file.Name(), "content-type": "application/json"}, true},
+		{"/data", map[string]string{"data-file": file.Name()}, false},
+		{"/data", map[string]string{"data-file": file.Name(), "data": "x", "content-type": "application/json"}, false},
+		{"/data", map[string]string{"data-file": "/path/is/not/found"}, false},
+	}
+
+	for _, tst := range tests {
+		s := &HTTPTest{}
+		err := s.RunTest(test.Test{Target: server.URL + tst.Path, Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.Path, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.Path, tst.Arguments)
+		}
+	}
+}
+
+// Test reading secrets on the worker
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overseer-secrets")
+	if err != nil {
+		t.Fatalf("Error creating secrets directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "api.token"), []byte("from-file\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error creating secret file: %s", err.Error())
+	}
+
+	os.Setenv("OVERSEER_TEST_SECRET", "from-env")
+	defer os.Unsetenv("OVERSEER_TEST_SECRET")
+
+	tests := map[string]string{
+		"literal":                  "literal",
+		"env:OVERSEER_TEST_SECRET": "from-env",
+		"file:api.token":           "from-file",
+	}
+
+	for input, expected := range tests {
+		secret, err := resolveSecret(input, dir)
+		if err != nil {
+			t.Errorf("Unexpected error resolving '%s': %s", input, err.Error())
+		}
+		if secret != expected {
+			t.Errorf("Expected '%s' to resolve to '%s', got '%s'", input, expected, secret)
+		}
+	}
+
+	//
+	// Files are only read from the secrets directory, if there is one.
+	//
+	for _, input := range []string{"file:missing", "file:", "file:..", "file:" + filepath.Join(dir, "api.token"), "file:../" + filepath.Base(dir) + "/api.token"} {
+		if _, err := resolveSecret(input, dir); err == nil {
+			t.Errorf("Expected '%s' to fail", input)
+		}
+	}
+	if _, err := resolveSecret("file:api.token", ""); err == nil {
+		t.Errorf("Expected a file secret without a secrets directory to fail")
+	}
+}
+
+// Test the assertions on structured responses
+func TestHTTPAssertions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health.xml" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<health><status>ok</status><check name="db">ok</check></health>`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Version", "1.2.3")
+		w.Write([]byte(`{"status": "ok", "queue": {"depth": 42}, "items": [{"name": "first"}], "error": null}`))
+	}))
+	defer server.Close()
+
+	schema, err := ioutil.TempFile("", "overseer-schema")
+	if err != nil {
+		t.Fatalf("Error creating schema file: %s", err.Error())
+	}
+	defer os.Remove(schema.Name())
+	schema.WriteString(`{"type": "object", "required": ["status", "queue"], "properties": {"status": {"enum": ["ok"]}, "queue": {"type": "object"}}}`)
+	schema.Close()
+
+	strict, err := ioutil.TempFile("", "overseer-schema")
+	if err != nil {
+		t.Fatalf("Error creating schema file: %s", err.Error())
+	}
+	defer os.Remove(strict.Name())
+	strict.WriteString(`{"type": "object", "required": ["uptime"]}`)
+	strict.Close()
+
+	tests := []struct {
+		Path      string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{"/", map[string]string{"json-path": "$.status"}, true},
+		{"/", map[string]string{"json-path": "$.missing"}, false},
+		{"/", map[string]string{"json-path": `$.status == "ok"`}, true},
+		{"/", map[string]string{"json-path": `$.status == 'ok'`}, true},
+		{"/", map[string]string{"json-path": `$.status != "ok"`}, false},
+		{"/", map[string]string{"json-path": "$.queue.depth < 100\n$.queue.depth >= 42"}, true},
+		{"/", map[string]string{"json-path": "$.queue.depth < 100\n$.queue.depth > 42"}, false},
+		{"/", map[string]string{"json-path": "$.status < 100"}, false},
+		{"/", map[string]string{"json-path": `$.items[0].name =~ "^fir"`}, true},
+		{"/", map[string]string{"json-path": "items.#(name==\"first\")"}, true},
+		{"/", map[string]string{"json-path": "$.error == null"}, true},
+		{"/", map[string]string{"header-match": "Content-Type: ^application/json"}, true},
+		{"/", map[string]string{"header-match": "X-Version: ^1\\.\nContent-Type: json$"}, true},
+		{"/", map[string]string{"header-match": "X-Version: ^2\\."}, false},
+		{"/", map[string]string{"header-match": "X-Missing: .*"}, false},
+		{"/", map[string]string{"header-match": "Content-Type"}, false},
+		{"/", map[string]string{"header-match": "X-Version: ("}, false},
+		{"/", map[string]string{"json-schema": schema.Name()}, true},
+		{"/", map[string]string{"json-schema": strict.Name()}, false},
+		{"/", map[string]string{"xpath": "/health"}, false},
+		{"/health.xml", map[string]string{"xpath": `/health/status[.="ok"]`}, true},
+		{"/health.xml", map[string]string{"xpath": "count(//check) = 1\n//check[@name='db']"}, true},
+		{"/health.xml", map[string]string{"xpath": "//check[@name='cache']"}, false},
+		{"/health.xml", map[string]string{"json-path": "$.status"}, false},
+	}
+
+	for _, tst := range tests {
+		s := &HTTPTest{}
+		err := s.RunTest(test.Test{Target: server.URL + tst.Path, Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.Path, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.Path, tst.Arguments)
+		}
+	}
+}
+
+// Test the timings of a request, and their thresholds
+func TestHTTPTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		Path      string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{"/", map[string]string{"max-response-time": "1s"}, true},
+		{"/slow", map[string]string{"max-response-time": "1s"}, true},
+		{"/slow", map[string]string{"max-response-time": "50ms"}, false},
+		{"/slow", map[string]string{"max-ttfb": "50ms"}, false},
+		{"/slow", map[string]string{"max-connect-time": "1s", "max-tls-time": "1s"}, true},
+		{"/", map[string]string{"max-tls-time": "1ns"}, false},
+	}
+
+	for _, tst := range tests {
+		metrics := make(map[string]float64)
+		opts := test.Options{
+			Timeout: time.Second,
+			Metric: func(name string, value float64) {
+				metrics[name] = value
+			},
+		}
+
+		tst.Arguments["tls"] = "insecure"
+		tst.Arguments["expiration"] = "any"
+
+		s := &HTTPTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: server.URL + tst.Path, Arguments: tst.Arguments}, "127.0.0.1", opts)
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.Path, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.Path, tst.Arguments)
+		}
+		if details == nil || !strings.HasPrefix(*details, "dns: ") {
+			t.Errorf("Unexpected details for %s: %v", tst.Path, details)
+		}
+		if _, ok := metrics["dns-time"]; !ok || metrics["tls-time"] <= 0 || metrics["response-time"] < metrics["ttfb"] {
+			t.Errorf("Unexpected metrics for %s: %v", tst.Path, metrics)
+		}
+	}
+}
+
+// Test that raced dials are timed apart, and only the one which
+// succeeded counts
+func TestHTTPTimingsDials(t *testing.T) {
+	timings := newHTTPTimings()
+	trace := timings.trace()
+
+	var wg sync.WaitGroup
+	for _, addr := range []string{"[::1]:443", "127.0.0.1:443"} {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			trace.ConnectStart("tcp", addr)
+			if addr == "127.0.0.1:443" {
+				time.Sleep(20 * time.Millisecond)
+				trace.ConnectDone("tcp", addr, nil)
+			} else {
+				time.Sleep(50 * time.Millisecond)
+				trace.ConnectDone("tcp", addr, errors.New("canceled"))
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	//
+	// A dial finishing after the request is done is ignored.
+	//
+	timings.done()
+	trace.ConnectStart("tcp", "127.0.0.1:443")
+	trace.ConnectDone("tcp", "127.0.0.1:443", nil)
+
+	if timings.Connect < 20*time.Millisecond || timings.Connect >= 50*time.Millisecond {
+		t.Errorf("Unexpected connect time: %s", timings.Connect)
+	}
+}
+
+// Test forcing, and asserting, the version of HTTP spoken
+func TestHTTPVersion(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	http1 := httptest.NewTLSServer(handler)
+	defer http1.Close()
+
+	http2Server := httptest.NewUnstartedServer(handler)
+	http2Server.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	if err := http2.ConfigureServer(http2Server.Config, nil); err != nil {
+		t.Fatalf("Error configuring HTTP/2: %s", err.Error())
+	}
+	http2Server.StartTLS()
+	defer http2Server.Close()
+
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	tests := []struct {
+		URL      string
+		Version  string
+		Valid    bool
+		Protocol string
+	}{
+		{http1.URL, "", true, "HTTP/1.1"},
+		{http1.URL, "1.1", true, "HTTP/1.1"},
+		{http1.URL, "2", false, "HTTP/1.1"},
+		{http2Server.URL, "", true, "HTTP/1.1"},
+		{http2Server.URL, "1.1", true, "HTTP/1.1"},
+		{http2Server.URL, "2", true, "HTTP/2.0"},
+		{plain.URL, "1.1", true, "HTTP/1.1"},
+		{plain.URL, "2", false, ""},
+	}
+
+	for _, tst := range tests {
+		arguments := map[string]string{"tls": "insecure", "expiration": "any", "content": tst.Protocol}
+		if tst.Version != "" {
+			arguments["http-version"] = tst.Version
+		}
+
+		s := &HTTPTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: tst.URL, Arguments: arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s with version '%s' to pass, got error: %s", tst.URL, tst.Version, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s with version '%s' to fail", tst.URL, tst.Version)
+		}
+		if tst.Protocol != "" && (details == nil || !strings.HasSuffix(*details, "protocol: "+tst.Protocol)) {
+			t.Errorf("Unexpected details for %s with version '%s': %v", tst.URL, tst.Version, details)
+		}
+	}
+}
+
+// writeTestKey writes the key of a certificate to a temporary PEM file.
+func writeTestKey(t *testing.T, cert *testCertificate) string {
+	der, err := x509.MarshalECPrivateKey(cert.key.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("Error encoding key: %s", err.Error())
+	}
+
+	file, err := ioutil.TempFile("", "overseer-key")
+	if err != nil {
+		t.Fatalf("Error creating key file: %s", err.Error())
+	}
+	defer file.Close()
+
+	pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return file.Name()
+}
+
+// Test mutual TLS, private CAs and SNI
+func TestHTTPClientCertificates(t *testing.T) {
+	pki := newTestPKI(t)
+	client := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "overseer"},
+		NotAfter:    time.Now().Add(24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, pki.intermediate)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(pki.root.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{pki.leaf.cert.Raw, pki.intermediate.cert.Raw},
+			PrivateKey:  pki.leaf.key,
+		}},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	ca := writeTestPEM(t, pki.root)
+	defer os.Remove(ca)
+	cert := writeTestPEM(t, client, pki.intermediate)
+	defer os.Remove(cert)
+	key := writeTestKey(t, client)
+	defer os.Remove(key)
+
+	tests := []struct {
+		URL       string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{"https://www.example.com:" + port + "/", map[string]string{"ca-file": ca, "client-cert": cert, "client-key": key, "content": "Hello overseer"}, true},
+		{"https://www.example.com:" + port + "/", map[string]string{"ca-file": ca}, false},
+		{"https://www.example.com:" + port + "/", map[string]string{"client-cert": cert, "client-key": key}, false},
+		{"https://www.example.com:" + port + "/", map[string]string{"ca-file": ca, "client-cert": cert}, false},
+		{"https://www.example.com:" + port + "/", map[string]string{"ca-file": ca, "client-key": key}, false},
+		{"https://127.0.0.1:" + port + "/", map[string]string{"ca-file": ca, "client-cert": cert, "client-key": key}, false},
+		{"https://127.0.0.1:" + port + "/", map[string]string{"ca-file": ca, "client-cert": cert, "client-key": key, "sni": "www.example.com"}, true},
+		{"https://www.example.com:" + port + "/", map[string]string{"ca-file": ca, "client-cert": cert, "client-key": key, "expiration": "100d"}, false},
+		{"https://www.example.com:" + port + "/", map[string]string{"tls": "insecure", "client-cert": cert, "client-key": key, "expiration": "100d"}, true},
+		{"https://www.example.com:" + port + "/", map[string]string{"tls": "insecure", "ca-file": ca, "client-cert": cert, "client-key": key, "expiration": "100d"}, false},
+	}
+
+	for _, tst := range tests {
+		s := &HTTPTest{}
+		err := s.RunTest(test.Test{Target: tst.URL, Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.URL, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.URL, tst.Arguments)
+		}
+	}
+}
+
+// startSOCKS5Proxy starts a SOCKS5 proxy, which connects every request
+// to the given address regardless of the one asked for.
+func startSOCKS5Proxy(t *testing.T, backend string) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting SOCKS5 proxy: %s", err.Error())
+	}
+
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+
+		// The greeting, to which we accept no authentication
+		header := make([]byte, 2)
+		if _, errRead := io.ReadFull(conn, header); errRead != nil {
+			return
+		}
+		io.ReadFull(conn, make([]byte, header[1]))
+		conn.Write([]byte{5, 0})
+
+		// The CONNECT request, for a domain name
+		request := make([]byte, 5)
+		if _, errRead := io.ReadFull(conn, request); errRead != nil || request[3] != 3 {
+			return
+		}
+		io.ReadFull(conn, make([]byte, int(request[4])+2))
+
+		upstream, errDial := net.Dial("tcp", backend)
+		if errDial != nil {
+			conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}
+
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+// Test requests made via HTTP and SOCKS5 proxies
+func TestHTTPProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello from " + r.Host))
+	}))
+	defer backend.Close()
+
+	// A forward proxy, which answers itself
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("Proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	socks, shutdown := startSOCKS5Proxy(t, backend.Listener.Addr().String())
+	defer shutdown()
+
+	//
+	// Nothing listens on the port of the target, so the requests only
+	// work via the proxies.
+	//
+	target := "http://www.example.com:1/status"
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"proxy": proxy.URL, "content": "Proxied " + target}, true},
+		{map[string]string{"proxy": proxy.URL, "no-proxy": ".example.com"}, false},
+		{map[string]string{"proxy": proxy.URL, "no-proxy": "other.example.com"}, true},
+		{map[string]string{"proxy": "socks5://" + socks, "content": "Hello from www.example.com:1"}, true},
+		{map[string]string{"proxy": "socks5://" + socks, "no-proxy": "www.example.com"}, false},
+	}
+
+	for _, tst := range tests {
+		s := &HTTPTest{}
+		err := s.RunTest(test.Test{Target: target, Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Arguments)
+		}
+	}
+}
+
+// Test obtaining, caching and discarding OAuth2 tokens
+func TestHTTPOAuth2(t *testing.T) {
+	issued := 0
+	revoked := false
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "overseer" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		issued++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d-%s", "token_type": "bearer", "expires_in": 3600}`, issued, r.FormValue("scope"))
+	}))
+	defer auth.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if revoked || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	os.Setenv("OVERSEER_TEST_OAUTH2_SECRET", "s3cret")
+	defer os.Unsetenv("OVERSEER_TEST_OAUTH2_SECRET")
+
+	run := func(arguments map[string]string) error {
+		args := map[string]string{
+			"oauth2-token-url":     auth.URL,
+			"oauth2-client-id":     "overseer",
+			"oauth2-client-secret": "env:OVERSEER_TEST_OAUTH2_SECRET",
+		}
+		for k, v := range arguments {
+			args[k] = v
+		}
+		s := &HTTPTest{}
+		return s.RunTest(test.Test{Target: api.URL, Arguments: args}, "127.0.0.1", test.Options{Timeout: time.Second})
+	}
+
+	//
+	// The token is obtained once, and then reused.
+	//
+	if err := run(map[string]string{"content": "Bearer token-1-read write"}); err == nil {
+		t.Errorf("Expected a token without scopes")
+	}
+	if err := run(map[string]string{"oauth2-scopes": "read,write", "content": "Bearer token-2-read write"}); err != nil {
+		t.Errorf("Expected the scoped token to pass, got error: %s", err.Error())
+	}
+	if err := run(map[string]string{"oauth2-scopes": "read,write", "content": "Bearer token-2-read write"}); err != nil {
+		t.Errorf("Expected the cached token to pass, got error: %s", err.Error())
+	}
+	if issued != 2 {
+		t.Errorf("Expected 2 tokens to be issued, got %d", issued)
+	}
+
+	//
+	// A rejected token is discarded.
+	//
+	revoked = true
+	if err := run(map[string]string{"oauth2-scopes": "read,write"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected the revoked token to fail with a 401, got %v", err)
+	}
+	revoked = false
+	if err := run(map[string]string{"oauth2-scopes": "read,write", "content": "Bearer token-3-read write"}); err != nil {
+		t.Errorf("Expected a new token to pass, got error: %s", err.Error())
+	}
+
+	//
+	// Failures to obtain a token are reported distinctly.
+	//
+	err := run(map[string]string{"oauth2-client-secret": "wrong"})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to obtain an OAuth2 token") {
+		t.Errorf("Expected the token request to fail, got %v", err)
+	}
+	err = run(map[string]string{"oauth2-client-secret": "env:OVERSEER_TEST_MISSING"})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to obtain an OAuth2 token") {
+		t.Errorf("Expected the missing secret to fail, got %v", err)
+	}
+	if err = run(map[string]string{"bearer-token": "token-1"}); err == nil {
+		t.Errorf("Expected bearer-token and OAuth2 to be mutually exclusive")
+	}
+}
+
+// Test that a slow token request only holds up the tests of its client
+func TestOAuth2TokenLocking(t *testing.T) {
+	arrived := make(chan bool, 1)
+	release := make(chan bool)
+	issued := make(map[string]int)
+	var lock sync.Mutex
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _, _ := r.BasicAuth()
+		if id == "slow" {
+			arrived <- true
+			<-release
+		}
+		lock.Lock()
+		issued[id]++
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%s", "token_type": "bearer", "expires_in": 3600}`, id)
+	}))
+	defer auth.Close()
+
+	arguments := func(id string) map[string]string {
+		return map[string]string{"oauth2-token-url": auth.URL, "oauth2-client-id": id, "oauth2-client-secret": "s3cret"}
+	}
+
+	slow := make(chan error)
+	go func() {
+		_, err := oauth2Token(arguments("slow"), "", http.DefaultClient)
+		slow <- err
+	}()
+	<-arrived
+
+	//
+	// Concurrent tests of another client share a single token, while
+	// the slow request is still pending.
+	//
+	fast := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := oauth2Token(arguments("fast"), "", http.DefaultClient)
+			fast <- err
+		}()
+	}
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-fast:
+			if err != nil {
+				t.Errorf("Expected the token to be obtained, got error: %s", err.Error())
+			}
+		case <-timeout:
+			t.Errorf("Token requests were held up by another client")
+			i = 5
+		}
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Errorf("Expected the slow token to be obtained, got error: %s", err.Error())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if issued["fast"] != 1 || issued["slow"] != 1 {
+		t.Errorf("Expected a token to be issued once per client, got %v", issued)
+	}
+}
@@ -0,0 +1,146 @@
+package protocols
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// httpTimings records how long the phases of a HTTP request took.
+//
+// The DNS, connect and TLS phases are summed over any redirects which
+// are followed, the time-to-first-byte and total are measured from the
+// start of the request.
+//
+// Several connections may be dialled at once, to the IPv4 and IPv6
+// addresses of a host, so each dial is timed on its own and only those
+// which succeed count towards the connect phase.
+type httpTimings struct {
+	start    time.Time
+	dnsStart time.Time
+	tlsStart time.Time
+
+	// dials holds the start of each dial in progress, by network and
+	// address.  Dials which lose the race may still be finishing after
+	// the request is done, which is when finished gets set.
+	mutex    sync.Mutex
+	dials    map[string]time.Time
+	finished bool
+
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+// newHTTPTimings starts timing a request.
+func newHTTPTimings() *httpTimings {
+	return &httpTimings{start: time.Now(), dials: make(map[string]time.Time)}
+}
+
+// trace returns the hooks which record the timings of the request.
+func (t *httpTimings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(_ httptrace.DNSDoneInfo) {
+			t.DNS += time.Since(t.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.dials[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			start, ok := t.dials[network+" "+addr]
+			delete(t.dials, network+" "+addr)
+			if ok && err == nil && !t.finished {
+				t.Connect += time.Since(start)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			t.TLS += time.Since(t.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			t.TTFB = time.Since(t.start)
+		},
+	}
+}
+
+// done records the total time, once the body has been read.
+func (t *httpTimings) done() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.finished = true
+	t.Total = time.Since(t.start)
+}
+
+// String returns the timings, as the details of a result.
+func (t *httpTimings) String() string {
+	return fmt.Sprintf("dns: %s, connect: %s, tls: %s, ttfb: %s, total: %s",
+		formatMilliseconds(t.DNS), formatMilliseconds(t.Connect), formatMilliseconds(t.TLS),
+		formatMilliseconds(t.TTFB), formatMilliseconds(t.Total))
+}
+
+// submit sends the timings to the metrics-host, if there is one.
+func (t *httpTimings) submit(opts test.Options) {
+	if opts.Metric == nil {
+		return
+	}
+	opts.Metric("dns-time", milliseconds(t.DNS))
+	opts.Metric("connect-time", milliseconds(t.Connect))
+	opts.Metric("tls-time", milliseconds(t.TLS))
+	opts.Metric("ttfb", milliseconds(t.TTFB))
+	opts.Metric("response-time", milliseconds(t.Total))
+}
+
+// check fails if any phase took longer than its threshold, if set in
+// the given arguments.
+func (t *httpTimings) check(arguments map[string]string) error {
+	thresholds := []struct {
+		Argument string
+		Phase    string
+		Value    time.Duration
+	}{
+		{"max-connect-time", "connecting", t.Connect},
+		{"max-tls-time", "the TLS handshake", t.TLS},
+		{"max-ttfb", "the first byte", t.TTFB},
+		{"max-response-time", "the response", t.Total},
+	}
+
+	for _, threshold := range thresholds {
+		if arguments[threshold.Argument] == "" {
+			continue
+		}
+		limit, err := time.ParseDuration(arguments[threshold.Argument])
+		if err != nil {
+			return err
+		}
+		if threshold.Value > limit {
+			return fmt.Errorf("%s took %s, exceeding the %s of %s", threshold.Phase, formatMilliseconds(threshold.Value), threshold.Argument, limit)
+		}
+	}
+	return nil
+}
+
+// milliseconds returns a duration in milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// formatMilliseconds formats a duration in milliseconds, as the worker
+// does for period-tests.
+func formatMilliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", milliseconds(d))
+}
@@ -8,6 +8,15 @@
 //
 //    host.example.com must run imap [with username 'steve@steve' with password 'secret']
 //
+// The certificate offered via STARTTLS can be checked to not expire
+// within the given period, as for the ssl test:
+//
+//    host.example.com must run imap with expiration 14d
+//
+// If the certificate is self-signed or otherwise non-trusted, only its
+// expiration is checked when using `with tls insecure`, and neither
+// its chain nor its hostname.
+//
 
 package protocols
 
@@ -30,9 +39,11 @@ type IMAPTest struct {
 // their values.
 func (s *IMAPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"username": ".*",
-		"password": ".*",
+		"port":       "^[0-9]+$",
+		"username":   ".*",
+		"password":   ".*",
+		"expiration": "^([0-9]+[hd]?)$",
+		"tls":        "insecure",
 	}
 	return known
 }
@@ -55,6 +66,15 @@ IMAP Tester
  This test is invoked via input like so:
 
     host.example.com must run imap
+
+ The certificate offered via STARTTLS can be checked to not expire
+ within the given period, as for the ssl test:
+
+    host.example.com must run imap with expiration 14d
+
+ If the certificate is self-signed or otherwise non-trusted, only its
+ expiration is checked when using 'with tls insecure', and neither
+ its chain nor its hostname.
 `
 	return str
 }
@@ -125,6 +145,15 @@ func (s *IMAPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		}
 	}
 
+	//
+	// Check the certificate offered via STARTTLS.
+	//
+	if tst.Arguments["expiration"] != "" {
+		if err = checkSTARTTLSExpiration("imap", address, tst, opts, tst.Arguments["tls"] == "insecure", true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
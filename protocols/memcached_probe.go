@@ -0,0 +1,368 @@
+// Memcached Tester
+//
+// The memcached tester connects to a remote host, speaking the text
+// protocol, and ensures that a value can be stored and retrieved again.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run memcached [with port 11211]
+//
+// The round-trip uses a short-lived key in the `overseer.probe.` namespace,
+// so it will never clash with keys used by your applications.
+//
+// Optionally you may make assertions upon the output of the `stats` command:
+//
+//    # Fail if, on average, more than 5 items per second have been evicted
+//    host.example.com must run memcached with max-evictions-rate 5
+//
+//    # Fail if fewer than 100 connection slots are still available
+//    host.example.com must run memcached with min-free-connections 100
+//
+// The evictions rate is measured since the previous test of the server,
+// by this worker.  The first test, and the first after a restart of the
+// server, uses the average since the server was started.
+//
+
+package protocols
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// MemcachedTest is our object.
+type MemcachedTest struct {
+}
+
+// memcachedSample is the evictions counter of a server, and its uptime
+// when read.
+type memcachedSample struct {
+	evictions int64
+	uptime    int64
+}
+
+// memcachedSamples holds the last sample taken of each server, by address,
+// from which the evictions rate is measured.
+var memcachedSamples = struct {
+	m map[string]memcachedSample
+	sync.Mutex
+}{m: make(map[string]memcachedSample)}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *MemcachedTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":                 "^[0-9]+$",
+		"max-evictions-rate":   `^[0-9]+(\.[0-9]+)?$`,
+		"min-free-connections": "^[0-9]+$",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *MemcachedTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *MemcachedTest) Example() string {
+	str := `
+Memcached Tester
+----------------
+ The memcached tester connects to a remote host, speaking the text
+ protocol, and ensures that a value can be stored and retrieved again.
+
+ This test is invoked via input like so:
+
+    host.example.com must run memcached [with port 11211]
+
+ The round-trip uses a short-lived key in the 'overseer.probe.' namespace,
+ so it will never clash with keys used by your applications.
+
+ Optionally you may make assertions upon the output of the 'stats' command:
+
+    # Fail if, on average, more than 5 items per second have been evicted
+    host.example.com must run memcached with max-evictions-rate 5
+
+    # Fail if fewer than 100 connection slots are still available
+    host.example.com must run memcached with min-free-connections 100
+
+ The evictions rate is measured since the previous test of the server,
+ by this worker.  The first test, and the first after a restart of the
+ server, uses the average since the server was started.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we make a TCP connection, defaulting to port 11211, store
+// a random value, read it back and then check the server statistics.
+func (s *MemcachedTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	//
+	// The default port to connect to.
+	//
+	port := 11211
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//
+	// Set an explicit timeout
+	//
+	d := net.Dialer{Timeout: opts.Timeout}
+
+	//
+	// Make the TCP connection.
+	//
+	address := net.JoinHostPort(target, strconv.Itoa(port))
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	//
+	// The whole conversation must complete within the timeout.
+	//
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	//
+	// Generate a random key & value for our round-trip.
+	//
+	random := make([]byte, 8)
+	if _, err = rand.Read(random); err != nil {
+		return err
+	}
+	key := "overseer.probe." + hex.EncodeToString(random)
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	//
+	// Store the value, with a short expiry so that we don't leave
+	// junk behind if the deletion fails.
+	//
+	reply, err := s.command(rw, fmt.Sprintf("set %s 0 60 %d\r\n%s", key, len(value), value))
+	if err != nil {
+		return err
+	}
+	if reply != "STORED" {
+		return fmt.Errorf("failed to store key '%s': %s", key, reply)
+	}
+
+	//
+	// Read it back.
+	//
+	reply, err = s.command(rw, fmt.Sprintf("get %s", key))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reply, "VALUE ") {
+		return fmt.Errorf("failed to retrieve key '%s': %s", key, reply)
+	}
+	data, err := rw.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(data, "\r\n") != value {
+		return fmt.Errorf("retrieved value for key '%s' didn't match the stored one", key)
+	}
+	end, err := rw.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(end, "\r\n") != "END" {
+		return fmt.Errorf("unexpected response terminator: %s", end)
+	}
+
+	//
+	// Cleanup.
+	//
+	reply, err = s.command(rw, fmt.Sprintf("delete %s", key))
+	if err != nil {
+		return err
+	}
+	if reply != "DELETED" {
+		return fmt.Errorf("failed to delete key '%s': %s", key, reply)
+	}
+
+	//
+	// If there are no stats-based assertions we're done.
+	//
+	if tst.Arguments["max-evictions-rate"] == "" && tst.Arguments["min-free-connections"] == "" {
+		return nil
+	}
+
+	stats, err := s.stats(rw, "stats")
+	if err != nil {
+		return err
+	}
+
+	//
+	// The eviction-rate is the number of evictions per second since
+	// our previous sample of the server.
+	//
+	if tst.Arguments["max-evictions-rate"] != "" {
+		maxRate, errParse := strconv.ParseFloat(tst.Arguments["max-evictions-rate"], 64)
+		if errParse != nil {
+			return errParse
+		}
+
+		evictions, errStat := s.statInt(stats, "evictions")
+		if errStat != nil {
+			return errStat
+		}
+		uptime, errStat := s.statInt(stats, "uptime")
+		if errStat != nil {
+			return errStat
+		}
+
+		rate := evictionsRate(address, memcachedSample{evictions: evictions, uptime: uptime})
+
+		if rate > maxRate {
+			return fmt.Errorf("evictions rate is %.2f/s, above the max allowed of %.2f/s", rate, maxRate)
+		}
+	}
+
+	//
+	// The free connections are the difference between the max allowed
+	// and the currently open ones.
+	//
+	if tst.Arguments["min-free-connections"] != "" {
+		minFree, errParse := strconv.ParseInt(tst.Arguments["min-free-connections"], 10, 64)
+		if errParse != nil {
+			return errParse
+		}
+
+		current, errStat := s.statInt(stats, "curr_connections")
+		if errStat != nil {
+			return errStat
+		}
+
+		//
+		// Older servers only report the limit via "stats settings".
+		//
+		if _, ok := stats["max_connections"]; !ok {
+			settings, errSettings := s.stats(rw, "stats settings")
+			if errSettings != nil {
+				return errSettings
+			}
+			stats["max_connections"] = settings["maxconns"]
+		}
+
+		maxConns, errStat := s.statInt(stats, "max_connections")
+		if errStat != nil {
+			return errStat
+		}
+
+		if free := maxConns - current; free < minFree {
+			return fmt.Errorf("only %d free connections available (%d/%d used), below the min required of %d", free, current, maxConns, minFree)
+		}
+	}
+
+	return nil
+}
+
+// evictionsRate returns the evictions per second since the previous
+// sample of the server at the given address, recording the new one.
+//
+// Without a usable previous sample, because this is the first or the
+// server has restarted since, the average since it started is used.
+func evictionsRate(address string, sample memcachedSample) float64 {
+	memcachedSamples.Lock()
+	previous, ok := memcachedSamples.m[address]
+	memcachedSamples.m[address] = sample
+	memcachedSamples.Unlock()
+
+	if ok && sample.uptime > previous.uptime && sample.evictions >= previous.evictions {
+		return float64(sample.evictions-previous.evictions) / float64(sample.uptime-previous.uptime)
+	}
+	if sample.uptime > 0 {
+		return float64(sample.evictions) / float64(sample.uptime)
+	}
+	return float64(sample.evictions)
+}
+
+// command sends a single command to the server, and returns the first
+// line of the reply.
+func (s *MemcachedTest) command(rw *bufio.ReadWriter, cmd string) (string, error) {
+	if _, err := rw.WriteString(cmd + "\r\n"); err != nil {
+		return "", err
+	}
+	if err := rw.Flush(); err != nil {
+		return "", err
+	}
+
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stats runs the given stats-command, returning the reported values.
+func (s *MemcachedTest) stats(rw *bufio.ReadWriter, cmd string) (map[string]string, error) {
+	stats := make(map[string]string)
+
+	line, err := s.command(rw, cmd)
+	for ; err == nil; line, err = rw.ReadString('\n') {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "END" {
+			return stats, nil
+		}
+
+		// Each line is in the form "STAT name value"
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			return nil, fmt.Errorf("unexpected stats response: %s", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+	return nil, err
+}
+
+// statInt returns the named statistic as an integer.
+func (s *MemcachedTest) statInt(stats map[string]string, name string) (int64, error) {
+	val, ok := stats[name]
+	if !ok || val == "" {
+		return 0, errors.New("stats didn't report " + name)
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+func (s *MemcachedTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("memcached", func() ProtocolTest {
+		return &MemcachedTest{}
+	})
+}
@@ -0,0 +1,189 @@
+package protocols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// testMemcachedServer is a stand-in for memcached, speaking enough of the
+// text protocol for our round-trip and stats.
+type testMemcachedServer struct {
+	sync.Mutex
+	stats    map[string]string
+	settings map[string]string
+	items    map[string]string
+}
+
+// setStat changes one of the reported statistics.
+func (m *testMemcachedServer) setStat(name string, value string) {
+	m.Lock()
+	defer m.Unlock()
+	m.stats[name] = value
+}
+
+// serve answers the commands sent over the connection.
+func (m *testMemcachedServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		m.Lock()
+		switch {
+		case fields[0] == "set" && len(fields) == 5:
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, data); err != nil {
+				m.Unlock()
+				return
+			}
+			m.items[fields[1]] = string(data[:size])
+			fmt.Fprintf(conn, "STORED\r\n")
+		case fields[0] == "get" && len(fields) == 2:
+			if value, ok := m.items[fields[1]]; ok {
+				fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+			}
+			fmt.Fprintf(conn, "END\r\n")
+		case fields[0] == "delete" && len(fields) == 2:
+			delete(m.items, fields[1])
+			fmt.Fprintf(conn, "DELETED\r\n")
+		case fields[0] == "stats":
+			stats := m.stats
+			if len(fields) == 2 && fields[1] == "settings" {
+				stats = m.settings
+			}
+			for name, value := range stats {
+				fmt.Fprintf(conn, "STAT %s %s\r\n", name, value)
+			}
+			fmt.Fprintf(conn, "END\r\n")
+		default:
+			fmt.Fprintf(conn, "ERROR\r\n")
+		}
+		m.Unlock()
+	}
+}
+
+// start listens for connections, returning the port.
+func (m *testMemcachedServer) start(t *testing.T) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port, func() { listener.Close() }
+}
+
+// Test the round-trip, and the stats-based assertions
+func TestMemcached(t *testing.T) {
+	server := &testMemcachedServer{
+		stats:    map[string]string{"uptime": "1000", "evictions": "1000", "curr_connections": "900"},
+		settings: map[string]string{"maxconns": "1024"},
+		items:    make(map[string]string),
+	}
+	port, stop := server.start(t)
+	defer stop()
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"min-free-connections": "100"}, true},
+		{map[string]string{"min-free-connections": "200"}, false},
+		{map[string]string{"max-evictions-rate": "0.5"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["port"] = port
+
+		s := &MemcachedTest{}
+		err := s.RunTest(test.Test{Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Arguments)
+		}
+	}
+
+	//
+	// The rate is measured since the previous sample, so a storm of
+	// evictions shows up despite the long uptime.
+	//
+	s := &MemcachedTest{}
+	arguments := map[string]string{"port": port, "max-evictions-rate": "5"}
+
+	server.setStat("uptime", "1010")
+	server.setStat("evictions", "1020")
+	if err := s.RunTest(test.Test{Arguments: arguments}, "127.0.0.1", test.Options{Timeout: time.Second}); err != nil {
+		t.Errorf("Expected 2 evictions/s to pass, got error: %s", err.Error())
+	}
+
+	server.setStat("uptime", "1020")
+	server.setStat("evictions", "1520")
+	if err := s.RunTest(test.Test{Arguments: arguments}, "127.0.0.1", test.Options{Timeout: time.Second}); err == nil {
+		t.Errorf("Expected 50 evictions/s to fail")
+	}
+
+	//
+	// After a restart the average since then is used.
+	//
+	server.setStat("uptime", "100")
+	server.setStat("evictions", "100")
+	if err := s.RunTest(test.Test{Arguments: arguments}, "127.0.0.1", test.Options{Timeout: time.Second}); err != nil {
+		t.Errorf("Expected 1 eviction/s after a restart to pass, got error: %s", err.Error())
+	}
+}
+
+// Test measuring the evictions rate between samples
+func TestEvictionsRate(t *testing.T) {
+	address := "192.0.2.1:11211"
+
+	tests := []struct {
+		Sample memcachedSample
+		Rate   float64
+	}{
+		{memcachedSample{evictions: 3600, uptime: 3600}, 1},
+		{memcachedSample{evictions: 3600, uptime: 3660}, 0},
+		{memcachedSample{evictions: 4200, uptime: 3720}, 10},
+		{memcachedSample{evictions: 4200, uptime: 3720}, 4200.0 / 3720},
+		{memcachedSample{evictions: 50, uptime: 10}, 5},
+		{memcachedSample{evictions: 0, uptime: 0}, 0},
+	}
+
+	for _, tst := range tests {
+		rate := evictionsRate(address, tst.Sample)
+		if rate != tst.Rate {
+			t.Errorf("Expected a rate of %f for %v, got %f", tst.Rate, tst.Sample, rate)
+		}
+	}
+}
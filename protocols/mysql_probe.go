@@ -8,15 +8,43 @@
 // Specifying a username and password is mandatory, because otherwise we
 // cannot connect to the database.
 //
+// A query can be run, in the given database, and its result tested.
+// The first column of the first row must equal the value given via
+// expect, be compared to a number, or match a regular expression:
+//
+//    db.example.com must run mysql with username 'overseer' with password 'test' with database 'shop' with query 'SELECT COUNT(*) FROM orders WHERE shipped_at IS NULL' with expect '< 100'
+//
+//    db.example.com must run mysql with username 'overseer' with password 'test' with query 'SELECT @@version' with expect '=~ ^8\.'
+//
+// There are also checks built in.  A replica can be tested to be no
+// more than the given number of seconds, or duration, behind:
+//
+//    replica.example.com must run mysql with username 'overseer' with password 'test' with max-replication-lag 30s
+//
+// A primary can be tested to accept writes, or a replica to refuse
+// them, via read-only:
+//
+//    db.example.com must run mysql with username 'overseer' with password 'test' with read-only false
+//
+// And the test can fail if more than the given percentage of the
+// allowed connections are in use:
+//
+//    db.example.com must run mysql with username 'overseer' with password 'test' with max-connections-used 80%
+//
+// The user needs the REPLICATION CLIENT privilege for the replication
+// check.
+//
 
 package protocols
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 	"github.com/go-sql-driver/mysql"
@@ -31,9 +59,15 @@ type MYSQLTest struct {
 // their values.
 func (s *MYSQLTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"username": ".*",
-		"password": ".*",
+		"port":                 "^[0-9]+$",
+		"username":             ".*",
+		"password":             ".*",
+		"database":             ".*",
+		"query":                ".+",
+		"expect":               ".+",
+		"read-only":            "^(true|false)$",
+		"max-replication-lag":  "^[0-9]+(ms|s|m|h)?$",
+		"max-connections-used": "^[0-9]+%?$",
 	}
 	return known
 }
@@ -55,23 +89,59 @@ MySQL Tester
 
  Specifying a username and password is mandatory, because otherwise we
  cannot connect to the database.
+
+ A query can be run, in the given database, and its result tested.
+ The first column of the first row must equal the value given via
+ expect, be compared to a number, or match a regular expression:
+
+    db.example.com must run mysql with username 'overseer' with password 'test' with database 'shop' with query 'SELECT COUNT(*) FROM orders WHERE shipped_at IS NULL' with expect '< 100'
+
+    db.example.com must run mysql with username 'overseer' with password 'test' with query 'SELECT @@version' with expect '=~ ^8\.'
+
+ There are also checks built in.  A replica can be tested to be no
+ more than the given number of seconds, or duration, behind:
+
+    replica.example.com must run mysql with username 'overseer' with password 'test' with max-replication-lag 30s
+
+ A primary can be tested to accept writes, or a replica to refuse
+ them, via read-only:
+
+    db.example.com must run mysql with username 'overseer' with password 'test' with read-only false
+
+ And the test can fail if more than the given percentage of the
+ allowed connections are in use:
+
+    db.example.com must run mysql with username 'overseer' with password 'test' with max-connections-used 80%
+
+ The user needs the REPLICATION CLIENT privilege for the replication
+ check.
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
+func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// result of the query, and of the built-in checks, alongside the result.
 //
 // In this case we make a TCP connection to the host and attempt to login
 // with the specified username & password.
-func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) error {
+func (s *MYSQLTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
 	var err error
 
 	//
 	// The password might be blank, but the username is required.
 	//
 	if tst.Arguments["username"] == "" {
-		return errors.New("no username specified")
+		return nil, errors.New("no username specified")
+	}
+	if err = checkSQLArguments(tst.Arguments); err != nil {
+		return nil, err
 	}
 
 	//
@@ -82,10 +152,15 @@ func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) err
 	if tst.Arguments["port"] != "" {
 		port, err = strconv.Atoi(tst.Arguments["port"])
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
 	//
 	// Create a default configuration structure for MySQL.
 	//
@@ -94,13 +169,14 @@ func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) err
 	//
 	// Setup the connection timeout
 	//
-	config.Timeout = opts.Timeout
+	config.Timeout = timeout
 
 	//
 	// Populate the username & password fields.
 	//
 	config.User = tst.Arguments["username"]
 	config.Passwd = tst.Arguments["password"]
+	config.DBName = tst.Arguments["database"]
 
 	//
 	// Default to connecting to an IPv4-address
@@ -138,15 +214,87 @@ func (s *MYSQLTest) RunTest(tst test.Test, target string, opts test.Options) err
 	//
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer db.Close()
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	//
 	// And test that the connection actually worked.
 	//
-	err = db.Ping()
-	return err
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//
+	// Then make the checks we've been asked to.
+	//
+	return runSQLChecks(ctx, db, mysqlDialect, tst.Arguments)
+}
+
+// mysqlDialect is how the built-in checks are made of MySQL.
+var mysqlDialect = sqlDialect{
+	readOnly: func(ctx context.Context, db *sql.DB) (bool, error) {
+		value, err := sqlScalar(ctx, db, "SELECT @@global.read_only")
+		if err != nil {
+			return false, err
+		}
+		return value.String == "1", nil
+	},
+
+	connections: func(ctx context.Context, db *sql.DB) (float64, float64, error) {
+		row, err := sqlRow(ctx, db, "SHOW GLOBAL STATUS LIKE 'Threads_connected'")
+		if err != nil {
+			return 0, 0, err
+		}
+		if row == nil {
+			return 0, 0, errors.New("failed to find the number of connections")
+		}
+		used, err := strconv.ParseFloat(row["Value"].String, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		value, err := sqlScalar(ctx, db, "SELECT @@global.max_connections")
+		if err != nil {
+			return 0, 0, err
+		}
+		max, err := strconv.ParseFloat(value.String, 64)
+		return used, max, err
+	},
+
+	//
+	// Newer releases have renamed the slaves to replicas, and the
+	// masters to sources.
+	//
+	replicationLag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+		row, err := sqlRow(ctx, db, "SHOW REPLICA STATUS")
+		if err != nil {
+			row, err = sqlRow(ctx, db, "SHOW SLAVE STATUS")
+		}
+		if err != nil {
+			return 0, err
+		}
+		if row == nil {
+			return 0, errors.New("database is not a replica")
+		}
+
+		lag, ok := row["Seconds_Behind_Source"]
+		if !ok {
+			lag = row["Seconds_Behind_Master"]
+		}
+		if !lag.Valid {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := strconv.Atoi(lag.String)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	},
 }
 
 func (s *MYSQLTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
@@ -0,0 +1,269 @@
+// NTP Tester
+//
+// The NTP tester sends an SNTP query to a remote host, and ensures that
+// the server is synchronised and that its clock agrees with the one of
+// the worker.
+//
+// This test is invoked via input like so:
+//
+//    pool.ntp.org must run ntp [with port 123]
+//
+// A server which reports itself as unsynchronised is always a failure.
+//
+// To fail if the server is too far away from the reference clocks:
+//
+//    pool.ntp.org must run ntp with max-stratum 3
+//
+// To fail if the server clock drifted away from the worker one:
+//
+//    pool.ntp.org must run ntp with max-offset 500ms
+//
+// The measured offset, round-trip delay and stratum are reported in the
+// details of the test result.
+//
+
+package protocols
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+// and the Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// NTPTest is our object.
+type NTPTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *NTPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":        "^[0-9]+$",
+		"max-stratum": "^([1-9]|1[0-5])$",
+		"max-offset":  `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *NTPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *NTPTest) Example() string {
+	str := `
+NTP Tester
+----------
+ The NTP tester sends an SNTP query to a remote host, and ensures that
+ the server is synchronised and that its clock agrees with the one of
+ the worker.
+
+ This test is invoked via input like so:
+
+    pool.ntp.org must run ntp [with port 123]
+
+ A server which reports itself as unsynchronised is always a failure.
+
+ To fail if the server is too far away from the reference clocks:
+
+    pool.ntp.org must run ntp with max-stratum 3
+
+ To fail if the server clock drifted away from the worker one:
+
+    pool.ntp.org must run ntp with max-offset 500ms
+
+ The measured offset, round-trip delay and stratum are reported in the
+ details of the test result.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+func (s *NTPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// measured values alongside the result.
+//
+// In this case we send a single SNTP query, defaulting to port 123, and
+// compare the timestamps in the reply with our own clock.
+func (s *NTPTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
+	var err error
+
+	//
+	// The default port to connect to.
+	//
+	port := 123
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// NTP servers are synchronised when their stratum is within 1-15.
+	//
+	maxStratum := 15
+	if tst.Arguments["max-stratum"] != "" {
+		maxStratum, err = strconv.Atoi(tst.Arguments["max-stratum"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var maxOffset time.Duration
+	if tst.Arguments["max-offset"] != "" {
+		maxOffset, err = time.ParseDuration(tst.Arguments["max-offset"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// Set an explicit timeout
+	//
+	d := net.Dialer{Timeout: opts.Timeout}
+
+	conn, err := d.Dial("udp", net.JoinHostPort(target, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+
+	//
+	// Build the request: leap-indicator 0, version 4, client-mode.
+	//
+	// The transmit-timestamp is random, rather than our real clock, to
+	// avoid leaking it, and is only used to match the reply.
+	//
+	req := make([]byte, 48)
+	req[0] = 0<<6 | 4<<3 | 3
+	if _, err = rand.Read(req[40:48]); err != nil {
+		return nil, err
+	}
+
+	t1 := time.Now()
+	if _, err = conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	t4 := time.Now()
+
+	if n < 48 {
+		return nil, fmt.Errorf("short NTP response of %d bytes", n)
+	}
+
+	//
+	// Ensure this is the reply to our request.
+	//
+	if mode := resp[0] & 0x07; mode != 4 {
+		return nil, fmt.Errorf("unexpected NTP response mode %d", mode)
+	}
+	if binary.BigEndian.Uint64(resp[24:32]) != binary.BigEndian.Uint64(req[40:48]) {
+		return nil, errors.New("NTP response doesn't match our request")
+	}
+
+	leap := resp[0] >> 6
+	stratum := int(resp[1])
+
+	//
+	// Stratum 0 is a "kiss-of-death" packet, where the reference-id
+	// contains the reason.
+	//
+	if stratum == 0 {
+		return nil, fmt.Errorf("NTP server sent a kiss-of-death: %s", string(resp[12:16]))
+	}
+
+	//
+	// Compare our send/receive times with the receive/transmit
+	// timestamps of the server, as described in RFC 4330.
+	//
+	t2 := s.ntpTime(resp[32:40])
+	t3 := s.ntpTime(resp[40:48])
+
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+
+	details := fmt.Sprintf("offset: %s, delay: %s, stratum: %d", offset, delay, stratum)
+
+	if opts.Verbose {
+		fmt.Printf("NTP %s\n", details)
+	}
+
+	if leap == 3 || stratum > 15 {
+		return &details, errors.New("NTP server is unsynchronised")
+	}
+
+	if stratum > maxStratum {
+		return &details, fmt.Errorf("NTP stratum %d is above the max allowed of %d", stratum, maxStratum)
+	}
+
+	if maxOffset > 0 {
+		abs := offset
+		if abs < 0 {
+			abs = -abs
+		}
+		if abs > maxOffset {
+			return &details, fmt.Errorf("NTP offset %s is above the max allowed of %s", offset, maxOffset)
+		}
+	}
+
+	return &details, nil
+}
+
+// ntpTime converts a 64-bit NTP timestamp to a time.
+//
+// Timestamps with the most-significant bit unset are assumed to belong to
+// the era starting in 2036, as suggested by RFC 4330.
+func (s *NTPTest) ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4]))
+	if seconds&0x80000000 == 0 {
+		seconds += 1 << 32
+	}
+	seconds -= ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	nanos := (fraction * int64(time.Second)) >> 32
+	return time.Unix(seconds, nanos)
+}
+
+func (s *NTPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("ntp", func() ProtocolTest {
+		return &NTPTest{}
+	})
+}
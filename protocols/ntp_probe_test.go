@@ -0,0 +1,131 @@
+package protocols
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// testNTPServer describes how our stand-in NTP server replies.
+type testNTPServer struct {
+	leap     byte
+	stratum  byte
+	refID    string
+	offset   time.Duration
+	mismatch bool
+	short    bool
+}
+
+// ntpTimestamp encodes a time as a 64-bit NTP timestamp.
+func ntpTimestamp(b []byte, t time.Time) {
+	seconds := uint64(t.Unix()+ntpEpochOffset) & 0xffffffff
+	fraction := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	binary.BigEndian.PutUint32(b[0:4], uint32(seconds))
+	binary.BigEndian.PutUint32(b[4:8], uint32(fraction))
+}
+
+// start replies to each query received, returning the port.
+func (n *testNTPServer) start(t *testing.T) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting NTP service: %s", err.Error())
+	}
+
+	go func() {
+		req := make([]byte, 48)
+		for {
+			_, addr, errRead := conn.ReadFrom(req)
+			if errRead != nil {
+				return
+			}
+
+			now := time.Now().Add(n.offset)
+			resp := make([]byte, 48)
+			resp[0] = n.leap<<6 | 4<<3 | 4
+			resp[1] = n.stratum
+			copy(resp[12:16], n.refID)
+			copy(resp[24:32], req[40:48])
+			if n.mismatch {
+				resp[24] ^= 0xff
+			}
+			ntpTimestamp(resp[32:40], now)
+			ntpTimestamp(resp[40:48], now)
+
+			if n.short {
+				resp = resp[:40]
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port), func() { conn.Close() }
+}
+
+// Test the NTP-probe against local responders
+func TestNTP(t *testing.T) {
+	tests := []struct {
+		Server    testNTPServer
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{testNTPServer{stratum: 2}, map[string]string{}, true},
+		{testNTPServer{stratum: 2}, map[string]string{"max-stratum": "2"}, true},
+		{testNTPServer{stratum: 3}, map[string]string{"max-stratum": "2"}, false},
+		{testNTPServer{stratum: 16}, map[string]string{}, false},
+		{testNTPServer{stratum: 2, leap: 3}, map[string]string{}, false},
+		{testNTPServer{stratum: 0, refID: "RATE"}, map[string]string{}, false},
+		{testNTPServer{stratum: 2, offset: 2 * time.Second}, map[string]string{"max-offset": "500ms"}, false},
+		{testNTPServer{stratum: 2, offset: -2 * time.Second}, map[string]string{"max-offset": "500ms"}, false},
+		{testNTPServer{stratum: 2, offset: 2 * time.Second}, map[string]string{"max-offset": "5s"}, true},
+		{testNTPServer{stratum: 2, mismatch: true}, map[string]string{}, false},
+		{testNTPServer{stratum: 2, short: true}, map[string]string{}, false},
+	}
+
+	for _, tst := range tests {
+		server := tst.Server
+		port, stop := server.start(t)
+
+		tst.Arguments["port"] = port
+
+		s := &NTPTest{}
+		details, err := s.RunTestWithDetails(test.Test{Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+		stop()
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %+v %v to pass, got error: %s", tst.Server, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %+v %v to fail", tst.Server, tst.Arguments)
+		}
+		if tst.Valid && (details == nil || !strings.HasPrefix(*details, "offset: ")) {
+			t.Errorf("Unexpected details for %+v: %v", tst.Server, details)
+		}
+		if tst.Server.stratum == 0 && (err == nil || !strings.Contains(err.Error(), "RATE")) {
+			t.Errorf("Expected the kiss-of-death code to be reported, got %v", err)
+		}
+	}
+}
+
+// Test the conversion of NTP timestamps
+func TestNTPTime(t *testing.T) {
+	s := &NTPTest{}
+
+	for _, when := range []time.Time{
+		time.Date(2020, 5, 1, 12, 30, 15, 500000000, time.UTC),
+		time.Date(2036, 2, 7, 6, 28, 16, 0, time.UTC),
+		time.Date(2040, 1, 1, 0, 0, 0, 250000000, time.UTC),
+	} {
+		b := make([]byte, 8)
+		ntpTimestamp(b, when)
+
+		got := s.ntpTime(b)
+		if diff := got.Sub(when); diff > time.Microsecond || diff < -time.Microsecond {
+			t.Errorf("Expected %s, got %s", when, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package protocols
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// oauth2Entry is a token in our cache.  Its own lock is held while it is
+// obtained, so that tests sharing a client don't all fetch a token,
+// without holding up the tests of other clients.
+type oauth2Entry struct {
+	token *oauth2.Token
+	sync.Mutex
+}
+
+// This is a map of the OAuth2 tokens obtained by this worker, which are
+// reused until they expire.
+var oauth2Tokens = struct {
+	m map[string]*oauth2Entry
+	sync.Mutex
+}{m: make(map[string]*oauth2Entry)}
+
+// oauth2CacheEntry returns the entry of our cache with the given key,
+// adding it if needed.
+func oauth2CacheEntry(key string) *oauth2Entry {
+	oauth2Tokens.Lock()
+	defer oauth2Tokens.Unlock()
+
+	entry, ok := oauth2Tokens.m[key]
+	if !ok {
+		entry = &oauth2Entry{}
+		oauth2Tokens.m[key] = entry
+	}
+	return entry
+}
+
+// oauth2Config returns the client-credentials configuration given by the
+// oauth2-* arguments, along with the key of its tokens in our cache.
+//
+// The client secret may be read from the environment or a file in the
+// given secrets directory, as with resolveSecret.
+func oauth2Config(arguments map[string]string, secretsDir string) (*clientcredentials.Config, string, error) {
+	secret, err := resolveSecret(arguments["oauth2-client-secret"], secretsDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cfg := &clientcredentials.Config{
+		ClientID:     arguments["oauth2-client-id"],
+		ClientSecret: secret,
+		TokenURL:     arguments["oauth2-token-url"],
+		Scopes:       strings.Fields(strings.Replace(arguments["oauth2-scopes"], ",", " ", -1)),
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join([]string{cfg.TokenURL, cfg.ClientID, cfg.ClientSecret, strings.Join(cfg.Scopes, " ")}, "\n")))
+	return cfg, hex.EncodeToString(hash[:]), nil
+}
+
+// oauth2Token returns an access token obtained via the client-credentials
+// grant, using the given client, unless we've one which is still valid.
+func oauth2Token(arguments map[string]string, secretsDir string, client *http.Client) (string, error) {
+	cfg, key, err := oauth2Config(arguments, secretsDir)
+	if err != nil {
+		return "", err
+	}
+
+	entry := oauth2CacheEntry(key)
+	entry.Lock()
+	defer entry.Unlock()
+
+	if entry.token.Valid() {
+		return entry.token.AccessToken, nil
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	token, err := cfg.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	entry.token = token
+	return token.AccessToken, nil
+}
+
+// forgetOAuth2Token removes a token from our cache, once it has been
+// rejected, so that a new one is obtained next time.
+func forgetOAuth2Token(arguments map[string]string, secretsDir string) {
+	_, key, err := oauth2Config(arguments, secretsDir)
+	if err != nil {
+		return
+	}
+
+	entry := oauth2CacheEntry(key)
+	entry.Lock()
+	entry.token = nil
+	entry.Unlock()
+}
@@ -1,25 +1,48 @@
 // Ping Tester
 //
-// The ping tester invokes the system 'ping' and 'ping6' binaries to carry
-// out a ping-test against a remote host.
+// The ping tester sends ICMP echo requests to a remote host, and waits
+// for the matching echo replies.
 //
-// If the system-binary reports a failure, via its exit-code, then this test
-// fails.
+// Unprivileged ICMP datagram sockets are used when the system allows
+// them (see the `net.ipv4.ping_group_range` sysctl on Linux), otherwise
+// raw sockets are used, which require the worker to run with the
+// CAP_NET_RAW capability.
 //
-// This test is invoked via input like so:
+// By default a single echo request is sent, and the test fails if no
+// reply is received. This test is invoked via input like so:
 //
 //    host.example.com must run ping
+//
+// More requests can be sent, with a custom interval between them:
+//
+//    host.example.com must run ping with count 5 with interval 200ms
+//
+// The test can fail if too many requests are lost, or if any reply is
+// too slow:
+//
+//    host.example.com must run ping with count 10 with max-loss 20%
+//
+//    host.example.com must run ping with count 5 with max-rtt 100ms
+//
+// The measured min/avg/max round-trip times and loss are reported in the
+// details of the test result.
 
 package protocols
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
-	"os/exec"
-	"syscall"
+	"strconv"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"github.com/cmaster11/overseer/utils"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // PINGTest is our object.
@@ -31,56 +54,16 @@ func (s *PINGTest) ShouldResolveHostname() bool {
 	return true
 }
 
-// RunCommand invokes an external binary and returns stdout/stderr/exit-code
-func (s *PINGTest) RunCommand(name string, args ...string) (stdout string, stderr string, exitCode int) {
-	var outbuf, errbuf bytes.Buffer
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = &outbuf
-	cmd.Stderr = &errbuf
-
-	err := cmd.Run()
-	stdout = outbuf.String()
-	stderr = errbuf.String()
-
-	if err != nil {
-		// try to get the exit code
-		if exitError, ok := err.(*exec.ExitError); ok {
-			ws := exitError.Sys().(syscall.WaitStatus)
-			exitCode = ws.ExitStatus()
-		} else {
-			exitCode = 1
-			if stderr == "" {
-				stderr = err.Error()
-			}
-		}
-	} else {
-		// success, exitCode should be 0 if go is ok
-		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
-		exitCode = ws.ExitStatus()
-	}
-	return
-}
-
-// Ping4 runs a ping test against an IPv4 address, returning true
-// if the ping succeeded.
-func (s *PINGTest) Ping4(target string) bool {
-
-	_, _, ret := s.RunCommand("ping4", "-c", "1", "-w", "4", "-W", "4", target)
-	return (ret == 0)
-}
-
-// Ping6 runs a ping test against an IPv6 address, returning true
-// if the ping succeeded.
-func (s *PINGTest) Ping6(target string) bool {
-	_, _, ret := s.RunCommand("ping6", "-c", "1", "-w", "4", "-W", "4", target)
-	return (ret == 0)
-}
-
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
 func (s *PINGTest) Arguments() map[string]string {
-	known := map[string]string{}
+	known := map[string]string{
+		"count":    "^[1-9][0-9]*$",
+		"interval": `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"max-loss": `^\d+(\.\d+)?%$`,
+		"max-rtt":  `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+	}
 	return known
 }
 
@@ -89,51 +72,279 @@ func (s *PINGTest) Example() string {
 	str := `
 Ping Tester
 -----------
- The ping tester invokes the system 'ping' and 'ping6' binaries to carry
- out a ping-test against a remote host.
+ The ping tester sends ICMP echo requests to a remote host, and waits
+ for the matching echo replies.
 
- If the system-binary reports a failure, via its exit-code, then this test
- fails.
+ Unprivileged ICMP datagram sockets are used when the system allows
+ them (see the 'net.ipv4.ping_group_range' sysctl on Linux), otherwise
+ raw sockets are used, which require the worker to run with the
+ CAP_NET_RAW capability.
 
- This test is invoked via input like so:
+ By default a single echo request is sent, and the test fails if no
+ reply is received. This test is invoked via input like so:
 
     host.example.com must run ping
+
+ More requests can be sent, with a custom interval between them:
+
+    host.example.com must run ping with count 5 with interval 200ms
+
+ The test can fail if too many requests are lost, or if any reply is
+ too slow:
+
+    host.example.com must run ping with count 10 with max-loss 20%
+
+    host.example.com must run ping with count 5 with max-rtt 100ms
+
+ The measured min/avg/max round-trip times and loss are reported in the
+ details of the test result.
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
-//
-// In this case we run a ping-command with the appropriate binary depending
-// on the address-family of the target host.
 func (s *PINGTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// measured values alongside the result.
+//
+// In this case we send the echo requests using the appropriate ICMP
+// version depending on the address-family of the target host.
+func (s *PINGTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
+	var err error
+
 	ip := net.ParseIP(target)
+	if ip == nil {
+		return nil, errors.New("neither IPv4 nor IPv6 address")
+	}
+
+	count := 1
+	if tst.Arguments["count"] != "" {
+		count, err = strconv.Atoi(tst.Arguments["count"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	interval := time.Second
+	if tst.Arguments["interval"] != "" {
+		interval, err = time.ParseDuration(tst.Arguments["interval"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// By default we fail only if all the requests were lost.
+	//
+	var maxLoss float32 = 1
+	if tst.Arguments["max-loss"] != "" {
+		maxLoss, err = utils.ParsePercentage(tst.Arguments["max-loss"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var maxRTT time.Duration
+	if tst.Arguments["max-rtt"] != "" {
+		maxRTT, err = time.ParseDuration(tst.Arguments["max-rtt"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// The whole test must complete within the timeout.
+	//
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	rtts, err := s.ping(ip, count, interval, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return pingResult(count, rtts, maxLoss, maxRTT, opts.Verbose)
+}
+
+// pingResult calculates the loss and round-trip times of the replies to
+// the given number of requests, and tests them against the limits.
+func pingResult(count int, rtts []time.Duration, maxLoss float32, maxRTT time.Duration, verbose bool) (*string, error) {
 
 	//
-	// If the address is an IPv4 address.
+	// Calculate our statistics.
 	//
-	if ip.To4() != nil {
-		if s.Ping4(target) {
-			return nil
+	loss := float32(count-len(rtts)) / float32(count)
+	var minRTT, avgRTT, maxSeenRTT time.Duration
+	for i, rtt := range rtts {
+		if i == 0 || rtt < minRTT {
+			minRTT = rtt
+		}
+		if rtt > maxSeenRTT {
+			maxSeenRTT = rtt
 		}
-		return errors.New("failed to ping binary")
+		avgRTT += rtt
+	}
+	if len(rtts) > 0 {
+		avgRTT /= time.Duration(len(rtts))
+	}
+
+	details := fmt.Sprintf("%d packets transmitted, %d received, %.2f%% packet loss, rtt min/avg/max = %s/%s/%s",
+		count, len(rtts), loss*100, minRTT, avgRTT, maxSeenRTT)
+
+	if verbose {
+		fmt.Printf("\tPing %s\n", details)
+	}
+
+	if len(rtts) == 0 {
+		return &details, errors.New("failed to ping target")
+	}
+
+	if loss > maxLoss {
+		return &details, fmt.Errorf("packet loss %.2f%% is above the max allowed of %.2f%%", loss*100, maxLoss*100)
+	}
+
+	if maxRTT > 0 && maxSeenRTT > maxRTT {
+		return &details, fmt.Errorf("round-trip time %s is above the max allowed of %s", maxSeenRTT, maxRTT)
+	}
+
+	return &details, nil
+}
+
+// ping sends the echo requests to the given address, returning the
+// round-trip times of the received replies.
+func (s *PINGTest) ping(ip net.IP, count int, interval time.Duration, timeout time.Duration) ([]time.Duration, error) {
+
+	//
+	// Pick the settings for the address-family.
+	//
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	proto := 1
+	datagramNetwork, rawNetwork, listenAddress := "udp4", "ip4:icmp", "0.0.0.0"
+	if ip.To4() == nil {
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		proto = 58
+		datagramNetwork, rawNetwork, listenAddress = "udp6", "ip6:ipv6-icmp", "::"
 	}
 
 	//
-	// If the address is an IPv6 address.
+	// Prefer the unprivileged datagram-socket, falling back to
+	// a raw socket.
 	//
-	if ip.To16() != nil && ip.To4() == nil {
-		if s.Ping6(target) {
-			return nil
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	conn, err := icmp.ListenPacket(datagramNetwork, listenAddress)
+	if err != nil {
+		var errRaw error
+		conn, errRaw = icmp.ListenPacket(rawNetwork, listenAddress)
+		if errRaw != nil {
+			return nil, fmt.Errorf("failed to open ICMP socket: %s, %s", err.Error(), errRaw.Error())
 		}
-		return errors.New("failed to ping target")
+		dst = &net.IPAddr{IP: ip}
 	}
+	defer conn.Close()
 
 	//
-	// Unknown family, or otherwise bogus name.
+	// With datagram-sockets the kernel replaces the identifier, and
+	// takes care of filtering the replies which belong to us.  Raw
+	// sockets see the replies to every ping on the host, so a random
+	// identifier, and base for the sequence numbers, keeps those of
+	// concurrent tests apart.
 	//
-	return errors.New("neither IPv4 nor IPv6 address")
+	random := make([]byte, 4)
+	if _, err = rand.Read(random); err != nil {
+		return nil, err
+	}
+	id := int(binary.BigEndian.Uint16(random[:2]))
+	base := int(binary.BigEndian.Uint16(random[2:]))
+
+	// The send-time of the requests still awaiting a reply.
+	pending := make(map[int]time.Time)
+	var rtts []time.Duration
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+
+	for i := 0; i < count && time.Now().Before(deadline); i++ {
+		seq := (base + i) & 0xffff
+		msg := icmp.Message{
+			Type: echoType,
+			Body: &icmp.Echo{
+				ID:   id,
+				Seq:  seq,
+				Data: []byte("overseer/probe"),
+			},
+		}
+		out, errMarshal := msg.Marshal(nil)
+		if errMarshal != nil {
+			return nil, errMarshal
+		}
+
+		sentAt := time.Now()
+		pending[seq] = sentAt
+		if _, err = conn.WriteTo(out, dst); err != nil {
+			return nil, err
+		}
+
+		//
+		// Collect replies until it's time to send the next request,
+		// or, after the last one, until we're out of time.
+		//
+		waitUntil := deadline
+		if i < count-1 && sentAt.Add(interval).Before(deadline) {
+			waitUntil = sentAt.Add(interval)
+		}
+
+		for time.Now().Before(waitUntil) && len(pending) > 0 {
+			conn.SetReadDeadline(waitUntil)
+			n, peer, errRead := conn.ReadFrom(buf)
+			if errRead != nil {
+				if errNet, ok := errRead.(net.Error); ok && errNet.Timeout() {
+					break
+				}
+				return nil, errRead
+			}
+			received := time.Now()
+
+			reply, errParse := icmp.ParseMessage(proto, buf[:n])
+			if errParse != nil || reply.Type != replyType {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			if !ok {
+				continue
+			}
+
+			//
+			// On raw sockets we see every reply, so skip those
+			// which are not ours.
+			//
+			if _, raw := dst.(*net.IPAddr); raw && (echo.ID != id || peer.String() != dst.String()) {
+				continue
+			}
+
+			if start, found := pending[echo.Seq]; found {
+				rtts = append(rtts, received.Sub(start))
+				delete(pending, echo.Seq)
+			}
+		}
+
+		//
+		// Respect the interval, even if the reply arrived early.
+		//
+		if i < count-1 {
+			if pause := time.Until(waitUntil); pause > 0 {
+				time.Sleep(pause)
+			}
+		}
+	}
+
+	return rtts, nil
 }
 
 func (s *PINGTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
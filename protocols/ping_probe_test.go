@@ -0,0 +1,125 @@
+package protocols
+
+import (
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// Test the validation of the arguments
+func TestPingArguments(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Value string
+		Valid bool
+	}{
+		{"count", "5", true},
+		{"count", "0", false},
+		{"count", "-1", false},
+		{"interval", "200ms", true},
+		{"interval", "200", false},
+		{"max-loss", "20%", true},
+		{"max-loss", "12.5%", true},
+		{"max-loss", "20", false},
+		{"max-rtt", "1.5s", true},
+		{"max-rtt", "-5ms", false},
+	}
+
+	s := &PINGTest{}
+	for _, tst := range tests {
+		valid := regexp.MustCompile(s.Arguments()[tst.Name]).MatchString(tst.Value)
+		if valid != tst.Valid {
+			t.Errorf("Expected %s '%s' to be valid=%t", tst.Name, tst.Value, tst.Valid)
+		}
+	}
+
+	//
+	// Some values are only rejected when the test runs, before any
+	// request is sent.
+	//
+	for _, tst := range []struct {
+		Target    string
+		Arguments map[string]string
+	}{
+		{"host.example.com", map[string]string{}},
+		{"127.0.0.1", map[string]string{"max-loss": "150%"}},
+		{"127.0.0.1", map[string]string{"interval": "5parsecs"}},
+	} {
+		_, err := s.RunTestWithDetails(test.Test{Arguments: tst.Arguments}, tst.Target, test.Options{Timeout: time.Second})
+		if err == nil {
+			t.Errorf("Expected %s %v to fail", tst.Target, tst.Arguments)
+		}
+	}
+}
+
+// Test the loss and round-trip time calculations over sets of replies
+func TestPingResult(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		Count   int
+		RTTs    []time.Duration
+		MaxLoss float32
+		MaxRTT  time.Duration
+		Valid   bool
+		Details string
+	}{
+		{1, []time.Duration{10 * ms}, 1, 0, true,
+			"1 packets transmitted, 1 received, 0.00% packet loss, rtt min/avg/max = 10ms/10ms/10ms"},
+		{4, []time.Duration{30 * ms, 10 * ms, 20 * ms}, 1, 0, true,
+			"4 packets transmitted, 3 received, 25.00% packet loss, rtt min/avg/max = 10ms/20ms/30ms"},
+		{4, []time.Duration{30 * ms, 10 * ms, 20 * ms}, 0.25, 0, true,
+			"4 packets transmitted, 3 received, 25.00% packet loss, rtt min/avg/max = 10ms/20ms/30ms"},
+		{4, []time.Duration{30 * ms, 10 * ms, 20 * ms}, 0.2, 0, false,
+			"4 packets transmitted, 3 received, 25.00% packet loss, rtt min/avg/max = 10ms/20ms/30ms"},
+		{3, []time.Duration{30 * ms, 10 * ms, 20 * ms}, 1, 25 * ms, false,
+			"3 packets transmitted, 3 received, 0.00% packet loss, rtt min/avg/max = 10ms/20ms/30ms"},
+		{3, []time.Duration{30 * ms, 10 * ms, 20 * ms}, 1, 30 * ms, true,
+			"3 packets transmitted, 3 received, 0.00% packet loss, rtt min/avg/max = 10ms/20ms/30ms"},
+		{2, nil, 1, 0, false,
+			"2 packets transmitted, 0 received, 100.00% packet loss, rtt min/avg/max = 0s/0s/0s"},
+	}
+
+	for _, tst := range tests {
+		details, err := pingResult(tst.Count, tst.RTTs, tst.MaxLoss, tst.MaxRTT, false)
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %d %v to pass, got error: %s", tst.Count, tst.RTTs, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %d %v to fail", tst.Count, tst.RTTs)
+		}
+		if details == nil || *details != tst.Details {
+			t.Errorf("Expected details '%s', got %v", tst.Details, details)
+		}
+	}
+}
+
+// Test pinging the loopback address, from several tests at once, each
+// of which must only count the replies to its own requests
+func TestPingLoopback(t *testing.T) {
+	s := &PINGTest{}
+	if _, err := s.ping(net.ParseIP("127.0.0.1"), 1, 0, time.Second); err != nil {
+		t.Skipf("Unable to ping: %s", err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtts, err := s.ping(net.ParseIP("127.0.0.1"), 3, 20*time.Millisecond, 2*time.Second)
+			if err != nil {
+				t.Errorf("Expected ping to pass, got error: %s", err.Error())
+			}
+			if len(rtts) != 3 {
+				t.Errorf("Expected 3 replies, got %d", len(rtts))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,274 @@
+// Probe Plugins
+//
+// Protocol-tests can be implemented outside of overseer, in any language,
+// as executables which live in a plugin directory.
+//
+// Each plugin is invoked with a single JSON request on its STDIN, and
+// must write a single JSON response to its STDOUT before exiting.
+//
+// When the plugins are loaded each of them is asked to describe itself:
+//
+//    {"action": "describe"}
+//
+// To which it replies with its name, the arguments it understands along
+// with the regular expressions used to validate them, and its usage
+// instructions:
+//
+//    {
+//      "name": "my-probe",
+//      "arguments": {"port": "^[0-9]+$"},
+//      "example": "...",
+//      "resolve-hostname": true
+//    }
+//
+// Then, for each test, the plugin is invoked with the test definition,
+// the (resolved) target, and the test options:
+//
+//    {"action": "run", "test": {...}, "target": "1.2.3.4", "options": {"Timeout": 10000000000, "Verbose": false}}
+//
+// The timeout of the options is given in nanoseconds.  No other options
+// of the worker are sent to the plugins.
+//
+// To which it replies with an empty error if the test passed, and any
+// optional details of the result:
+//
+//    {"error": "", "details": "response time 12ms"}
+//
+// A plugin which exits with a non-zero code, or which doesn't reply with
+// valid JSON, fails the test.  Plugins, and any process they started,
+// are killed if they don't reply within the test timeout.
+
+package protocols
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// pluginDescribeTimeout is how long a plugin may take to describe itself.
+const pluginDescribeTimeout = 10 * time.Second
+
+// pluginRequest is the JSON request sent to a plugin.
+type pluginRequest struct {
+	Action  string         `json:"action"`
+	Test    *test.Test     `json:"test,omitempty"`
+	Target  string         `json:"target,omitempty"`
+	Options *pluginOptions `json:"options,omitempty"`
+}
+
+// pluginOptions are the test options sent to a plugin.
+type pluginOptions struct {
+	Timeout time.Duration
+	Verbose bool
+}
+
+// pluginDescription is the JSON response of a plugin to a describe-request.
+type pluginDescription struct {
+	Name            string            `json:"name"`
+	Arguments       map[string]string `json:"arguments"`
+	Example         string            `json:"example"`
+	ResolveHostname bool              `json:"resolve-hostname"`
+}
+
+// pluginResult is the JSON response of a plugin to a run-request.
+type pluginResult struct {
+	Error   string  `json:"error"`
+	Details *string `json:"details"`
+}
+
+// PluginTest is our object, wrapping an external plugin.
+type PluginTest struct {
+	path        string
+	description pluginDescription
+}
+
+// LoadPlugins discovers the executables in the given directory, and
+// registers each of them as a protocol-test.
+//
+// The names of the registered protocol-tests are returned.  Plugins
+// cannot replace the built-in protocol-tests.
+func LoadPlugins(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if !file.Mode().IsRegular() || file.Mode()&0111 == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		plugin, errLoad := loadPlugin(path)
+		if errLoad != nil {
+			return names, fmt.Errorf("failed to load plugin %s: %s", path, errLoad.Error())
+		}
+
+		name := plugin.description.Name
+		if ProtocolHandler(name) != nil {
+			return names, fmt.Errorf("failed to load plugin %s: protocol-test '%s' already exists", path, name)
+		}
+
+		Register(name, func() ProtocolTest {
+			return plugin
+		})
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// loadPlugin asks the plugin at the given path to describe itself.
+func loadPlugin(path string) (*PluginTest, error) {
+	plugin := &PluginTest{path: path}
+
+	err := plugin.call(pluginRequest{Action: "describe"}, pluginDescribeTimeout, &plugin.description)
+	if err != nil {
+		return nil, err
+	}
+
+	//
+	// The name must be usable in a test-line.
+	//
+	if !regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`).MatchString(plugin.description.Name) {
+		return nil, fmt.Errorf("invalid name '%s'", plugin.description.Name)
+	}
+
+	//
+	// The parser expects valid regular expressions for the arguments.
+	//
+	for name, pattern := range plugin.description.Arguments {
+		if _, errCompile := regexp.Compile(pattern); errCompile != nil {
+			return nil, fmt.Errorf("invalid pattern for argument '%s': %s", name, errCompile.Error())
+		}
+	}
+
+	return plugin, nil
+}
+
+// call sends the given request to the plugin, decoding its response.
+func (s *PluginTest) call(request pluginRequest, timeout time.Duration, response interface{}) error {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.path)
+	cmd.Dir = filepath.Dir(s.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	startProcessGroup(cmd)
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	//
+	// As for the exec-test, we don't wait for the plugin to be
+	// reaped after killing it.
+	//
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-expired:
+		killProcessGroup(cmd)
+		return fmt.Errorf("plugin timed out after %s", timeout)
+	}
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("plugin failed: %s: %s", err.Error(), msg)
+		}
+		return fmt.Errorf("plugin failed: %s", err.Error())
+	}
+
+	if err = json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return fmt.Errorf("invalid plugin response: %s", err.Error())
+	}
+	return nil
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *PluginTest) Arguments() map[string]string {
+	known := map[string]string{}
+	for name, pattern := range s.description.Arguments {
+		known[name] = pattern
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *PluginTest) ShouldResolveHostname() bool {
+	return s.description.ResolveHostname
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *PluginTest) Example() string {
+	return s.description.Example
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+func (s *PluginTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// details reported by the plugin alongside the result.
+func (s *PluginTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tPlugin running: %s\n", s.path)
+	}
+
+	var result pluginResult
+	err := s.call(pluginRequest{
+		Action:  "run",
+		Test:    &tst,
+		Target:  target,
+		Options: &pluginOptions{Timeout: timeout, Verbose: opts.Verbose},
+	}, timeout, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Error != "" {
+		return result.Details, errors.New(result.Error)
+	}
+	return result.Details, nil
+}
+
+func (s *PluginTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
@@ -0,0 +1,124 @@
+package protocols
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// A plugin which passes if the target is 127.0.0.1, and the "expect"
+// argument is "ok".  The options of the worker must not be sent.  For
+// the target 127.0.0.3 it hangs, having started a child which leaves a
+// file behind if it isn't killed.
+const testPluginScript = `#!/bin/sh
+read request
+case "$request" in
+  *'"action":"describe"'*)
+    echo '{"name": "test-plugin", "arguments": {"expect": "^(ok|ko)$"}, "example": "Test Plugin", "resolve-hostname": true}'
+    ;;
+  *'ExecPluginDir'*)
+    echo '{"error": "unexpected options", "details": "all bad"}'
+    ;;
+  *'"target":"127.0.0.3"'*)
+    (sleep 1; touch survived) &
+    sleep 10
+    ;;
+  *'"target":"127.0.0.1"'*'"expect":"ok"'*|*'"expect":"ok"'*'"target":"127.0.0.1"'*)
+    echo '{"error": "", "details": "all good"}'
+    ;;
+  *)
+    echo '{"error": "not good", "details": "all bad"}'
+    ;;
+esac
+`
+
+// Test loading and running a plugin
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "overseer-plugins")
+	if err != nil {
+		t.Fatalf("Error creating plugin directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "test-plugin"), []byte(testPluginScript), 0755)
+	if err != nil {
+		t.Fatalf("Error writing plugin: %s", err.Error())
+	}
+
+	// Non-executable files are ignored
+	err = ioutil.WriteFile(filepath.Join(dir, "README"), []byte("Not a plugin"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %s", err.Error())
+	}
+
+	names, err := LoadPlugins(dir)
+	if err != nil {
+		t.Fatalf("Error loading plugins: %s", err.Error())
+	}
+	if len(names) != 1 || names[0] != "test-plugin" {
+		t.Fatalf("Unexpected plugins loaded: %v", names)
+	}
+
+	handler := ProtocolHandler("test-plugin")
+	if handler == nil {
+		t.Fatalf("Plugin was not registered")
+	}
+	if handler.Example() != "Test Plugin" {
+		t.Errorf("Unexpected example: %s", handler.Example())
+	}
+	if handler.Arguments()["expect"] != "^(ok|ko)$" {
+		t.Errorf("Unexpected arguments: %v", handler.Arguments())
+	}
+	if !handler.ShouldResolveHostname() {
+		t.Errorf("Expected the plugin to resolve hostnames")
+	}
+
+	tests := []struct {
+		Target string
+		Expect string
+		Valid  bool
+	}{
+		{"127.0.0.1", "ok", true},
+		{"127.0.0.1", "ko", false},
+		{"127.0.0.2", "ok", false},
+	}
+
+	opts := test.Options{Timeout: 5 * time.Second, ExecPluginDir: dir}
+	for _, tst := range tests {
+		details, err := RunTest(handler, test.Test{Arguments: map[string]string{"expect": tst.Expect}}, tst.Target, opts)
+
+		if details == nil {
+			t.Errorf("Expected details for %v", tst)
+		}
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst)
+		}
+	}
+
+	// A plugin which times out is killed, along with its children
+	opts.Timeout = 200 * time.Millisecond
+	if _, err = RunTest(handler, test.Test{Arguments: map[string]string{"expect": "ok"}}, "127.0.0.3", opts); err == nil {
+		t.Errorf("Expected the hanging plugin to time out")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err = os.Stat(filepath.Join(dir, "survived")); err == nil {
+		t.Errorf("Expected the child of the plugin to be killed")
+	}
+
+	// Loading again fails, as the protocol-test already exists
+	if _, err = LoadPlugins(dir); err == nil {
+		t.Errorf("Expected duplicate plugin to fail loading")
+	}
+}
@@ -8,6 +8,15 @@
 //
 //    host.example.com must run pop3 [with username 'steve@steve' with password ]
 //
+// The certificate offered via STARTTLS can be checked to not expire
+// within the given period, as for the ssl test:
+//
+//    host.example.com must run pop3 with expiration 14d
+//
+// If the certificate is self-signed or otherwise non-trusted, only its
+// expiration is checked when using `with tls insecure`, and neither
+// its chain nor its hostname.
+//
 
 package protocols
 
@@ -29,10 +38,11 @@ type POP3Test struct {
 // their values.
 func (s *POP3Test) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"tls":      "insecure",
-		"username": ".*",
-		"password": ".*",
+		"port":       "^[0-9]+$",
+		"tls":        "insecure",
+		"username":   ".*",
+		"password":   ".*",
+		"expiration": "^([0-9]+[hd]?)$",
 	}
 	return known
 }
@@ -54,6 +64,15 @@ POP3 Tester
  This test is invoked via input like so:
 
     host.example.com must run pop3
+
+ The certificate offered via STARTTLS can be checked to not expire
+ within the given period, as for the ssl test:
+
+    host.example.com must run pop3 with expiration 14d
+
+ If the certificate is self-signed or otherwise non-trusted, only its
+ expiration is checked when using 'with tls insecure', and neither
+ its chain nor its hostname.
 `
 	return str
 }
@@ -114,9 +133,19 @@ func (s *POP3Test) RunTest(tst test.Test, target string, opts test.Options) erro
 	}
 
 	//
-	// Quit and return
+	// Quit
 	//
 	c.Quit()
+
+	//
+	// Check the certificate offered via STARTTLS.
+	//
+	if tst.Arguments["expiration"] != "" {
+		if err = checkSTARTTLSExpiration("pop3", address, tst, opts, tst.Arguments["tls"] == "insecure", true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
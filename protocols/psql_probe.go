@@ -13,14 +13,52 @@
 // Specifying a username and password is required, because otherwise we
 // cannot connect to the database.
 //
+// The certificate offered via STARTTLS can be checked to not expire
+// within the given period, as for the ssl test, and is verified with
+// tls verify-ca, and its hostname too with verify-full:
+//
+//    host.example.com must run psql with username 'postgres' with password 'mysecretpassword' with expiration 14d
+//
+// With the other `tls` settings only its expiration is checked, and
+// neither its chain nor its hostname.
+//
+// A query can be run, in the given database, and its result tested.
+// The first column of the first row must equal the value given via
+// expect, be compared to a number, or match a regular expression:
+//
+//    db.example.com must run psql with username 'overseer' with password 'test' with database 'shop' with query 'SELECT COUNT(*) FROM orders WHERE shipped_at IS NULL' with expect '< 100'
+//
+//    db.example.com must run psql with username 'overseer' with password 'test' with query 'SHOW server_version' with expect '=~ ^12\.'
+//
+// There are also checks built in, which need PostgreSQL 10 or later.
+// A replica can be tested to be no more than the given number of
+// seconds, or duration, behind.  A replica which isn't streaming from
+// its primary is as far behind as its last replayed transaction is
+// old, and fails the test if it has never replayed one:
+//
+//    replica.example.com must run psql with username 'overseer' with password 'test' with max-replication-lag 30s
+//
+// A primary can be tested to accept writes, or a replica to refuse
+// them, via read-only:
+//
+//    db.example.com must run psql with username 'overseer' with password 'test' with read-only false
+//
+// And the test can fail if more than the given percentage of the
+// allowed connections are in use:
+//
+//    db.example.com must run psql with username 'overseer' with password 'test' with max-connections-used 80%
+//
 
 package protocols
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 	_ "github.com/lib/pq" // Don't need to import this
@@ -35,10 +73,17 @@ type PSQLTest struct {
 // their values.
 func (s *PSQLTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"username": ".*",
-		"password": ".*",
-		"tls":      "^(disable|require|verify-ca|verify-full)$",
+		"port":                 "^[0-9]+$",
+		"username":             ".*",
+		"password":             ".*",
+		"tls":                  "^(disable|require|verify-ca|verify-full)$",
+		"expiration":           "^([0-9]+[hd]?)$",
+		"database":             ".*",
+		"query":                ".+",
+		"expect":               ".+",
+		"read-only":            "^(true|false)$",
+		"max-replication-lag":  "^[0-9]+(ms|s|m|h)?$",
+		"max-connections-used": "^[0-9]+%?$",
 	}
 	return known
 }
@@ -64,23 +109,68 @@ PSQL Tester
 
  Specifying a username and password is required, because otherwise we
  cannot connect to the database.
+
+ The certificate offered via STARTTLS can be checked to not expire
+ within the given period, as for the ssl test, and is verified with
+ tls verify-ca, and its hostname too with verify-full:
+
+    host.example.com must run psql with username 'postgres' with password 'mysecretpassword' with expiration 14d
+
+ With the other 'tls' settings only its expiration is checked, and
+ neither its chain nor its hostname.
+
+ A query can be run, in the given database, and its result tested.
+ The first column of the first row must equal the value given via
+ expect, be compared to a number, or match a regular expression:
+
+    db.example.com must run psql with username 'overseer' with password 'test' with database 'shop' with query 'SELECT COUNT(*) FROM orders WHERE shipped_at IS NULL' with expect '< 100'
+
+    db.example.com must run psql with username 'overseer' with password 'test' with query 'SHOW server_version' with expect '=~ ^12\.'
+
+ There are also checks built in, which need PostgreSQL 10 or later.
+ A replica can be tested to be no more than the given number of
+ seconds, or duration, behind.  A replica which isn't streaming from
+ its primary is as far behind as its last replayed transaction is
+ old, and fails the test if it has never replayed one:
+
+    replica.example.com must run psql with username 'overseer' with password 'test' with max-replication-lag 30s
+
+ A primary can be tested to accept writes, or a replica to refuse
+ them, via read-only:
+
+    db.example.com must run psql with username 'overseer' with password 'test' with read-only false
+
+ And the test can fail if more than the given percentage of the
+ allowed connections are in use:
+
+    db.example.com must run psql with username 'overseer' with password 'test' with max-connections-used 80%
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
+func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// result of the query, and of the built-in checks, alongside the result.
 //
 // In this case we make a TCP connection to the database host and attempt
 // to login with the specified username & password.
-func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) error {
+func (s *PSQLTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
 	var err error
 
 	//
 	// The password might be blank, but the username is required.
 	//
 	if tst.Arguments["username"] == "" {
-		return errors.New("no username specified")
+		return nil, errors.New("no username specified")
+	}
+	if err = checkSQLArguments(tst.Arguments); err != nil {
+		return nil, err
 	}
 
 	//
@@ -90,22 +180,38 @@ func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	if tst.Arguments["port"] != "" {
 		port, err = strconv.Atoi(tst.Arguments["port"])
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
 	//
 	// The default SSL mode
 	//
 	ssl := "disable"
-	if tst.Arguments["tsl"] != "" {
-		ssl = tst.Arguments["tsl"]
+	if tst.Arguments["tls"] != "" {
+		ssl = tst.Arguments["tls"]
+	}
+
+	//
+	// The connection timeout is given in whole seconds.
+	//
+	connectTimeout := int(timeout.Seconds())
+	if connectTimeout < 1 {
+		connectTimeout = 1
 	}
 
 	//
 	// This is the string we'll use for the database connection.
 	//
-	connect := fmt.Sprintf("host=%s port='%d' user='%s' password='%s' connect_timeout='%d' sslmode='%s'", target, port, tst.Arguments["username"], tst.Arguments["password"], opts.Timeout, ssl)
+	connect := fmt.Sprintf("host=%s port='%d' user='%s' password='%s' connect_timeout='%d' sslmode='%s'", target, port, tst.Arguments["username"], tst.Arguments["password"], connectTimeout, ssl)
+	if tst.Arguments["database"] != "" {
+		connect += fmt.Sprintf(" dbname='%s'", tst.Arguments["database"])
+	}
 
 	//
 	// Show the config, if appropriate.
@@ -119,15 +225,100 @@ func (s *PSQLTest) RunTest(tst test.Test, target string, opts test.Options) erro
 	//
 	db, err := sql.Open("postgres", connect)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer db.Close()
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	//
 	// And test that the connection actually worked.
 	//
-	err = db.Ping()
-	return err
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//
+	// Then make the checks we've been asked to.
+	//
+	details, err := runSQLChecks(ctx, db, psqlDialect, tst.Arguments)
+	if err != nil {
+		return details, err
+	}
+
+	//
+	// Check the certificate offered via STARTTLS.
+	//
+	if tst.Arguments["expiration"] != "" {
+		address := net.JoinHostPort(target, strconv.Itoa(port))
+		insecure := ssl != "verify-ca" && ssl != "verify-full"
+		if err = checkSTARTTLSExpiration("psql", address, tst, opts, insecure, ssl == "verify-full"); err != nil {
+			return details, err
+		}
+	}
+
+	return details, nil
+}
+
+// psqlDialect is how the built-in checks are made of PostgreSQL.
+var psqlDialect = sqlDialect{
+	readOnly: func(ctx context.Context, db *sql.DB) (bool, error) {
+		value, err := sqlScalar(ctx, db, "SHOW transaction_read_only")
+		if err != nil {
+			return false, err
+		}
+		return value.String == "on", nil
+	},
+
+	connections: func(ctx context.Context, db *sql.DB) (float64, float64, error) {
+		value, err := sqlScalar(ctx, db, "SELECT COUNT(*) FROM pg_stat_activity WHERE backend_type = 'client backend'")
+		if err != nil {
+			return 0, 0, err
+		}
+		used, err := strconv.ParseFloat(value.String, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		value, err = sqlScalar(ctx, db, "SHOW max_connections")
+		if err != nil {
+			return 0, 0, err
+		}
+		max, err := strconv.ParseFloat(value.String, 64)
+		return used, max, err
+	},
+
+	//
+	// A replica which is streaming from the primary, and has replayed
+	// all it has received, isn't behind, no matter how long ago the
+	// last write to the primary was.  One which has lost the primary
+	// is as far behind as its last replayed transaction is old, and
+	// broken if it has never replayed one.
+	//
+	replicationLag: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+		row, err := sqlRow(ctx, db, `SELECT pg_is_in_recovery() AS replica, CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+		AND EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') THEN 0
+	ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+END AS lag`)
+		if err != nil {
+			return 0, err
+		}
+		if row == nil || row["replica"].String != "true" {
+			return 0, errors.New("database is not a replica")
+		}
+		value := row["lag"]
+		if !value.Valid {
+			return 0, errors.New("replica has never replayed a transaction, and isn't streaming from the primary")
+		}
+		seconds, err := strconv.ParseFloat(value.String, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	},
 }
 
 func (s *PSQLTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
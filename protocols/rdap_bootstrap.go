@@ -0,0 +1,75 @@
+package protocols
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// rdapFallback is the base URL used for the TLDs we don't know about.
+//
+// rdap.org redirects each query to the RDAP service of the registry,
+// using the bootstrap registry of IANA.
+const rdapFallback = "https://rdap.org/"
+
+// rdapBootstrap maps TLDs to the base URL of the RDAP service of their
+// registry, as published by IANA at https://data.iana.org/rdap/dns.json
+//
+// Only the most common TLDs are listed here, the others go via
+// rdapFallback, unless a copy of the IANA file is given.
+var rdapBootstrap = map[string]string{
+	"com":    "https://rdap.verisign.com/com/v1/",
+	"net":    "https://rdap.verisign.com/net/v1/",
+	"cc":     "https://tld-rdap.verisign.com/cc/v1/",
+	"org":    "https://rdap.publicinterestregistry.org/rdap/",
+	"info":   "https://rdap.identitydigital.services/rdap/",
+	"io":     "https://rdap.identitydigital.services/rdap/",
+	"app":    "https://pubapi.registry.google/rdap/",
+	"dev":    "https://pubapi.registry.google/rdap/",
+	"page":   "https://pubapi.registry.google/rdap/",
+	"xyz":    "https://rdap.centralnic.com/xyz/",
+	"uk":     "https://rdap.nominet.uk/uk/",
+	"fr":     "https://rdap.nic.fr/",
+	"nl":     "https://rdap.sidn.nl/",
+	"google": "https://pubapi.registry.google/rdap/",
+}
+
+// loadRDAPBootstrap reads a bootstrap file in the format published by
+// IANA, as described in RFC 7484, returning the base URL of each TLD.
+func loadRDAPBootstrap(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Services [][][]string `json:"services"`
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid RDAP bootstrap file %s: %s", path, err.Error())
+	}
+
+	bootstrap := make(map[string]string)
+	for _, service := range file.Services {
+		if len(service) != 2 || len(service[1]) == 0 {
+			continue
+		}
+
+		//
+		// Prefer the HTTPS URLs.
+		//
+		base := service[1][0]
+		for _, u := range service[1] {
+			if strings.HasPrefix(u, "https://") {
+				base = u
+				break
+			}
+		}
+
+		for _, tld := range service[0] {
+			bootstrap[strings.ToLower(tld)] = base
+		}
+	}
+	return bootstrap, nil
+}
@@ -7,10 +7,46 @@
 //
 //    host.example.com must run redis [with port 6379] [with password 'password']
 //
+// Servers using ACLs can be sent a username too, and a database other
+// than 0 can be selected:
+//
+//    host.example.com must run redis with username 'overseer' with password 'secret' with db 2
+//
+// To connect via TLS use "with tls true", or "with tls insecure" if the
+// certificate isn't to be verified.
+//
+// The output of INFO can be tested, to check the role of the server, or
+// that it isn't running out of memory or connections:
+//
+//    host.example.com must run redis with expect-role master with min-connected-slaves 1
+//
+//    host.example.com must run redis with max-memory-used 80% with max-connected-clients 5000
+//
+// The memory used is either a percentage of maxmemory, or a size such as
+// 512mb or 2gb.  How far the replicas are behind can be tested on the
+// master, in bytes of the replication offset of the slowest of them:
+//
+//    host.example.com must run redis with max-replication-offset-lag 1048576
+//
+// A replica doesn't know how far ahead its master is, so instead it can
+// be tested to have heard from its master within the given number of
+// seconds.  The test fails if the link to the master has been down, or
+// silent, for longer:
+//
+//    replica.example.com must run redis with max-master-link-down 30
+//
+// Finally a Sentinel, on port 26379 unless stated, can be asked for the
+// master of a group.  The test fails if it doesn't know of one, or if it
+// regards the master as down:
+//
+//    sentinel.example.com must run redis with sentinel-master 'mymaster'
+//
 
 package protocols
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -23,13 +59,33 @@ import (
 type REDISTest struct {
 }
 
+// redisInfoArguments are the settings tested against the output of INFO.
+var redisInfoArguments = []string{
+	"expect-role",
+	"max-memory-used",
+	"max-connected-clients",
+	"min-connected-slaves",
+	"max-replication-offset-lag",
+	"max-master-link-down",
+}
+
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
 func (s *REDISTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"password": ".*",
+		"port":                       "^[0-9]+$",
+		"password":                   ".*",
+		"username":                   ".*",
+		"db":                         "^[0-9]+$",
+		"tls":                        "^(true|insecure)$",
+		"expect-role":                "^(master|slave)$",
+		"max-memory-used":            "^([0-9]+%|[0-9]+(b|kb|mb|gb)?)$",
+		"max-connected-clients":      "^[0-9]+$",
+		"min-connected-slaves":       "^[0-9]+$",
+		"max-replication-offset-lag": "^[0-9]+$",
+		"max-master-link-down":       "^[0-9]+$",
+		"sentinel-master":            ".+",
 	}
 	return known
 }
@@ -50,16 +106,58 @@ Redis Tester
  This test is invoked via input like so:
 
     host.example.com must run redis
+
+ Servers using ACLs can be sent a username too, and a database other
+ than 0 can be selected:
+
+    host.example.com must run redis with username 'overseer' with password 'secret' with db 2
+
+ To connect via TLS use "with tls true", or "with tls insecure" if the
+ certificate isn't to be verified.
+
+ The output of INFO can be tested, to check the role of the server, or
+ that it isn't running out of memory or connections:
+
+    host.example.com must run redis with expect-role master with min-connected-slaves 1
+
+    host.example.com must run redis with max-memory-used 80% with max-connected-clients 5000
+
+ The memory used is either a percentage of maxmemory, or a size such as
+ 512mb or 2gb.  How far the replicas are behind can be tested on the
+ master, in bytes of the replication offset of the slowest of them:
+
+    host.example.com must run redis with max-replication-offset-lag 1048576
+
+ A replica doesn't know how far ahead its master is, so instead it can
+ be tested to have heard from its master within the given number of
+ seconds.  The test fails if the link to the master has been down, or
+ silent, for longer:
+
+    replica.example.com must run redis with max-master-link-down 30
+
+ Finally a Sentinel, on port 26379 unless stated, can be asked for the
+ master of a group.  The test fails if it doesn't know of one, or if it
+ regards the master as down:
+
+    sentinel.example.com must run redis with sentinel-master 'mymaster'
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
+func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning what was
+// found via INFO, or the master reported by a Sentinel, alongside the
+// result.
 //
 // In this case we make a Redis-test against the given target.
 //
-func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) error {
+func (s *REDISTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
 
 	//
 	// Predeclare our error
@@ -70,11 +168,9 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 	// The default port to connect to.
 	//
 	port := 6379
-
-	//
-	// The default password to use.
-	//
-	password := ""
+	if tst.Arguments["sentinel-master"] != "" {
+		port = 26379
+	}
 
 	//
 	// If the user specified a different port update to use it.
@@ -82,15 +178,10 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 	if tst.Arguments["port"] != "" {
 		port, err = strconv.Atoi(tst.Arguments["port"])
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	//
-	// If the user specified a password use it.
-	//
-	password = tst.Arguments["password"]
-
 	//
 	// Default to connecting to an IPv4-address
 	//
@@ -103,14 +194,74 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 		address = fmt.Sprintf("[%s]:%d", target, port)
 	}
 
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	options := &redis.Options{
+		Addr:         address,
+		Password:     tst.Arguments["password"],
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+
+	if tst.Arguments["tls"] != "" {
+		options.TLSConfig = &tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		}
+	}
+
+	//
+	// A Sentinel is asked about the master of the group, rather than
+	// about itself.
+	//
+	if tst.Arguments["sentinel-master"] != "" {
+		for _, name := range append([]string{"db"}, redisInfoArguments...) {
+			if tst.Arguments[name] != "" {
+				return nil, fmt.Errorf("%s can't be combined with sentinel-master", name)
+			}
+		}
+		return s.sentinel(options, tst.Arguments)
+	}
+
+	if tst.Arguments["db"] != "" {
+		options.DB, err = strconv.Atoi(tst.Arguments["db"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//
+	// The AUTH command only takes a username, as used by ACLs, in
+	// newer versions of Redis, so we send it ourselves.
+	//
+	// It has to be sent before we select the database.
+	//
+	if tst.Arguments["username"] != "" {
+		username := tst.Arguments["username"]
+		password := options.Password
+		db := options.DB
+		options.Password = ""
+		options.DB = 0
+		options.OnConnect = func(conn *redis.Conn) error {
+			if errAuth := conn.Do("auth", username, password).Err(); errAuth != nil {
+				return errAuth
+			}
+			if db > 0 {
+				return conn.Select(db).Err()
+			}
+			return nil
+		}
+	}
+
 	//
 	// Attempt to connect to the host with the optional password
 	//
-	client := redis.NewClient(&redis.Options{
-		Addr:     address,
-		Password: password,
-		DB:       0, // use default DB
-	})
+	client := redis.NewClient(options)
+	defer client.Close()
 
 	//
 	// And run a ping
@@ -120,13 +271,312 @@ func (s *REDISTest) RunTest(tst test.Test, target string, opts test.Options) err
 	//
 	_, err = client.Ping().Result()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	//
+	// Test the output of INFO, if we've been asked to.
+	//
+	for _, name := range redisInfoArguments {
+		if tst.Arguments[name] != "" {
+			output, errInfo := client.Info().Result()
+			if errInfo != nil {
+				return nil, errInfo
+			}
+			return checkRedisInfo(parseRedisInfo(output), tst.Arguments)
+		}
 	}
 
 	//
 	// If we reached here all is OK
 	//
-	return nil
+	return nil, nil
+}
+
+// sentinel asks a Sentinel for the master of the group, and whether
+// it's regarded as down.
+func (s *REDISTest) sentinel(options *redis.Options, arguments map[string]string) (*string, error) {
+	group := arguments["sentinel-master"]
+
+	//
+	// Sentinels don't do ACLs, but may have a password.
+	//
+	if arguments["username"] != "" {
+		return nil, errors.New("username can't be combined with sentinel-master")
+	}
+
+	client := redis.NewSentinelClient(options)
+	defer client.Close()
+
+	master, err := client.GetMasterAddrByName(group).Result()
+	if err == redis.Nil || (err == nil && len(master) != 2) {
+		return nil, fmt.Errorf("sentinel reports no master for group %s", group)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	details := fmt.Sprintf("master: %s", strings.Join(master, ":"))
+
+	//
+	// The state of the master, as a list of names and values.
+	//
+	state, err := client.Do("sentinel", "master", group).Result()
+	if err != nil {
+		return &details, err
+	}
+	fields, _ := state.([]interface{})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fmt.Sprint(fields[i]) != "flags" {
+			continue
+		}
+		flags := fmt.Sprint(fields[i+1])
+		details = fmt.Sprintf("%s, flags: %s", details, flags)
+		for _, flag := range strings.Split(flags, ",") {
+			if flag == "s_down" || flag == "o_down" {
+				return &details, fmt.Errorf("sentinel reports the master of group %s, %s, is down", group, strings.Join(master, ":"))
+			}
+		}
+	}
+
+	return &details, nil
+}
+
+// parseRedisInfo parses the output of INFO into its fields.
+func parseRedisInfo(output string) map[string]string {
+	info := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			info[parts[0]] = parts[1]
+		}
+	}
+	return info
+}
+
+// redisInfoNumber returns a numeric field of the output of INFO.
+func redisInfoNumber(info map[string]string, name string) (int64, error) {
+	value, ok := info[name]
+	if !ok {
+		return 0, fmt.Errorf("INFO didn't include %s", name)
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// checkRedisInfo tests the output of INFO against the settings we've
+// been given, returning what was found alongside the result.
+func checkRedisInfo(info map[string]string, arguments map[string]string) (*string, error) {
+	var found []string
+	details := func() *string {
+		if len(found) == 0 {
+			return nil
+		}
+		str := strings.Join(found, ", ")
+		return &str
+	}
+
+	role := info["role"]
+	found = append(found, fmt.Sprintf("role: %s", role))
+
+	//
+	// Is this the master, or a replica?
+	//
+	if arguments["expect-role"] != "" && role != arguments["expect-role"] {
+		return details(), fmt.Errorf("role was %s not %s", role, arguments["expect-role"])
+	}
+
+	//
+	// Is it running out of memory?
+	//
+	if limit := arguments["max-memory-used"]; limit != "" {
+		used, err := redisInfoNumber(info, "used_memory")
+		if err != nil {
+			return details(), err
+		}
+		found = append(found, fmt.Sprintf("memory used: %d", used))
+
+		if strings.HasSuffix(limit, "%") {
+			max, err := redisInfoNumber(info, "maxmemory")
+			if err != nil {
+				return details(), err
+			}
+			if max == 0 {
+				return details(), errors.New("max-memory-used can only be a percentage if maxmemory is set")
+			}
+			percent, _ := strconv.ParseFloat(strings.TrimSuffix(limit, "%"), 64)
+			if float64(used)*100/float64(max) > percent {
+				return details(), fmt.Errorf("%.1f%% of maxmemory used, more than %s", float64(used)*100/float64(max), limit)
+			}
+		} else {
+			max, err := parseRedisSize(limit)
+			if err != nil {
+				return details(), err
+			}
+			if used > max {
+				return details(), fmt.Errorf("memory used was %d bytes, more than %s", used, limit)
+			}
+		}
+	}
+
+	//
+	// Or out of connections?
+	//
+	if arguments["max-connected-clients"] != "" {
+		clients, err := redisInfoNumber(info, "connected_clients")
+		if err != nil {
+			return details(), err
+		}
+		found = append(found, fmt.Sprintf("clients: %d", clients))
+
+		max, _ := strconv.ParseInt(arguments["max-connected-clients"], 10, 64)
+		if clients > max {
+			return details(), fmt.Errorf("%d clients connected, more than %d", clients, max)
+		}
+	}
+
+	//
+	// Does the master have enough replicas?
+	//
+	if arguments["min-connected-slaves"] != "" {
+		slaves, err := redisInfoNumber(info, "connected_slaves")
+		if err != nil {
+			return details(), err
+		}
+		found = append(found, fmt.Sprintf("slaves: %d", slaves))
+
+		min, _ := strconv.ParseInt(arguments["min-connected-slaves"], 10, 64)
+		if slaves < min {
+			return details(), fmt.Errorf("%d slaves connected, fewer than %d", slaves, min)
+		}
+	}
+
+	//
+	// Are the replicas keeping up?
+	//
+	if arguments["max-replication-offset-lag"] != "" {
+		if role != "master" {
+			return details(), errors.New("max-replication-offset-lag can only be tested on a master, use max-master-link-down for a replica")
+		}
+		lag, err := redisReplicationLag(info)
+		if err != nil {
+			return details(), err
+		}
+		found = append(found, fmt.Sprintf("replication offset lag: %d", lag))
+
+		max, _ := strconv.ParseInt(arguments["max-replication-offset-lag"], 10, 64)
+		if lag > max {
+			return details(), fmt.Errorf("replication offset lag was %d, more than %d", lag, max)
+		}
+	}
+
+	//
+	// Is the replica still hearing from its master?
+	//
+	if arguments["max-master-link-down"] != "" {
+		if role != "slave" {
+			return details(), errors.New("max-master-link-down can only be tested on a replica")
+		}
+		max, _ := strconv.ParseInt(arguments["max-master-link-down"], 10, 64)
+
+		if info["master_link_status"] == "up" {
+			silent, err := redisInfoNumber(info, "master_last_io_seconds_ago")
+			if err != nil {
+				return details(), err
+			}
+			found = append(found, fmt.Sprintf("master last I/O: %ds ago", silent))
+
+			if silent > max {
+				return details(), fmt.Errorf("nothing heard from the master for %d seconds, more than %d", silent, max)
+			}
+		} else {
+			//
+			// Redis reports -1 if the link has never been up.
+			//
+			down, err := redisInfoNumber(info, "master_link_down_since_seconds")
+			if err != nil {
+				return details(), err
+			}
+			if down < 0 {
+				return details(), errors.New("link to the master has never been up")
+			}
+			found = append(found, fmt.Sprintf("master link down: %ds", down))
+
+			if down > max {
+				return details(), fmt.Errorf("link to the master down for %d seconds, more than %d", down, max)
+			}
+		}
+	}
+
+	return details(), nil
+}
+
+// redisReplicationLag returns how far behind a master, in bytes of the
+// replication offset, the slowest of its replicas is.
+func redisReplicationLag(info map[string]string) (int64, error) {
+	master, err := redisInfoNumber(info, "master_repl_offset")
+	if err != nil {
+		return 0, err
+	}
+
+	//
+	// Each replica is listed like so:
+	//
+	//    slave0:ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0
+	//
+	var lag int64
+	for name, value := range info {
+		if !strings.HasPrefix(name, "slave") || name == "slave_repl_offset" {
+			continue
+		}
+		if _, errIndex := strconv.Atoi(strings.TrimPrefix(name, "slave")); errIndex != nil {
+			continue
+		}
+		for _, field := range strings.Split(value, ",") {
+			if strings.HasPrefix(field, "offset=") {
+				offset, errParse := strconv.ParseInt(strings.TrimPrefix(field, "offset="), 10, 64)
+				if errParse != nil {
+					return 0, errParse
+				}
+				if master-offset > lag {
+					lag = master - offset
+				}
+			}
+		}
+	}
+	return lag, nil
+}
+
+// parseRedisSize parses a size, as used in the configuration of Redis,
+// such as "512mb", into bytes.
+func parseRedisSize(size string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"gb", 1024 * 1024 * 1024},
+		{"mb", 1024 * 1024},
+		{"kb", 1024},
+		{"b", 1},
+	}
+
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSuffix(size, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return value * multiplier, nil
 }
 
 func (s *REDISTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
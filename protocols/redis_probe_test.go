@@ -0,0 +1,323 @@
+package protocols
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// testRedisServer is a stand-in for Redis, or a Sentinel, answering
+// the few commands we send.
+type testRedisServer struct {
+	username string
+	password string
+	info     string
+	masters  map[string]string
+	flags    string
+}
+
+// serve answers the commands sent over the connection.
+func (r *testRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authed := r.password == ""
+	for {
+		command, err := readRedisCommand(reader)
+		if err != nil {
+			return
+		}
+
+		name := strings.ToLower(command[0])
+		if name != "auth" && !authed {
+			fmt.Fprintf(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		switch name {
+		case "auth":
+			username, password := "default", command[len(command)-1]
+			if len(command) == 3 {
+				username = command[1]
+			}
+			if password != r.password || (r.username != "" && username != r.username) {
+				fmt.Fprintf(conn, "-WRONGPASS invalid username-password pair\r\n")
+				continue
+			}
+			authed = true
+			fmt.Fprintf(conn, "+OK\r\n")
+		case "ping":
+			fmt.Fprintf(conn, "+PONG\r\n")
+		case "select":
+			fmt.Fprintf(conn, "+OK\r\n")
+		case "info":
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(r.info), r.info)
+		case "sentinel":
+			master, ok := r.masters[command[2]]
+			switch {
+			case !ok && strings.ToLower(command[1]) == "get-master-addr-by-name":
+				fmt.Fprintf(conn, "*-1\r\n")
+			case !ok:
+				fmt.Fprintf(conn, "-ERR No such master with that name\r\n")
+			case strings.ToLower(command[1]) == "get-master-addr-by-name":
+				parts := strings.Split(master, ":")
+				fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(parts[0]), parts[0], len(parts[1]), parts[1])
+			default:
+				fmt.Fprintf(conn, "*4\r\n$4\r\nname\r\n$%d\r\n%s\r\n$5\r\nflags\r\n$%d\r\n%s\r\n", len(command[2]), command[2], len(r.flags), r.flags)
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", name)
+		}
+	}
+}
+
+// readRedisCommand reads a command, sent as an array of bulk strings.
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	var command []string
+	for i := 0; i < count; i++ {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, errSize := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if errSize != nil {
+			return nil, errSize
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		command = append(command, string(data[:size]))
+	}
+	return command, nil
+}
+
+// start listens for connections, via TLS if a config is given.
+func (r *testRedisServer) start(t *testing.T, config *tls.Config) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port, func() { listener.Close() }
+}
+
+// Test the INFO-based assertions against a stand-in server
+func TestRedis(t *testing.T) {
+	master := &testRedisServer{
+		info: strings.Join([]string{
+			"# Clients",
+			"connected_clients:12",
+			"# Memory",
+			"used_memory:838860800",
+			"maxmemory:1073741824",
+			"# Replication",
+			"role:master",
+			"connected_slaves:2",
+			"slave0:ip=10.0.0.2,port=6379,state=online,offset=1000,lag=0",
+			"slave1:ip=10.0.0.3,port=6379,state=online,offset=400,lag=1",
+			"master_repl_offset:1000",
+			"",
+		}, "\r\n"),
+	}
+	masterPort, stopMaster := master.start(t, nil)
+	defer stopMaster()
+
+	replica := &testRedisServer{
+		username: "overseer",
+		password: "secret",
+		info: strings.Join([]string{
+			"# Replication",
+			"role:slave",
+			"master_link_status:down",
+			"master_link_down_since_seconds:45",
+			"slave_repl_offset:900",
+			"connected_slaves:0",
+			"master_repl_offset:900",
+			"",
+		}, "\r\n"),
+	}
+	replicaPort, stopReplica := replica.start(t, nil)
+	defer stopReplica()
+
+	linked := &testRedisServer{
+		info: strings.Join([]string{
+			"# Replication",
+			"role:slave",
+			"master_link_status:up",
+			"master_last_io_seconds_ago:3",
+			"slave_repl_offset:1000",
+			"connected_slaves:0",
+			"master_repl_offset:1000",
+			"",
+		}, "\r\n"),
+	}
+	linkedPort, stopLinked := linked.start(t, nil)
+	defer stopLinked()
+
+	tests := []struct {
+		Port      string
+		Arguments map[string]string
+		Valid     bool
+		Details   string
+	}{
+		{masterPort, map[string]string{}, true, ""},
+		{masterPort, map[string]string{"db": "2"}, true, ""},
+		{masterPort, map[string]string{"expect-role": "master"}, true, "role: master"},
+		{masterPort, map[string]string{"expect-role": "slave"}, false, "role: master"},
+		{masterPort, map[string]string{"max-memory-used": "80%"}, true, "role: master, memory used: 838860800"},
+		{masterPort, map[string]string{"max-memory-used": "75%"}, false, "role: master, memory used: 838860800"},
+		{masterPort, map[string]string{"max-memory-used": "1gb"}, true, "role: master, memory used: 838860800"},
+		{masterPort, map[string]string{"max-memory-used": "512mb"}, false, "role: master, memory used: 838860800"},
+		{masterPort, map[string]string{"max-connected-clients": "10"}, false, "role: master, clients: 12"},
+		{masterPort, map[string]string{"min-connected-slaves": "2"}, true, "role: master, slaves: 2"},
+		{masterPort, map[string]string{"min-connected-slaves": "3"}, false, "role: master, slaves: 2"},
+		{masterPort, map[string]string{"max-replication-offset-lag": "600"}, true, "role: master, replication offset lag: 600"},
+		{masterPort, map[string]string{"max-replication-offset-lag": "500"}, false, "role: master, replication offset lag: 600"},
+		{replicaPort, map[string]string{}, false, ""},
+		{replicaPort, map[string]string{"password": "secret"}, false, ""},
+		{replicaPort, map[string]string{"username": "overseer", "password": "secret"}, true, ""},
+		{replicaPort, map[string]string{"username": "overseer", "password": "secret", "db": "1", "expect-role": "slave"}, true, "role: slave"},
+		{replicaPort, map[string]string{"username": "other", "password": "secret"}, false, ""},
+		{replicaPort, map[string]string{"username": "overseer", "password": "secret", "max-memory-used": "80%"}, false, "role: slave"},
+		{replicaPort, map[string]string{"username": "overseer", "password": "secret", "max-replication-offset-lag": "1000"}, false, "role: slave"},
+		{replicaPort, map[string]string{"username": "overseer", "password": "secret", "max-master-link-down": "60"}, true, "role: slave, master link down: 45s"},
+		{replicaPort, map[string]string{"username": "overseer", "password": "secret", "max-master-link-down": "30"}, false, "role: slave, master link down: 45s"},
+		{linkedPort, map[string]string{"max-replication-offset-lag": "1000"}, false, "role: slave"},
+		{linkedPort, map[string]string{"max-master-link-down": "5"}, true, "role: slave, master last I/O: 3s ago"},
+		{linkedPort, map[string]string{"max-master-link-down": "2"}, false, "role: slave, master last I/O: 3s ago"},
+		{masterPort, map[string]string{"max-master-link-down": "30"}, false, "role: master"},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["port"] = tst.Port
+
+		s := &REDISTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: "localhost", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Arguments)
+		}
+
+		found := ""
+		if details != nil {
+			found = *details
+		}
+		if found != tst.Details {
+			t.Errorf("Expected details '%s' for %v, got '%s'", tst.Details, tst.Arguments, found)
+		}
+	}
+}
+
+// Test connecting via TLS
+func TestRedisTLS(t *testing.T) {
+	cert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		NotAfter:    time.Now().Add(24 * time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+
+	server := &testRedisServer{}
+	port, stop := server.start(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}},
+	})
+	defer stop()
+
+	s := &REDISTest{}
+	err := s.RunTest(test.Test{Target: "localhost", Arguments: map[string]string{"port": port, "tls": "insecure"}}, "127.0.0.1", test.Options{Timeout: time.Second})
+	if err != nil {
+		t.Errorf("Expected tls insecure to pass, got error: %s", err.Error())
+	}
+
+	err = s.RunTest(test.Test{Target: "localhost", Arguments: map[string]string{"port": port, "tls": "true"}}, "127.0.0.1", test.Options{Timeout: time.Second})
+	if err == nil {
+		t.Errorf("Expected an untrusted certificate to fail")
+	}
+
+	err = s.RunTest(test.Test{Target: "localhost", Arguments: map[string]string{"port": port}}, "127.0.0.1", test.Options{Timeout: time.Second})
+	if err == nil {
+		t.Errorf("Expected plaintext to a TLS server to fail")
+	}
+}
+
+// Test asking a Sentinel for the master of a group
+func TestRedisSentinel(t *testing.T) {
+	sentinel := &testRedisServer{
+		masters: map[string]string{"mymaster": "10.0.0.1:6379"},
+		flags:   "master",
+	}
+	port, stop := sentinel.start(t, nil)
+	defer stop()
+
+	down := &testRedisServer{
+		masters: map[string]string{"mymaster": "10.0.0.1:6379"},
+		flags:   "s_down,master",
+	}
+	downPort, stopDown := down.start(t, nil)
+	defer stopDown()
+
+	tests := []struct {
+		Port      string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{port, map[string]string{"sentinel-master": "mymaster"}, true},
+		{port, map[string]string{"sentinel-master": "other"}, false},
+		{port, map[string]string{"sentinel-master": "mymaster", "expect-role": "master"}, false},
+		{downPort, map[string]string{"sentinel-master": "mymaster"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["port"] = tst.Port
+
+		s := &REDISTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: "localhost", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Arguments)
+		}
+		if tst.Valid && (details == nil || *details != "master: 10.0.0.1:6379, flags: master") {
+			t.Errorf("Unexpected details for %v: %v", tst.Arguments, details)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+// Script Tester
+//
+// The script tester runs a Starlark script, a dialect of Python, which
+// is useful for checks that are too specific to deserve their own
+// protocol-test, but are simple to express as a sequence of steps.
+//
+// This test is invoked via input like so:
+//
+//    api.example.com must run script with file '/etc/overseer/scripts/api.star'
+//
+// Values can be passed to the script as "key=value" pairs, which are
+// split on whitespace unless double-quoted:
+//
+//    api.example.com must run script with file '/etc/overseer/scripts/api.star' with args 'min-items=3 path="/v1/items"'
+//
+// The script must define a `check()` function, which is called to run
+// the test.  It fails the test by calling `fail(msg)`, or if any of its
+// statements fails.  It runs in a sandbox, with no access to the local
+// filesystem, and the following globals are available:
+//
+//    target   - the target of the test
+//    args     - a dict of the values passed via the args argument
+//    http     - http.get(url, headers={}) and http.post(url, body="", headers={}),
+//               returning a struct with status_code, headers and body
+//    tcp      - tcp.exchange(address, data=""), sending the optional data
+//               and returning the first response received
+//    dns      - dns.lookup(name), dns.lookup_mx(name) and dns.lookup_txt(name),
+//               returning a list of strings
+//    json     - json.encode(value), json.decode(string) and json.indent(string)
+//
+// For example:
+//
+//    def check():
+//        resp = http.get("https://%s%s" % (target, args["path"]))
+//        if resp.status_code != 200:
+//            fail("unexpected status %d" % resp.status_code)
+//        items = json.decode(resp.body)["items"]
+//        if len(items) < int(args["min-items"]):
+//            fail("only %d items found" % len(items))
+//
+// Scripts are compiled once, and recompiled only if they change.  They
+// are cancelled if they run for longer than the test timeout, or for too
+// many steps.
+//
+
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+)
+
+// scriptGlobals are the names of the globals available to the scripts.
+var scriptGlobals = map[string]bool{
+	"target": true,
+	"args":   true,
+	"http":   true,
+	"tcp":    true,
+	"dns":    true,
+	"json":   true,
+	"fail":   true,
+}
+
+// scriptMaxResponse is the maximum size of a response read by the helpers.
+const scriptMaxResponse = 1024 * 1024
+
+// scriptMaxSteps is the maximum number of computation steps of a script.
+const scriptMaxSteps = 10 * 1000 * 1000
+
+// compiledScript is a script in our cache.
+type compiledScript struct {
+	modTime time.Time
+	program *starlark.Program
+}
+
+// The cache of compiled scripts, by path.
+var scriptCache = struct {
+	m map[string]*compiledScript
+	sync.Mutex
+}{m: make(map[string]*compiledScript)}
+
+// scriptFailure is the error raised by a call to fail().
+type scriptFailure struct {
+	msg string
+}
+
+func (e *scriptFailure) Error() string {
+	return e.msg
+}
+
+// ScriptTest is our object.
+type ScriptTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *ScriptTest) Arguments() map[string]string {
+	known := map[string]string{
+		"file": ".*",
+		"args": ".*",
+		"tls":  "insecure",
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *ScriptTest) ShouldResolveHostname() bool {
+	return false
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *ScriptTest) Example() string {
+	str := `
+Script Tester
+-------------
+ The script tester runs a Starlark script, a dialect of Python, which
+ is useful for checks that are too specific to deserve their own
+ protocol-test, but are simple to express as a sequence of steps.
+
+ This test is invoked via input like so:
+
+    api.example.com must run script with file '/etc/overseer/scripts/api.star'
+
+ Values can be passed to the script as "key=value" pairs, which are
+ split on whitespace unless double-quoted:
+
+    api.example.com must run script with file '/etc/overseer/scripts/api.star' with args 'min-items=3 path="/v1/items"'
+
+ The script must define a 'check()' function, which is called to run
+ the test.  It fails the test by calling 'fail(msg)', or if any of its
+ statements fails.  It runs in a sandbox, with no access to the local
+ filesystem, and the following globals are available:
+
+    target   - the target of the test
+    args     - a dict of the values passed via the args argument
+    http     - http.get(url, headers={}) and http.post(url, body="", headers={}),
+               returning a struct with status_code, headers and body
+    tcp      - tcp.exchange(address, data=""), sending the optional data
+               and returning the first response received
+    dns      - dns.lookup(name), dns.lookup_mx(name) and dns.lookup_txt(name),
+               returning a list of strings
+    json     - json.encode(value), json.decode(string) and json.indent(string)
+
+ For example:
+
+    def check():
+        resp = http.get("https://%s%s" % (target, args["path"]))
+        if resp.status_code != 200:
+            fail("unexpected status %d" % resp.status_code)
+        items = json.decode(resp.body)["items"]
+        if len(items) < int(args["min-items"]):
+            fail("only %d items found" % len(items))
+
+ Scripts are compiled once, and recompiled only if they change.  They
+ are cancelled if they run for longer than the test timeout, or for too
+ many steps.
+
+ If you need to disable failures due to expired, broken, or
+ otherwise bogus SSL certificates in the HTTP requests you can do so
+ via the tls setting:
+
+    with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+func (s *ScriptTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	if tst.Arguments["file"] == "" {
+		return errors.New("no script file specified")
+	}
+
+	program, err := s.compile(tst.Arguments["file"])
+	if err != nil {
+		return err
+	}
+
+	//
+	// The values passed to the script.
+	//
+	args := starlark.NewDict(0)
+	for _, arg := range splitArgs(tst.Arguments["args"]) {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid script argument '%s', expected key=value", arg)
+		}
+		args.SetKey(starlark.String(parts[0]), starlark.String(parts[1]))
+	}
+
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	//
+	// The HTTP requests of a run share a transport, so that they can
+	// reuse their connections.
+	//
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: tst.Arguments["tls"] == "insecure"},
+	}
+	defer transport.CloseIdleConnections()
+
+	helpers := &scriptHelpers{
+		ctx:    ctx,
+		client: &http.Client{Timeout: timeout, Transport: transport},
+	}
+
+	thread := &starlark.Thread{
+		Name: tst.Arguments["file"],
+		Print: func(_ *starlark.Thread, msg string) {
+			if opts.Verbose {
+				fmt.Printf("\tScript: %s\n", msg)
+			}
+		},
+	}
+
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+
+	//
+	// Cancel the script when we're out of time, the helpers are
+	// cancelled via the context.
+	//
+	timer := time.AfterFunc(timeout, func() {
+		thread.Cancel("timed out")
+	})
+	defer timer.Stop()
+
+	globals, err := program.Init(thread, starlark.StringDict{
+		"target": starlark.String(target),
+		"args":   args,
+		"http":   helpers.httpModule(),
+		"tcp":    helpers.tcpModule(),
+		"dns":    helpers.dnsModule(),
+		"json":   starlarkjson.Module,
+		"fail":   starlark.NewBuiltin("fail", s.fail),
+	})
+	if err != nil {
+		return s.scriptError(err)
+	}
+
+	check, ok := globals["check"].(starlark.Callable)
+	if !ok {
+		return errors.New("the script doesn't define a check() function")
+	}
+
+	if _, err = starlark.Call(thread, check, nil, nil); err != nil {
+		return s.scriptError(err)
+	}
+
+	return nil
+}
+
+// scriptError returns the error to report for a failed script.
+func (s *ScriptTest) scriptError(err error) error {
+	var failure *scriptFailure
+	if errors.As(err, &failure) {
+		return failure
+	}
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("script error: %s", evalErr.Backtrace())
+	}
+	return err
+}
+
+// compile returns the compiled script at the given path, from our cache
+// unless the file has changed.
+func (s *ScriptTest) compile(path string) (*starlark.Program, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptCache.Lock()
+	defer scriptCache.Unlock()
+
+	cached := scriptCache.m[path]
+	if cached != nil && cached.modTime.Equal(stat.ModTime()) {
+		return cached.program, nil
+	}
+
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, program, err := starlark.SourceProgram(path, source, func(name string) bool {
+		return scriptGlobals[name]
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile script: %s", err.Error())
+	}
+
+	scriptCache.m[path] = &compiledScript{modTime: stat.ModTime(), program: program}
+	return program, nil
+}
+
+// fail is the builtin which scripts call to fail the test.
+func (s *ScriptTest) fail(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "msg", &msg); err != nil {
+		return nil, err
+	}
+	return nil, &scriptFailure{msg: msg}
+}
+
+// scriptHelpers implements the sandboxed helpers available to scripts.
+type scriptHelpers struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+// httpModule returns the http helpers.
+func (h *scriptHelpers) httpModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "http",
+		Members: starlark.StringDict{
+			"get":  starlark.NewBuiltin("http.get", h.httpGet),
+			"post": starlark.NewBuiltin("http.post", h.httpPost),
+		},
+	}
+}
+
+func (h *scriptHelpers) httpGet(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url string
+	var headers *starlark.Dict
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &url, "headers?", &headers); err != nil {
+		return nil, err
+	}
+	return h.httpRequest("GET", url, "", headers)
+}
+
+func (h *scriptHelpers) httpPost(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url, body string
+	var headers *starlark.Dict
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &url, "body?", &body, "headers?", &headers); err != nil {
+		return nil, err
+	}
+	return h.httpRequest("POST", url, body, headers)
+}
+
+// httpRequest makes a HTTP request, returning the response as a struct.
+func (h *scriptHelpers) httpRequest(method string, url string, body string, headers *starlark.Dict) (starlark.Value, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(h.ctx)
+	req.Header.Set("User-Agent", "overseer/probe")
+
+	if headers != nil {
+		for _, item := range headers.Items() {
+			name, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("invalid header name %s", item[0])
+			}
+			value, ok := starlark.AsString(item[1])
+			if !ok {
+				return nil, fmt.Errorf("invalid value for header %s", name)
+			}
+			req.Header.Set(name, value)
+		}
+	}
+
+	response, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	content, err := ioutil.ReadAll(&io.LimitedReader{R: response.Body, N: scriptMaxResponse})
+	if err != nil {
+		return nil, err
+	}
+
+	responseHeaders := starlark.NewDict(len(response.Header))
+	for name := range response.Header {
+		responseHeaders.SetKey(starlark.String(strings.ToLower(name)), starlark.String(response.Header.Get(name)))
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String("response"), starlark.StringDict{
+		"status_code": starlark.MakeInt(response.StatusCode),
+		"headers":     responseHeaders,
+		"body":        starlark.String(content),
+	}), nil
+}
+
+// tcpModule returns the tcp helpers.
+func (h *scriptHelpers) tcpModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "tcp",
+		Members: starlark.StringDict{
+			"exchange": starlark.NewBuiltin("tcp.exchange", h.tcpExchange),
+		},
+	}
+}
+
+func (h *scriptHelpers) tcpExchange(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var address, data string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "address", &address, "data?", &data); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(h.ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := h.ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if data != "" {
+		if _, err = conn.Write([]byte(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.String(buf[:n]), nil
+}
+
+// dnsModule returns the dns helpers.
+func (h *scriptHelpers) dnsModule() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "dns",
+		Members: starlark.StringDict{
+			"lookup":     starlark.NewBuiltin("dns.lookup", h.dnsLookup),
+			"lookup_mx":  starlark.NewBuiltin("dns.lookup_mx", h.dnsLookup),
+			"lookup_txt": starlark.NewBuiltin("dns.lookup_txt", h.dnsLookup),
+		},
+	}
+}
+
+func (h *scriptHelpers) dnsLookup(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+
+	var results []string
+	switch fn.Name() {
+	case "dns.lookup":
+		addrs, err := net.DefaultResolver.LookupIPAddr(h.ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			results = append(results, addr.IP.String())
+		}
+	case "dns.lookup_mx":
+		records, err := net.DefaultResolver.LookupMX(h.ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			results = append(results, record.Host)
+		}
+	case "dns.lookup_txt":
+		records, err := net.DefaultResolver.LookupTXT(h.ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		results = records
+	}
+
+	var values []starlark.Value
+	for _, result := range results {
+		values = append(values, starlark.String(result))
+	}
+	return starlark.NewList(values), nil
+}
+
+func (s *ScriptTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("script", func() ProtocolTest {
+		return &ScriptTest{}
+	})
+}
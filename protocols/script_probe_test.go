@@ -0,0 +1,106 @@
+package protocols
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// A script which fetches a JSON document, and checks the number of
+// items it contains.
+const testScript = `
+def check():
+    resp = http.get(target + args["path"], headers={"X-Probe": "yes"})
+    if resp.status_code != 200:
+        fail("unexpected status %d" % resp.status_code)
+    items = json.decode(resp.body)["items"]
+    if len(items) < int(args["min-items"]):
+        fail("only %d items found" % len(items))
+`
+
+// Test running scripts against a local HTTP server
+func TestScript(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Probe") != "yes" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		if r.URL.Path != "/items" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"items": ["a", "b", "c"]}`)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "overseer-scripts")
+	if err != nil {
+		t.Fatalf("Error creating script directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	scripts := map[string]string{
+		"items.star":    testScript,
+		"invalid.star":  "if True\n",
+		"toplevel.star": "if True:\n    pass\n",
+		"nocheck.star":  "x = 1\n",
+		"unknown.star":  "def check():\n    os.remove('/')\n",
+		"loop.star":     "def check():\n    for i in range(100000000):\n        pass\n",
+		"slow.star":     "def check():\n    http.get(target + '/slow', headers={'X-Probe': 'yes'})\n",
+	}
+	for name, content := range scripts {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing script: %s", err.Error())
+		}
+	}
+
+	tests := []struct {
+		File  string
+		Args  string
+		Valid bool
+		Error string
+	}{
+		{"items.star", "path=/items min-items=3", true, ""},
+		{"items.star", "path=/items min-items=4", false, "only 3 items found"},
+		{"items.star", "path=/missing min-items=1", false, "unexpected status 404"},
+		{"items.star", "path=/items", false, "key \"min-items\" not in dict"},
+		{"invalid.star", "", false, "failed to compile script"},
+		{"toplevel.star", "", false, "not within a function"},
+		{"nocheck.star", "", false, "doesn't define a check() function"},
+		{"unknown.star", "", false, "undefined: os"},
+		{"loop.star", "", false, "too many steps"},
+		{"slow.star", "", false, "deadline exceeded"},
+		{"missing.star", "", false, "no such file"},
+	}
+
+	for _, tst := range tests {
+		s := &ScriptTest{}
+		err = s.RunTest(test.Test{Arguments: map[string]string{
+			"file": filepath.Join(dir, tst.File),
+			"args": tst.Args,
+		}}, server.URL, test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected script %s to pass, got error: %s", tst.File, err.Error())
+		}
+		if !tst.Valid {
+			if err == nil {
+				t.Errorf("Expected script %s with args '%s' to fail", tst.File, tst.Args)
+			} else if !strings.Contains(err.Error(), tst.Error) {
+				t.Errorf("Expected script %s with args '%s' to fail with '%s', got: %s", tst.File, tst.Args, tst.Error, err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveSecret returns the value of a secret setting, such as a token.
+//
+// So that secrets don't have to be written into the test-definitions
+// they may be given as "env:NAME", to read an environment variable, or
+// "file:NAME", to read a file from the secrets directory of the worker
+// running the test.  Any other value is used as-is.
+//
+// Files are only read from the secrets directory, as anybody who can
+// enqueue tests could otherwise send any file the worker can read to a
+// server of their choosing.
+func resolveSecret(value string, secretsDir string) (string, error) {
+	if strings.HasPrefix(value, "env:") {
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+
+	if strings.HasPrefix(value, "file:") {
+		if secretsDir == "" {
+			return "", errors.New("file secrets are disabled on this worker, no secrets directory is configured")
+		}
+
+		//
+		// The secret must be a plain file in the secrets directory.
+		//
+		name := strings.TrimPrefix(value, "file:")
+		if name == "" || strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/') || name == "." || name == ".." {
+			return "", fmt.Errorf("invalid secret file '%s'", name)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(secretsDir, name))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	return value, nil
+}
@@ -20,6 +20,15 @@
 //    host.example.com must run smtp [with port 587] with username 'steve@example.com' with password 'secret'  [with tls insecure]
 //
 //
+// The certificate offered via STARTTLS can be checked to not expire
+// within the given period, as for the ssl test:
+//
+//    host.example.com must run smtp with port 587 with expiration 14d
+//
+// If the certificate is self-signed or otherwise non-trusted, only its
+// expiration is checked when using `with tls insecure`, and neither
+// its chain nor its hostname.
+//
 
 package protocols
 
@@ -44,10 +53,11 @@ type SMTPTest struct {
 // their values.
 func (s *SMTPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":     "^[0-9]+$",
-		"username": ".*",
-		"password": ".*",
-		"tls":      "insecure",
+		"port":       "^[0-9]+$",
+		"username":   ".*",
+		"password":   ".*",
+		"tls":        "insecure",
+		"expiration": "^([0-9]+[hd]?)$",
 	}
 	return known
 }
@@ -80,6 +90,15 @@ SMTP Tester
  A complete example, testing a login, will look like this:
 
     host.example.com must run smtp [with port 587] with username 'steve@example.com' with password 's3cr3t'  [with tls insecure]
+
+ The certificate offered via STARTTLS can be checked to not expire
+ within the given period, as for the ssl test:
+
+    host.example.com must run smtp with port 587 with expiration 14d
+
+ If the certificate is self-signed or otherwise non-trusted, only its
+ expiration is checked when using 'with tls insecure', and neither
+ its chain nor its hostname.
 `
 	return str
 }
@@ -192,6 +211,15 @@ func (s *SMTPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		}
 	}
 
+	//
+	// Check the certificate offered via STARTTLS.
+	//
+	if tst.Arguments["expiration"] != "" {
+		if err = checkSTARTTLSExpiration("smtp", address, tst, opts, tst.Arguments["tls"] == "insecure", true); err != nil {
+			return err
+		}
+	}
+
 	// All done
 	return nil
 }
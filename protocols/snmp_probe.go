@@ -0,0 +1,338 @@
+// SNMP Tester
+//
+// The SNMP tester fetches one or more OIDs from a remote agent, and
+// optionally makes assertions upon the returned values.
+//
+// This test is invoked via input like so:
+//
+//    switch.example.com must run snmp with oid '1.3.6.1.2.1.1.3.0'
+//
+// Multiple OIDs can be fetched by joining them with a comma, in which
+// case the assertions are applied to each of the returned values.
+//
+// By default SNMP v2c is used, with the "public" community, but you can
+// change this via:
+//
+//    with community 's3cr3t'
+//
+// SNMP v3 is supported too, with optional authentication and privacy:
+//
+//    with version 3 with username 'monitor' with auth-protocol SHA with auth-password 'secret' with priv-protocol AES with priv-password 'secret'
+//
+// The returned values can be compared literally, via a regular expression,
+// or against numeric thresholds:
+//
+//    # The interface must be operationally "up"
+//    switch.example.com must run snmp with oid '1.3.6.1.2.1.2.2.1.8.1' with expect 1
+//
+//    # The sysDescr must mention the right firmware
+//    switch.example.com must run snmp with oid '1.3.6.1.2.1.1.1.0' with pattern 'Version 15\.'
+//
+//    # The UPS battery charge must be at least 80%
+//    ups.example.com must run snmp with oid '1.3.6.1.2.1.33.1.2.4.0' with min 80
+//
+
+package protocols
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/gosnmp/gosnmp"
+)
+
+// SNMPTest is our object.
+type SNMPTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *SNMPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":          "^[0-9]+$",
+		"version":       "^(1|2c|3)$",
+		"community":     ".*",
+		"username":      ".*",
+		"auth-protocol": "^(MD5|SHA|SHA224|SHA256|SHA384|SHA512)$",
+		"auth-password": ".*",
+		"priv-protocol": "^(DES|AES|AES192|AES256|AES192C|AES256C)$",
+		"priv-password": ".*",
+		"oid":           `^\.?[0-9]+(\.[0-9]+)*(,\s*\.?[0-9]+(\.[0-9]+)*)*$`,
+		"expect":        ".*",
+		"pattern":       ".*",
+		"min":           `^-?[0-9]+(\.[0-9]+)?$`,
+		"max":           `^-?[0-9]+(\.[0-9]+)?$`,
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *SNMPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *SNMPTest) Example() string {
+	str := `
+SNMP Tester
+-----------
+ The SNMP tester fetches one or more OIDs from a remote agent, and
+ optionally makes assertions upon the returned values.
+
+ This test is invoked via input like so:
+
+    switch.example.com must run snmp with oid '1.3.6.1.2.1.1.3.0'
+
+ Multiple OIDs can be fetched by joining them with a comma, in which
+ case the assertions are applied to each of the returned values.
+
+ By default SNMP v2c is used, with the "public" community, but you can
+ change this via:
+
+    with community 's3cr3t'
+
+ SNMP v3 is supported too, with optional authentication and privacy:
+
+    with version 3 with username 'monitor' with auth-protocol SHA with auth-password 'secret' with priv-protocol AES with priv-password 'secret'
+
+ The returned values can be compared literally, via a regular expression,
+ or against numeric thresholds:
+
+    # The interface must be operationally "up"
+    switch.example.com must run snmp with oid '1.3.6.1.2.1.2.2.1.8.1' with expect 1
+
+    # The sysDescr must mention the right firmware
+    switch.example.com must run snmp with oid '1.3.6.1.2.1.1.1.0' with pattern 'Version 15\.'
+
+    # The UPS battery charge must be at least 80%
+    ups.example.com must run snmp with oid '1.3.6.1.2.1.33.1.2.4.0' with min 80
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we make a single SNMP GET request, defaulting to port 161,
+// and compare each of the returned values with our expectations.
+func (s *SNMPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	//
+	// The OIDs to fetch are mandatory.
+	//
+	if tst.Arguments["oid"] == "" {
+		return errors.New("no oid specified")
+	}
+	var oids []string
+	for _, oid := range strings.Split(tst.Arguments["oid"], ",") {
+		oids = append(oids, strings.TrimSpace(oid))
+	}
+
+	//
+	// The default port to connect to.
+	//
+	port := 161
+
+	//
+	// If the user specified a different port update to use it.
+	//
+	if tst.Arguments["port"] != "" {
+		port, err = strconv.Atoi(tst.Arguments["port"])
+		if err != nil {
+			return err
+		}
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:    target,
+		Port:      uint16(port),
+		Community: "public",
+		Version:   gosnmp.Version2c,
+		Timeout:   opts.Timeout,
+		MaxOids:   gosnmp.MaxOids,
+	}
+
+	if tst.Arguments["community"] != "" {
+		client.Community = tst.Arguments["community"]
+	}
+
+	switch tst.Arguments["version"] {
+	case "1":
+		client.Version = gosnmp.Version1
+	case "3":
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.SecurityParameters, client.MsgFlags, err = s.securityParameters(tst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = client.Connect(); err != nil {
+		return err
+	}
+	defer client.Conn.Close()
+
+	result, err := client.Get(oids)
+	if err != nil {
+		return err
+	}
+
+	if result.Error != gosnmp.NoError {
+		return fmt.Errorf("SNMP agent returned error %s", result.Error)
+	}
+
+	//
+	// Now compare each of the returned values.
+	//
+	for _, variable := range result.Variables {
+		value, errValue := s.formatValue(variable)
+		if errValue != nil {
+			return errValue
+		}
+
+		if opts.Verbose {
+			fmt.Printf("\tSNMP %s = %s\n", variable.Name, value)
+		}
+
+		if err = s.checkValue(tst, variable.Name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// securityParameters returns the SNMP v3 security parameters, as
+// configured by the test arguments.
+func (s *SNMPTest) securityParameters(tst test.Test) (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags, error) {
+	if tst.Arguments["username"] == "" {
+		return nil, 0, errors.New("no username specified for SNMP v3")
+	}
+
+	authProtocols := map[string]gosnmp.SnmpV3AuthProtocol{
+		"MD5":    gosnmp.MD5,
+		"SHA":    gosnmp.SHA,
+		"SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256,
+		"SHA384": gosnmp.SHA384,
+		"SHA512": gosnmp.SHA512,
+	}
+	privProtocols := map[string]gosnmp.SnmpV3PrivProtocol{
+		"DES":     gosnmp.DES,
+		"AES":     gosnmp.AES,
+		"AES192":  gosnmp.AES192,
+		"AES256":  gosnmp.AES256,
+		"AES192C": gosnmp.AES192C,
+		"AES256C": gosnmp.AES256C,
+	}
+
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:               tst.Arguments["username"],
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+	flags := gosnmp.NoAuthNoPriv
+
+	if tst.Arguments["auth-protocol"] != "" {
+		params.AuthenticationProtocol = authProtocols[tst.Arguments["auth-protocol"]]
+		params.AuthenticationPassphrase = tst.Arguments["auth-password"]
+		flags = gosnmp.AuthNoPriv
+	}
+
+	if tst.Arguments["priv-protocol"] != "" {
+		if flags != gosnmp.AuthNoPriv {
+			return nil, 0, errors.New("SNMP v3 privacy requires an auth-protocol too")
+		}
+		params.PrivacyProtocol = privProtocols[tst.Arguments["priv-protocol"]]
+		params.PrivacyPassphrase = tst.Arguments["priv-password"]
+		flags = gosnmp.AuthPriv
+	}
+
+	return params, flags, nil
+}
+
+// formatValue converts a returned SNMP variable to its string form.
+func (s *SNMPTest) formatValue(variable gosnmp.SnmpPDU) (string, error) {
+	switch variable.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+		return "", fmt.Errorf("no value available for OID %s", variable.Name)
+	case gosnmp.OctetString:
+		return string(variable.Value.([]byte)), nil
+	case gosnmp.ObjectIdentifier, gosnmp.IPAddress:
+		return fmt.Sprintf("%v", variable.Value), nil
+	}
+	return gosnmp.ToBigInt(variable.Value).String(), nil
+}
+
+// checkValue compares a returned value with the expectations of the test.
+func (s *SNMPTest) checkValue(tst test.Test, oid string, value string) error {
+
+	//
+	// Literal match?
+	//
+	if tst.Arguments["expect"] != "" && value != tst.Arguments["expect"] {
+		return fmt.Errorf("value of OID %s was '%s' not '%s'", oid, value, tst.Arguments["expect"])
+	}
+
+	//
+	// Regular expression?
+	//
+	if tst.Arguments["pattern"] != "" {
+		re, err := regexp.Compile("(?ms)" + tst.Arguments["pattern"])
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("value of OID %s '%s' didn't match the regular expression '%s'", oid, value, tst.Arguments["pattern"])
+		}
+	}
+
+	//
+	// Numeric thresholds?
+	//
+	if tst.Arguments["min"] == "" && tst.Arguments["max"] == "" {
+		return nil
+	}
+
+	number, ok := new(big.Float).SetString(value)
+	if !ok {
+		return fmt.Errorf("value of OID %s '%s' is not numeric", oid, value)
+	}
+
+	if tst.Arguments["min"] != "" {
+		minimum, _ := new(big.Float).SetString(tst.Arguments["min"])
+		if number.Cmp(minimum) < 0 {
+			return fmt.Errorf("value of OID %s is %s, below the min allowed of %s", oid, value, tst.Arguments["min"])
+		}
+	}
+
+	if tst.Arguments["max"] != "" {
+		maximum, _ := new(big.Float).SetString(tst.Arguments["max"])
+		if number.Cmp(maximum) > 0 {
+			return fmt.Errorf("value of OID %s is %s, above the max allowed of %s", oid, value, tst.Arguments["max"])
+		}
+	}
+
+	return nil
+}
+
+func (s *SNMPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("snmp", func() ProtocolTest {
+		return &SNMPTest{}
+	})
+}
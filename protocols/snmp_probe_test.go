@@ -0,0 +1,105 @@
+package protocols
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/gosnmp/gosnmp"
+)
+
+// startSNMPAgent starts a local SNMP v2c agent stand-in, which answers
+// GET requests with the given values.
+func startSNMPAgent(t *testing.T, community string, values map[string]gosnmp.SnmpPDU) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting SNMP agent: %s", err.Error())
+	}
+
+	go func() {
+		decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+		buf := make([]byte, 65535)
+		for {
+			n, addr, errRead := conn.ReadFrom(buf)
+			if errRead != nil {
+				return
+			}
+
+			req, errDecode := decoder.SnmpDecodePacket(buf[:n])
+			if errDecode != nil || req.Community != community {
+				continue
+			}
+
+			resp := &gosnmp.SnmpPacket{
+				Version:   gosnmp.Version2c,
+				Community: community,
+				PDUType:   gosnmp.GetResponse,
+				RequestID: req.RequestID,
+			}
+			for _, variable := range req.Variables {
+				value, ok := values[strings.TrimPrefix(variable.Name, ".")]
+				if !ok {
+					value = gosnmp.SnmpPDU{Type: gosnmp.NoSuchObject}
+				}
+				value.Name = variable.Name
+				resp.Variables = append(resp.Variables, value)
+			}
+
+			out, errMarshal := resp.MarshalMsg()
+			if errMarshal != nil {
+				continue
+			}
+			conn.WriteTo(out, addr)
+		}
+	}()
+
+	return conn
+}
+
+// Test the SNMP-probe against our agent stand-in
+func TestSNMP(t *testing.T) {
+	agent := startSNMPAgent(t, "s3cr3t", map[string]gosnmp.SnmpPDU{
+		"1.3.6.1.2.1.1.1.0":      {Type: gosnmp.OctetString, Value: "Firmware Version 15.2"},
+		"1.3.6.1.2.1.2.2.1.8.1":  {Type: gosnmp.Integer, Value: 1},
+		"1.3.6.1.2.1.33.1.2.4.0": {Type: gosnmp.Integer, Value: 75},
+	})
+	defer agent.Close()
+
+	port := strconv.Itoa(agent.LocalAddr().(*net.UDPAddr).Port)
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{"oid": "1.3.6.1.2.1.1.1.0"}, true},
+		{map[string]string{"oid": "1.3.6.1.2.1.1.1.0", "pattern": `Version 15\.`}, true},
+		{map[string]string{"oid": "1.3.6.1.2.1.1.1.0", "pattern": `Version 16\.`}, false},
+		{map[string]string{"oid": "1.3.6.1.2.1.2.2.1.8.1", "expect": "1"}, true},
+		{map[string]string{"oid": "1.3.6.1.2.1.2.2.1.8.1", "expect": "2"}, false},
+		{map[string]string{"oid": "1.3.6.1.2.1.33.1.2.4.0", "min": "50", "max": "100"}, true},
+		{map[string]string{"oid": "1.3.6.1.2.1.33.1.2.4.0", "min": "80"}, false},
+		{map[string]string{"oid": "1.3.6.1.2.1.2.2.1.8.1, 1.3.6.1.2.1.33.1.2.4.0", "min": "1"}, true},
+		{map[string]string{"oid": "1.3.6.1.2.1.1.5.0"}, false},
+		{map[string]string{"oid": "1.3.6.1.2.1.1.1.0", "community": "public"}, false},
+	}
+
+	for _, tst := range tests {
+		if tst.Arguments["community"] == "" {
+			tst.Arguments["community"] = "s3cr3t"
+		}
+		tst.Arguments["port"] = port
+
+		s := &SNMPTest{}
+		err := s.RunTest(test.Test{Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: 500 * time.Millisecond})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+	}
+}
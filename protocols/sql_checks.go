@@ -0,0 +1,241 @@
+package protocols
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDialect holds how the built-in checks of the mysql and psql
+// protocol-tests are made of a database.
+type sqlDialect struct {
+	// readOnly returns if the database refuses writes.
+	readOnly func(ctx context.Context, db *sql.DB) (bool, error)
+
+	// connections returns the number of connections in use, and the
+	// maximum allowed.
+	connections func(ctx context.Context, db *sql.DB) (float64, float64, error)
+
+	// replicationLag returns how far the database is behind its
+	// primary, failing if it isn't a replica.
+	replicationLag func(ctx context.Context, db *sql.DB) (time.Duration, error)
+}
+
+// sqlExpectation splits an expect setting into the comparison, and the
+// value, such as "< 100".
+var sqlExpectation = regexp.MustCompile(`^(==|!=|<=|>=|=~|<|>)\s*(.+)$`)
+
+// checkSQLArguments ensures the settings of the checks are consistent,
+// before we connect.
+func checkSQLArguments(arguments map[string]string) error {
+	if arguments["expect"] != "" && arguments["query"] == "" {
+		return errors.New("expect requires query")
+	}
+	return nil
+}
+
+// runSQLChecks makes the checks of the database we've been asked to,
+// returning what was found alongside the result.
+func runSQLChecks(ctx context.Context, db *sql.DB, dialect sqlDialect, arguments map[string]string) (*string, error) {
+	var found []string
+	details := func() *string {
+		if len(found) == 0 {
+			return nil
+		}
+		str := strings.Join(found, ", ")
+		return &str
+	}
+
+	//
+	// Does the query return what we expect?
+	//
+	if arguments["query"] != "" {
+		value, err := sqlScalar(ctx, db, arguments["query"])
+		if err != nil {
+			return details(), fmt.Errorf("query failed: %s", err.Error())
+		}
+		result := "NULL"
+		if value.Valid {
+			result = value.String
+		}
+		found = append(found, fmt.Sprintf("result: %s", result))
+
+		if arguments["expect"] != "" {
+			if err = checkSQLExpectation(result, arguments["expect"]); err != nil {
+				return details(), err
+			}
+		}
+	}
+
+	//
+	// Is the database accepting writes, or not?
+	//
+	if arguments["read-only"] != "" {
+		readOnly, err := dialect.readOnly(ctx, db)
+		if err != nil {
+			return details(), err
+		}
+		found = append(found, fmt.Sprintf("read-only: %t", readOnly))
+
+		if readOnly && arguments["read-only"] == "false" {
+			return details(), errors.New("database is read-only")
+		}
+		if !readOnly && arguments["read-only"] == "true" {
+			return details(), errors.New("database is not read-only")
+		}
+	}
+
+	//
+	// Is the replica keeping up?
+	//
+	if arguments["max-replication-lag"] != "" {
+		max, err := parseSQLDuration(arguments["max-replication-lag"])
+		if err != nil {
+			return details(), err
+		}
+		lag, err := dialect.replicationLag(ctx, db)
+		if err != nil {
+			return details(), err
+		}
+		found = append(found, fmt.Sprintf("replication lag: %s", lag))
+
+		if lag > max {
+			return details(), fmt.Errorf("replication lag was %s, more than %s", lag, max)
+		}
+	}
+
+	//
+	// Are we about to run out of connections?
+	//
+	if arguments["max-connections-used"] != "" {
+		limit, err := strconv.ParseFloat(strings.TrimSuffix(arguments["max-connections-used"], "%"), 64)
+		if err != nil {
+			return details(), err
+		}
+		used, max, err := dialect.connections(ctx, db)
+		if err != nil {
+			return details(), err
+		}
+		if max <= 0 {
+			return details(), fmt.Errorf("invalid maximum number of connections %.0f", max)
+		}
+		percent := used * 100 / max
+		found = append(found, fmt.Sprintf("connections used: %.0f/%.0f (%.1f%%)", used, max, percent))
+
+		if percent > limit {
+			return details(), fmt.Errorf("%.1f%% of connections used, more than %.0f%%", percent, limit)
+		}
+	}
+
+	return details(), nil
+}
+
+// checkSQLExpectation tests the result of a query against an expect
+// setting.
+//
+// Without a comparison the result must equal the value.  Otherwise it's
+// compared numerically if the value is a number, as a regular
+// expression with "=~", or as a string.
+func checkSQLExpectation(result string, expect string) error {
+	op := "=="
+	expected := strings.TrimSpace(expect)
+	if match := sqlExpectation.FindStringSubmatch(expected); match != nil {
+		op, expected = match[1], strings.TrimSpace(match[2])
+	}
+	expected = unquoteLiteral(expected)
+
+	failed := fmt.Errorf("query returned '%s', expected %s %s", result, op, expected)
+
+	if op == "=~" {
+		re, err := regexp.Compile(expected)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(result) {
+			return failed
+		}
+		return nil
+	}
+
+	//
+	// Numeric comparison?
+	//
+	if number, err := strconv.ParseFloat(expected, 64); err == nil {
+		actual, errParse := strconv.ParseFloat(strings.TrimSpace(result), 64)
+		if errParse != nil {
+			return fmt.Errorf("query returned '%s', not a number", result)
+		}
+		if !compareNumbers(actual, op, number) {
+			return failed
+		}
+		return nil
+	}
+
+	switch op {
+	case "==":
+		if result != expected {
+			return failed
+		}
+	case "!=":
+		if result == expected {
+			return failed
+		}
+	default:
+		return fmt.Errorf("query result can only be compared with %s to a number", op)
+	}
+	return nil
+}
+
+// parseSQLDuration parses a duration, in seconds unless stated.
+func parseSQLDuration(value string) (time.Duration, error) {
+	if _, err := strconv.Atoi(value); err == nil {
+		value += "s"
+	}
+	return time.ParseDuration(value)
+}
+
+// sqlScalar returns the first column of the first row returned by the
+// query.
+func sqlScalar(ctx context.Context, db *sql.DB, query string) (sql.NullString, error) {
+	var value sql.NullString
+	err := db.QueryRowContext(ctx, query).Scan(&value)
+	return value, err
+}
+
+// sqlRow returns the first row returned by the query, by column name,
+// or nil if there are none.
+func sqlRow(ctx context.Context, db *sql.DB, query string) (map[string]sql.NullString, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]sql.NullString)
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+	return row, nil
+}
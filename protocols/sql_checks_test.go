@@ -0,0 +1,165 @@
+package protocols
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSQLResult is what our fake database returns for a query.
+type testSQLResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// testSQLDatabases are the fake databases, by name, returning results
+// by query.
+var testSQLDatabases = map[string]map[string]testSQLResult{
+	"primary": {
+		"SELECT 42":                       {[]string{"answer"}, [][]driver.Value{{int64(42)}}},
+		"SELECT 'ok'":                     {[]string{"status"}, [][]driver.Value{{"ok"}}},
+		"SELECT NULL":                     {[]string{"nothing"}, [][]driver.Value{{nil}}},
+		"SELECT @@global.read_only":       {[]string{"@@global.read_only"}, [][]driver.Value{{int64(0)}}},
+		"SELECT @@global.max_connections": {[]string{"@@global.max_connections"}, [][]driver.Value{{int64(100)}}},
+		"SHOW GLOBAL STATUS LIKE 'Threads_connected'": {[]string{"Variable_name", "Value"}, [][]driver.Value{{"Threads_connected", "85"}}},
+		"SHOW REPLICA STATUS":                         {[]string{"Replica_IO_State", "Seconds_Behind_Source"}, nil},
+	},
+	"replica": {
+		"SELECT @@global.read_only":                   {[]string{"@@global.read_only"}, [][]driver.Value{{int64(1)}}},
+		"SELECT @@global.max_connections":             {[]string{"@@global.max_connections"}, [][]driver.Value{{int64(100)}}},
+		"SHOW GLOBAL STATUS LIKE 'Threads_connected'": {[]string{"Variable_name", "Value"}, [][]driver.Value{{"Threads_connected", "10"}}},
+		"SHOW SLAVE STATUS":                           {[]string{"Slave_IO_State", "Seconds_Behind_Master"}, [][]driver.Value{{"Waiting for master to send event", int64(12)}}},
+	},
+	"stopped": {
+		"SHOW REPLICA STATUS": {[]string{"Replica_IO_State", "Seconds_Behind_Source"}, [][]driver.Value{{"", nil}}},
+	},
+}
+
+type testSQLDriver struct{}
+
+type testSQLConn struct {
+	results map[string]testSQLResult
+}
+
+type testSQLRows struct {
+	result testSQLResult
+	next   int
+}
+
+func (d testSQLDriver) Open(name string) (driver.Conn, error) {
+	return &testSQLConn{results: testSQLDatabases[name]}, nil
+}
+
+func (c *testSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *testSQLConn) Close() error {
+	return nil
+}
+
+func (c *testSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *testSQLConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	result, ok := c.results[query]
+	if !ok {
+		return nil, errors.New("syntax error")
+	}
+	return &testSQLRows{result: result}, nil
+}
+
+func (r *testSQLRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *testSQLRows) Close() error {
+	return nil
+}
+
+func (r *testSQLRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+func init() {
+	sql.Register("overseer-test", testSQLDriver{})
+}
+
+// Test the query assertions and built-in checks against our fake
+// databases
+func TestSQLChecks(t *testing.T) {
+	tests := []struct {
+		Database  string
+		Arguments map[string]string
+		Valid     bool
+		Details   string
+	}{
+		{"primary", map[string]string{}, true, ""},
+		{"primary", map[string]string{"query": "SELECT 42"}, true, "result: 42"},
+		{"primary", map[string]string{"query": "SELECT 42", "expect": "42"}, true, "result: 42"},
+		{"primary", map[string]string{"query": "SELECT 42", "expect": "< 10"}, false, "result: 42"},
+		{"primary", map[string]string{"query": "SELECT 42", "expect": ">= 40"}, true, "result: 42"},
+		{"primary", map[string]string{"query": "SELECT 'ok'", "expect": "'ok'"}, true, "result: ok"},
+		{"primary", map[string]string{"query": "SELECT 'ok'", "expect": "!= ok"}, false, "result: ok"},
+		{"primary", map[string]string{"query": "SELECT 'ok'", "expect": "=~ ^o"}, true, "result: ok"},
+		{"primary", map[string]string{"query": "SELECT 'ok'", "expect": "> 1"}, false, "result: ok"},
+		{"primary", map[string]string{"query": "SELECT NULL", "expect": "NULL"}, true, "result: NULL"},
+		{"primary", map[string]string{"query": "SELECT oops"}, false, ""},
+		{"primary", map[string]string{"read-only": "false"}, true, "read-only: false"},
+		{"primary", map[string]string{"read-only": "true"}, false, "read-only: false"},
+		{"primary", map[string]string{"max-connections-used": "90%"}, true, "connections used: 85/100 (85.0%)"},
+		{"primary", map[string]string{"max-connections-used": "80"}, false, "connections used: 85/100 (85.0%)"},
+		{"primary", map[string]string{"max-replication-lag": "30"}, false, ""},
+		{"replica", map[string]string{"read-only": "true", "max-replication-lag": "30s"}, true, "read-only: true, replication lag: 12s"},
+		{"replica", map[string]string{"max-replication-lag": "10"}, false, "replication lag: 12s"},
+		{"stopped", map[string]string{"max-replication-lag": "1m"}, false, ""},
+	}
+
+	for _, tst := range tests {
+		db, err := sql.Open("overseer-test", tst.Database)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", tst.Database, err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		details, err := runSQLChecks(ctx, db, mysqlDialect, tst.Arguments)
+		cancel()
+		db.Close()
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.Database, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.Database, tst.Arguments)
+		}
+
+		found := ""
+		if details != nil {
+			found = *details
+		}
+		if found != tst.Details {
+			t.Errorf("Expected details '%s' for %s %v, got '%s'", tst.Details, tst.Database, tst.Arguments, found)
+		}
+	}
+}
+
+// Test the arguments which depend on others
+func TestSQLArguments(t *testing.T) {
+	if err := checkSQLArguments(map[string]string{"expect": "1"}); err == nil || !strings.Contains(err.Error(), "requires query") {
+		t.Errorf("Expected expect without query to fail, got %v", err)
+	}
+	if err := checkSQLArguments(map[string]string{"query": "SELECT 1", "expect": "1"}); err != nil {
+		t.Errorf("Expected expect with query to pass, got %s", err.Error())
+	}
+}
@@ -7,6 +7,26 @@
 //
 //    host.example.com must run ssh [with port 22]
 //
+// To detect an unexpected change of the host key it can be pinned, via
+// its SHA256 fingerprint as shown by "ssh-keygen -lf", or the older MD5
+// one.  The fingerprint is reported with the result:
+//
+//    host.example.com must run ssh with host-key-fingerprint 'SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8'
+//
+// To test that logins are accepted, rather than only that the port is
+// open, a username can be given along with a password, or the path to
+// a private key on the worker:
+//
+//    bastion.example.com must run ssh with username 'overseer' with key-file /etc/overseer/id_ed25519
+//
+// Once logged in a command can be run.  The test fails if it exits with
+// a status other than 0, or the one given, or if its output doesn't
+// match the regular expression given via expect:
+//
+//    bastion.example.com must run ssh with username 'overseer' with password 'secret' with command 'systemctl is-active sshd' with expect '^active'
+//
+//    bastion.example.com must run ssh with username 'overseer' with key-file /etc/overseer/id_ed25519 with command 'test -f /etc/nologin' with exit-status 1
+//
 
 package protocols
 
@@ -14,11 +34,15 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"golang.org/x/crypto/ssh"
 )
 
 // SSHTest is our object.
@@ -30,7 +54,14 @@ type SSHTest struct {
 // their values.
 func (s *SSHTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port": "^[0-9]+$",
+		"port":                 "^[0-9]+$",
+		"command":              ".+",
+		"exit-status":          "^[0-9]+$",
+		"expect":               ".*",
+		"host-key-fingerprint": "^(SHA256:[A-Za-z0-9+/]+=*|([0-9a-fA-F]{2}:){15}[0-9a-fA-F]{2})$",
+		"key-file":             ".*",
+		"password":             ".*",
+		"username":             ".*",
 	}
 	return known
 }
@@ -51,16 +82,45 @@ SSH Tester
  This test is invoked via input like so:
 
     host.example.com must run ssh
+
+ To detect an unexpected change of the host key it can be pinned, via
+ its SHA256 fingerprint as shown by "ssh-keygen -lf", or the older MD5
+ one.  The fingerprint is reported with the result:
+
+    host.example.com must run ssh with host-key-fingerprint 'SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8'
+
+ To test that logins are accepted, rather than only that the port is
+ open, a username can be given along with a password, or the path to
+ a private key on the worker:
+
+    bastion.example.com must run ssh with username 'overseer' with key-file /etc/overseer/id_ed25519
+
+ Once logged in a command can be run.  The test fails if it exits with
+ a status other than 0, or the one given, or if its output doesn't
+ match the regular expression given via expect:
+
+    bastion.example.com must run ssh with username 'overseer' with password 'secret' with command 'systemctl is-active sshd' with expect '^active'
+
+    bastion.example.com must run ssh with username 'overseer' with key-file /etc/overseer/id_ed25519 with command 'test -f /etc/nologin' with exit-status 1
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // test against the given target.
+func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// fingerprint of the host key alongside the result, if the handshake
+// was made.
 //
 // In this case we make a TCP connection, defaulting to port 22, and
-// look for a response which appears to be an SSH-server.
-func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error {
+// look for a response which appears to be an SSH-server.  If we've been
+// asked to check the host key, or to login, we then talk SSH to it.
+func (s *SSHTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
 	var err error
 
 	//
@@ -74,14 +134,33 @@ func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error
 	if tst.Arguments["port"] != "" {
 		port, err = strconv.Atoi(tst.Arguments["port"])
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	//
+	// The settings which need a login.
+	//
+	for _, name := range []string{"password", "key-file", "command"} {
+		if tst.Arguments[name] != "" && tst.Arguments["username"] == "" {
+			return nil, fmt.Errorf("%s requires username", name)
+		}
+	}
+	for _, name := range []string{"expect", "exit-status"} {
+		if tst.Arguments[name] != "" && tst.Arguments["command"] == "" {
+			return nil, fmt.Errorf("%s requires command", name)
+		}
+	}
+
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
 	//
 	// Set an explicit timeout
 	//
-	d := net.Dialer{Timeout: opts.Timeout}
+	d := net.Dialer{Timeout: timeout}
 
 	//
 	// Default to connecting to an IPv4-address
@@ -100,23 +179,156 @@ func (s *SSHTest) RunTest(tst test.Test, target string, opts test.Options) error
 	//
 	conn, err := d.Dial("tcp", address)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer conn.Close()
+
+	//
+	// Without a host key to check, or a login to make, the banner
+	// is all we need.
+	//
+	if tst.Arguments["host-key-fingerprint"] == "" && tst.Arguments["username"] == "" {
+
+		//
+		// Read the banner.
+		//
+		banner, errRead := bufio.NewReader(conn).ReadString('\n')
+		if errRead != nil {
+			return nil, errRead
+		}
+
+		if !strings.Contains(banner, "SSH-") {
+			return nil, errors.New("banner doesn't look like an SSH server")
+		}
+
+		return nil, nil
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	return s.session(conn, address, tst.Arguments, opts.Verbose)
+}
+
+// session makes the SSH handshake over the given connection, checking
+// the host key, then logs in and runs the command if we've been asked
+// to.
+func (s *SSHTest) session(conn net.Conn, address string, arguments map[string]string, verbose bool) (*string, error) {
+	var details *string
+	var hostKeyErr error
+
+	config := &ssh.ClientConfig{
+		User: arguments["username"],
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint := ssh.FingerprintSHA256(key)
+			found := fmt.Sprintf("host key: %s %s", key.Type(), fingerprint)
+			details = &found
+
+			if verbose {
+				fmt.Printf("\tSSH host key: %s %s\n", key.Type(), fingerprint)
+			}
+
+			expected := arguments["host-key-fingerprint"]
+			if expected == "" {
+				return nil
+			}
+			if strings.HasPrefix(expected, "SHA256:") {
+				if strings.TrimRight(expected, "=") != fingerprint {
+					hostKeyErr = fmt.Errorf("host key fingerprint was %s not %s", fingerprint, expected)
+				}
+			} else if !strings.EqualFold(expected, ssh.FingerprintLegacyMD5(key)) {
+				hostKeyErr = fmt.Errorf("host key fingerprint was %s not %s", ssh.FingerprintLegacyMD5(key), expected)
+			}
+			return hostKeyErr
+		},
+	}
+
+	//
+	// The ways we can login.
+	//
+	if arguments["key-file"] != "" {
+		pem, err := ioutil.ReadFile(arguments["key-file"])
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the key-file: %s", err.Error())
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if arguments["password"] != "" {
+		config.Auth = append(config.Auth, ssh.Password(arguments["password"]))
+	}
+
+	client, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+
+	//
+	// If we're only checking the host key then failing to login,
+	// having got as far as seeing it, is fine.
+	//
+	if hostKeyErr != nil {
+		return details, hostKeyErr
+	}
+	if arguments["username"] == "" && details != nil {
+		if err == nil {
+			client.Close()
+		}
+		return details, nil
+	}
+	if err != nil {
+		return details, err
+	}
+	defer client.Close()
+
+	if arguments["command"] == "" {
+		return details, nil
 	}
 
 	//
-	// Read the banner.
+	// Run the command.
 	//
-	banner, err := bufio.NewReader(conn).ReadString('\n')
+	session, err := ssh.NewClient(client, chans, reqs).NewSession()
 	if err != nil {
-		return err
+		return details, err
 	}
-	conn.Close()
+	defer session.Close()
 
-	if !strings.Contains(banner, "SSH-") {
-		return errors.New("banner doesn't look like an SSH server")
+	output, err := session.CombinedOutput(arguments["command"])
+	status := 0
+	if err != nil {
+		exitError, ok := err.(*ssh.ExitError)
+		if !ok {
+			return details, err
+		}
+		status = exitError.ExitStatus()
 	}
 
-	return nil
+	if verbose {
+		fmt.Printf("\tSSH command exited with status %d: %s\n", status, strings.TrimSpace(string(output)))
+	}
+
+	expectedStatus := 0
+	if arguments["exit-status"] != "" {
+		expectedStatus, err = strconv.Atoi(arguments["exit-status"])
+		if err != nil {
+			return details, err
+		}
+	}
+	if status != expectedStatus {
+		return details, fmt.Errorf("command exited with status %d not %d", status, expectedStatus)
+	}
+
+	if arguments["expect"] != "" {
+		re, errCompile := regexp.Compile("(?m)" + arguments["expect"])
+		if errCompile != nil {
+			return details, errCompile
+		}
+		if !re.Match(output) {
+			return details, fmt.Errorf("command output didn't match the regular expression '%s'", arguments["expect"])
+		}
+	}
+
+	return details, nil
 }
 
 func (s *SSHTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
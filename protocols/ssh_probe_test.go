@@ -0,0 +1,161 @@
+package protocols
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer starts an SSH server which accepts the password
+// "secret", or the given key, for the user "overseer".
+//
+// The command "echo active" outputs "active", any other command exits
+// with the status 1.
+func startSSHServer(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) (string, func()) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "overseer" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("invalid password")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "overseer" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("invalid key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, errHandshake := ssh.NewServerConn(conn, config)
+				if errHandshake != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+
+				for newChannel := range chans {
+					channel, requests, errAccept := newChannel.Accept()
+					if errAccept != nil {
+						return
+					}
+					for req := range requests {
+						if req.Type != "exec" {
+							req.Reply(false, nil)
+							continue
+						}
+						req.Reply(true, nil)
+
+						command := string(req.Payload[4:])
+						status := uint32(1)
+						if command == "echo active" {
+							channel.Write([]byte("active\n"))
+							status = 0
+						}
+						payload := make([]byte, 4)
+						binary.BigEndian.PutUint32(payload, status)
+						channel.SendRequest("exit-status", false, payload)
+						channel.Close()
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+// Test the banner, host key, login and command checks
+func TestSSH(t *testing.T) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+	clientSigner, _ := ssh.NewSignerFromKey(clientKey)
+
+	der, _ := x509.MarshalECPrivateKey(clientKey)
+	keyFile, err := ioutil.TempFile("", "overseer-ssh")
+	if err != nil {
+		t.Fatalf("Error creating key file: %s", err.Error())
+	}
+	defer os.Remove(keyFile.Name())
+	pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	keyFile.Close()
+
+	address, stop := startSSHServer(t, hostSigner, clientSigner.PublicKey())
+	defer stop()
+	_, port, _ := net.SplitHostPort(address)
+
+	fingerprint := ssh.FingerprintSHA256(hostSigner.PublicKey())
+	md5 := ssh.FingerprintLegacyMD5(hostSigner.PublicKey())
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"host-key-fingerprint": fingerprint}, true},
+		{map[string]string{"host-key-fingerprint": md5}, true},
+		{map[string]string{"host-key-fingerprint": "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"}, false},
+		{map[string]string{"username": "overseer", "password": "secret"}, true},
+		{map[string]string{"username": "overseer", "password": "wrong"}, false},
+		{map[string]string{"username": "overseer", "key-file": keyFile.Name()}, true},
+		{map[string]string{"username": "other", "key-file": keyFile.Name()}, false},
+		{map[string]string{"username": "overseer", "password": "secret", "host-key-fingerprint": md5, "command": "echo active", "expect": "^active$"}, true},
+		{map[string]string{"username": "overseer", "password": "secret", "command": "echo active", "expect": "^inactive"}, false},
+		{map[string]string{"username": "overseer", "password": "secret", "command": "false"}, false},
+		{map[string]string{"username": "overseer", "password": "secret", "command": "false", "exit-status": "1"}, true},
+		{map[string]string{"password": "secret"}, false},
+		{map[string]string{"expect": "active"}, false},
+	}
+
+	for _, tst := range tests {
+		tst.Arguments["port"] = port
+
+		s := &SSHTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: "localhost", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: 5 * time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %v to fail", tst.Arguments)
+		}
+		if len(tst.Arguments) > 1 && err == nil && (details == nil || !strings.HasSuffix(*details, fingerprint)) {
+			t.Errorf("Unexpected details for %v: %v", tst.Arguments, details)
+		}
+	}
+}
@@ -7,6 +7,11 @@
 //
 //    example.com must run ssl
 //
+// The test runs against each of the addresses the name resolves to,
+// sending the name via SNI, so a single node of a load-balanced service
+// with a stale certificate will be caught.  Port 443 is used unless
+// another is set via port.
+//
 // By default tests will fail if you're probing an SSL-site which has
 // a certificate which will expire within the next 14 days. To change
 // the time-period specify it explicitly like so, if not stated the
@@ -18,29 +23,106 @@
 //    # 12 hours (!)
 //    steve.fi must run ssl with expiration 12h
 //
+// Certificates are validated against the system roots, or against the
+// certificates of a PEM file set via ca-file.
+//
+// The TLS setup of the server can be checked further:
+//
+//    # The server must not accept any version below TLS 1.2
+//    example.com must run ssl with min-tls-version 1.2
+//
+//    # The server must not accept any matching cipher suite
+//    example.com must run ssl with forbidden-ciphers 'RC4,3DES,CBC'
+//
+//    # The certificate must be valid for all these names
+//    example.com must run ssl with san 'example.com,www.example.com'
+//
+//    # The certificate must be issued by this CA, via its CN or full DN
+//    example.com must run ssl with issuer 'R3'
+//
+//    # The server must staple a good OCSP response
+//    example.com must run ssl with ocsp-stapling true
+//
+//    # The server must send all the intermediates, in order
+//    example.com must run ssl with chain-complete true
+//
+// The versions and cipher suites are checked by offering them from Go's
+// TLS client, so only those it supports can be checked.  SSLv3 isn't
+// one of them, and a forbidden cipher matching none of the suites it
+// supports, such as EXPORT or NULL, fails the test.
+//
 
 package protocols
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"strconv"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/cmaster11/overseer/test"
+	"golang.org/x/crypto/ocsp"
 )
 
 // SSLTest is our object.
 type SSLTest struct {
 }
 
+// sslVersions maps the TLS versions we can test for to their values.
+var sslVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// sslCipherSuites contains the names of the cipher suites we know about.
+var sslCipherSuites = map[uint16]string{
+	tls.TLS_RSA_WITH_RC4_128_SHA:                "TLS_RSA_WITH_RC4_128_SHA",
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            "TLS_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            "TLS_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:          "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305:    "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:  "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_AES_128_GCM_SHA256:                  "TLS_AES_128_GCM_SHA256",
+	tls.TLS_AES_256_GCM_SHA384:                  "TLS_AES_256_GCM_SHA384",
+	tls.TLS_CHACHA20_POLY1305_SHA256:            "TLS_CHACHA20_POLY1305_SHA256",
+}
+
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
 func (s *SSLTest) Arguments() map[string]string {
 	known := map[string]string{
-		"expiration": "^([0-9]+[hd]?)$",
+		"expiration":        "^([0-9]+[hd]?)$",
+		"port":              "^[0-9]+$",
+		"ca-file":           ".*",
+		"min-tls-version":   `^(1\.0|1\.1|1\.2|1\.3)$`,
+		"forbidden-ciphers": "^[A-Za-z0-9_,]+$",
+		"san":               ".*",
+		"issuer":            ".*",
+		"ocsp-stapling":     "^(true|false)$",
+		"chain-complete":    "^(true|false)$",
 	}
 	return known
 }
@@ -62,6 +144,11 @@ This test is invoked via input like so:
 
    example.com must run ssl
 
+The test runs against each of the addresses the name resolves to,
+sending the name via SNI, so a single node of a load-balanced service
+with a stale certificate will be caught.  Port 443 is used unless
+another is set via port.
+
 By default tests will fail if you're probing an SSL-site which has
 a certificate which will expire within the next 14 days. To change
 the time-period specify it explicitly like so, if not stated the
@@ -72,12 +159,47 @@ expiration period is assumed to be days:
 
    # 12 hours (!)
    steve.fi must run ssl with expiration 12h
+
+Certificates are validated against the system roots, or against the
+certificates of a PEM file set via ca-file.
+
+The TLS setup of the server can be checked further:
+
+   # The server must not accept any version below TLS 1.2
+   example.com must run ssl with min-tls-version 1.2
+
+   # The server must not accept any matching cipher suite
+   example.com must run ssl with forbidden-ciphers 'RC4,3DES,CBC'
+
+   # The certificate must be valid for all these names
+   example.com must run ssl with san 'example.com,www.example.com'
+
+   # The certificate must be issued by this CA, via its CN or full DN
+   example.com must run ssl with issuer 'R3'
+
+   # The server must staple a good OCSP response
+   example.com must run ssl with ocsp-stapling true
+
+   # The server must send all the intermediates, in order
+   example.com must run ssl with chain-complete true
+
+The versions and cipher suites are checked by offering them from Go's
+TLS client, so only those it supports can be checked.  SSLv3 isn't
+one of them, and a forbidden cipher matching none of the suites it
+supports, such as EXPORT or NULL, fails the test.
 `
 	return str
 }
 
 // RunTest is the part of our API which is invoked to actually execute a
 // SSL-test against the given URL.
+func (s *SSLTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	_, err := s.RunTestWithDetails(tst, target, opts)
+	return err
+}
+
+// RunTestWithDetails is invoked to execute the test, returning the
+// details of the leaf certificate alongside the result.
 //
 // For the purposes of clarity this test makes a TCP dial and verifies SSL
 // certificates validity. The `test.Test` structure contains our raw test,
@@ -90,132 +212,310 @@ expiration period is assumed to be days:
 //
 //    target => "176.9.183.100"
 //
-func (s *SSLTest) RunTest(tst test.Test, _ string, opts test.Options) error {
+func (s *SSLTest) RunTestWithDetails(tst test.Test, target string, opts test.Options) (*string, error) {
 
-	var err error
-	target := tst.Target
+	//
+	// The name we send via SNI, and validate the certificate against.
+	//
+	hostname := tst.Target
+	port := "443"
+	if host, p, errSplit := net.SplitHostPort(tst.Target); errSplit == nil {
+		hostname = host
+		port = p
+	}
+	if tst.Arguments["port"] != "" {
+		port = tst.Arguments["port"]
+	}
+	if target == "" {
+		target = hostname
+	}
+	address := net.JoinHostPort(target, port)
 
 	//
-	// The default expiration-time 14 days.
+	// The expiration period, in hours.
 	//
-	period := 14 * 24
+	period, err := parseExpirationPeriod(tst.Arguments["expiration"])
+	if err != nil {
+		return nil, err
+	}
 
 	//
-	// The user might have specified a different period
-	// in hours / days.
+	// The roots we trust, if not the system ones.
 	//
-	expire := tst.Arguments["expiration"]
-	if expire != "" {
+	var roots *x509.CertPool
+	if tst.Arguments["ca-file"] != "" {
+		roots, err = loadCAFile(tst.Arguments["ca-file"])
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		//
-		// How much to scale the given figure by
-		//
-		// By default if no units are specified we'll
-		// assume the figure is in days, so no scaling
-		// is required.
-		//
-		mul := 1
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
 
-		// Days?
-		if strings.HasSuffix(expire, "d") {
-			expire = strings.Replace(expire, "d", "", -1)
-			mul = 24
-		}
+	if opts.Verbose {
+		fmt.Printf("SSL testing: %s (%s)\n", address, hostname)
+	}
 
-		// Hours?
-		if strings.HasSuffix(expire, "h") {
-			expire = strings.Replace(expire, "h", "", -1)
-			mul = 1
-		}
+	//
+	// We validate the certificates ourselves, to report what is wrong
+	// with them.
+	//
+	state, err := s.handshake(address, timeout, &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New("no certificate sent by the server")
+	}
 
-		// Get the period.
-		period, err = strconv.Atoi(expire)
-		if err != nil {
-			return err
-		}
+	leaf := state.PeerCertificates[0]
+	details := fmt.Sprintf("subject: %s, issuer: %s, serial: %s, expiry: %s",
+		leaf.Subject.String(), leaf.Issuer.String(), leaf.SerialNumber.Text(16), leaf.NotAfter.UTC().Format(time.RFC3339))
+
+	if opts.Verbose {
+		fmt.Printf("SSL certificate - %s\n", details)
+	}
 
-		//
-		// Multiply by our multiplier.
-		//
-		period *= mul
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: intermediates,
+		Roots:         roots,
+	})
+	if err != nil {
+		return &details, fmt.Errorf("SSL certificate verification failed: %s", err.Error())
 	}
 
 	//
 	// Check the expiration
 	//
-	hours, err := s.SSLExpiration(target, opts.Verbose)
+	hours, cn := certificateExpiration(chains, opts.Verbose)
+	if hours < period {
+		return &details, fmt.Errorf("SSL certificate '%s' will expire in %d hours (%d days)", cn, hours, int(hours/24))
+	}
 
-	if err == nil {
-		// Is the age too short?
-		if int64(hours) < int64(period) {
+	if tst.Arguments["chain-complete"] == "true" {
+		if err = s.checkChain(state.PeerCertificates, roots); err != nil {
+			return &details, err
+		}
+	}
+
+	if tst.Arguments["san"] != "" {
+		for _, name := range strings.Split(tst.Arguments["san"], ",") {
+			name = strings.TrimSpace(name)
+			if err = leaf.VerifyHostname(name); err != nil {
+				return &details, fmt.Errorf("SSL certificate is not valid for '%s'", name)
+			}
+		}
+	}
 
-			return fmt.Errorf("SSL certificate will expire in %d hours (%d days)", hours, int(hours/24))
+	if issuer := tst.Arguments["issuer"]; issuer != "" {
+		if leaf.Issuer.CommonName != issuer && leaf.Issuer.String() != issuer {
+			return &details, fmt.Errorf("SSL certificate is issued by '%s', not '%s'", leaf.Issuer.String(), issuer)
+		}
+	}
+
+	if tst.Arguments["ocsp-stapling"] == "true" {
+		if err = s.checkOCSP(state, chains[0]); err != nil {
+			return &details, err
+		}
+	}
+
+	if tst.Arguments["min-tls-version"] != "" {
+		if err = s.checkVersion(address, hostname, timeout, state, sslVersions[tst.Arguments["min-tls-version"]]); err != nil {
+			return &details, err
+		}
+	}
+
+	if tst.Arguments["forbidden-ciphers"] != "" {
+		if err = s.checkCiphers(address, hostname, timeout, state, strings.Split(tst.Arguments["forbidden-ciphers"], ",")); err != nil {
+			return &details, err
 		}
 	}
 
 	//
 	// If we reached here all is OK
 	//
-	return nil
+	return &details, nil
 }
 
-// SSLExpiration returns the number of hours remaining for a given
-// SSL certificate chain.
-func (s *SSLTest) SSLExpiration(host string, verbose bool) (int64, error) {
+// handshake connects to the given address and returns the state of the
+// TLS connection.
+func (s *SSLTest) handshake(address string, timeout time.Duration, cfg *tls.Config) (tls.ConnectionState, error) {
+	dialer := &net.Dialer{Timeout: timeout}
 
-	// Expiry time, in hours
-	var hours int64
-	hours = -1
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, cfg)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
 
-	//
-	// If no port is specified default to :443
-	//
-	p := strings.Index(host, ":")
-	if p == -1 {
-		host += ":443"
+	return conn.ConnectionState(), nil
+}
+
+// checkChain ensures the server sent the whole chain in order: each
+// certificate must be followed by its issuer, up to a trusted root.
+func (s *SSLTest) checkChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	for i := 0; i < len(certs)-1; i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return fmt.Errorf("SSL chain is incomplete: '%s' is not followed by its issuer '%s'", certs[i].Subject.CommonName, certs[i].Issuer.CommonName)
+		}
 	}
 
 	//
-	// Show what we're doing.
+	// The last certificate must be a root, or signed by one.  Trusting
+	// an intermediate locally doesn't count, as other clients won't.
 	//
-	if verbose {
-		fmt.Printf("SSLExpiration testing: %s\n", host)
+	last := certs[len(certs)-1]
+	if s.selfSigned(last) {
+		return nil
+	}
+	chains, err := last.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err == nil {
+		for _, chain := range chains {
+			if len(chain) == 2 && s.selfSigned(chain[1]) {
+				return nil
+			}
+		}
 	}
+	return fmt.Errorf("SSL chain is incomplete: the issuer '%s' of '%s' wasn't sent", last.Issuer.CommonName, last.Subject.CommonName)
+}
+
+// selfSigned returns true if the certificate is a root.
+func (s *SSLTest) selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
 
-	cfg := &tls.Config{}
+// checkOCSP ensures the server stapled a good OCSP response for its
+// certificate.
+func (s *SSLTest) checkOCSP(state tls.ConnectionState, chain []*x509.Certificate) error {
+	if len(state.OCSPResponse) == 0 {
+		return errors.New("no OCSP response stapled by the server")
+	}
 
-	conn, err := tls.Dial("tcp", host, cfg)
+	var issuer *x509.Certificate
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	response, err := ocsp.ParseResponseForCert(state.OCSPResponse, chain[0], issuer)
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("invalid stapled OCSP response: %s", err.Error())
 	}
-	defer conn.Close()
 
-	timeNow := time.Now()
-	for _, chain := range conn.ConnectionState().VerifiedChains {
-		for _, cert := range chain {
+	switch response.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return fmt.Errorf("SSL certificate was revoked at %s", response.RevokedAt.UTC().Format(time.RFC3339))
+	default:
+		return errors.New("stapled OCSP response has an unknown status")
+	}
+
+	if !response.NextUpdate.IsZero() && response.NextUpdate.Before(time.Now()) {
+		return fmt.Errorf("stapled OCSP response expired at %s", response.NextUpdate.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
 
-			// Get the expiration time, in hours.
-			expiresIn := int64(cert.NotAfter.Sub(timeNow).Hours())
+// checkVersion ensures the server doesn't accept any TLS version below
+// the given one.
+func (s *SSLTest) checkVersion(address string, hostname string, timeout time.Duration, state tls.ConnectionState, minimum uint16) error {
+	name := func(version uint16) string {
+		for k, v := range sslVersions {
+			if v == version {
+				return "TLS " + k
+			}
+		}
+		return fmt.Sprintf("version 0x%04x", version)
+	}
+
+	if state.Version < minimum {
+		return fmt.Errorf("server negotiated %s, below the minimum of %s", name(state.Version), name(minimum))
+	}
+
+	for version := uint16(tls.VersionTLS10); version < minimum; version++ {
+		_, err := s.handshake(address, timeout, &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true,
+			MinVersion:         version,
+			MaxVersion:         version,
+		})
+		if err == nil {
+			return fmt.Errorf("server accepts %s, below the minimum of %s", name(version), name(minimum))
+		}
+	}
+	return nil
+}
 
-			if verbose {
-				fmt.Printf("SSLExpiration - certificate: %s expires in %d hours (%d days)\n", cert.Subject.CommonName, expiresIn, expiresIn/24)
+// checkCiphers ensures the server doesn't accept any cipher suite which
+// matches one of the forbidden names.
+//
+// The cipher suites of TLS 1.3 can't be chosen by the client, so these
+// are only checked against the negotiated one.  Names which match none
+// of the suites the client supports are rejected, as they can't be
+// checked.
+func (s *SSLTest) checkCiphers(address string, hostname string, timeout time.Duration, state tls.ConnectionState, forbidden []string) error {
+	for _, name := range forbidden {
+		supported := false
+		for _, suite := range sslCipherSuites {
+			if strings.Contains(suite, strings.ToUpper(name)) {
+				supported = true
 			}
+		}
+		if !supported {
+			return fmt.Errorf("forbidden cipher '%s' can't be checked, it matches none of the cipher suites supported by the client", name)
+		}
+	}
 
-			// If we've not checked anything this is the benchmark
-			if hours == -1 {
-				hours = expiresIn
-			} else {
-				// Otherwise replace our result if the
-				// certificate is going to expire more
-				// recently than the current "winner".
-				if expiresIn < hours {
-					hours = expiresIn
-				}
+	matches := func(suite uint16) bool {
+		for _, name := range forbidden {
+			if name != "" && strings.Contains(sslCipherSuites[suite], strings.ToUpper(name)) {
+				return true
 			}
 		}
+		return false
 	}
 
-	return hours, nil
+	if matches(state.CipherSuite) {
+		return fmt.Errorf("server negotiated the forbidden cipher suite %s", sslCipherSuites[state.CipherSuite])
+	}
+
+	var suites []uint16
+	for suite, name := range sslCipherSuites {
+		if strings.HasPrefix(name, "TLS_AES_") || strings.HasPrefix(name, "TLS_CHACHA20_") {
+			continue
+		}
+		if matches(suite) {
+			suites = append(suites, suite)
+		}
+	}
+	if len(suites) == 0 {
+		return nil
+	}
+
+	accepted, err := s.handshake(address, timeout, &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       suites,
+	})
+	if err == nil {
+		return fmt.Errorf("server accepts the forbidden cipher suite %s", sslCipherSuites[accepted.CipherSuite])
+	}
+	return nil
 }
 
 func (s *SSLTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
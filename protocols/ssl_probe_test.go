@@ -0,0 +1,225 @@
+package protocols
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCertificate is a certificate along with its key.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// newTestCertificate creates a certificate from the template, signed by
+// the given parent, or self-signed if nil.
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+
+	signer, issuer := crypto.Signer(key), template
+	if parent != nil {
+		signer, issuer = parent.key, parent.cert
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err.Error())
+	}
+	return &testCertificate{cert: cert, key: key}
+}
+
+// testPKI is a root CA, an intermediate CA and a leaf certificate.
+type testPKI struct {
+	root, intermediate, leaf *testCertificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	pki := &testPKI{}
+	pki.root = newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pki.intermediate = newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate", Organization: []string{"Overseer"}},
+		NotAfter:              time.Now().Add(5 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, pki.root)
+	pki.leaf = newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "www.example.com"},
+		DNSNames:    []string{"www.example.com", "example.com"},
+		NotAfter:    time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, pki.intermediate)
+	return pki
+}
+
+// writeTestPEM writes the certificates to a temporary PEM file.
+func writeTestPEM(t *testing.T, certs ...*testCertificate) string {
+	file, err := ioutil.TempFile("", "overseer-ca")
+	if err != nil {
+		t.Fatalf("Error creating CA file: %s", err.Error())
+	}
+	defer file.Close()
+
+	for _, cert := range certs {
+		pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: cert.cert.Raw})
+	}
+	return file.Name()
+}
+
+// ocspStaple returns an OCSP response with the given status for the leaf.
+func (pki *testPKI) ocspStaple(t *testing.T, status int) []byte {
+	response, err := ocsp.CreateResponse(pki.intermediate.cert, pki.intermediate.cert, ocsp.Response{
+		Status:       status,
+		SerialNumber: pki.leaf.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   time.Now().Add(24 * time.Hour),
+		RevokedAt:    time.Now().Add(-time.Hour),
+	}, pki.intermediate.key)
+	if err != nil {
+		t.Fatalf("Error creating OCSP response: %s", err.Error())
+	}
+	return response
+}
+
+// startTLSServer starts a server which completes TLS handshakes with
+// the given configuration, returning its port.
+func startTLSServer(t *testing.T, cfg *tls.Config) (string, func()) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatalf("Error starting TLS server: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port, func() { listener.Close() }
+}
+
+// Test the SSL-probe against TLS servers with a certificate of our own CA
+func TestSSL(t *testing.T) {
+	pki := newTestPKI(t)
+
+	caFile := writeTestPEM(t, pki.root)
+	defer os.Remove(caFile)
+
+	// A CA file including the intermediate, as installed on some clients
+	caBundle := writeTestPEM(t, pki.root, pki.intermediate)
+	defer os.Remove(caBundle)
+
+	chain := func(certs ...*testCertificate) tls.Certificate {
+		c := tls.Certificate{PrivateKey: pki.leaf.key}
+		for _, cert := range certs {
+			c.Certificate = append(c.Certificate, cert.cert.Raw)
+		}
+		return c
+	}
+	full := chain(pki.leaf, pki.intermediate)
+
+	stapled := func(status int) tls.Certificate {
+		c := chain(pki.leaf, pki.intermediate)
+		c.OCSPStaple = pki.ocspStaple(t, status)
+		return c
+	}
+
+	unrelated := newTestCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Unrelated"},
+		NotAfter: time.Now().Add(time.Hour),
+	}, nil)
+
+	tests := []struct {
+		Config    *tls.Config
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{}, false},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "expiration": "30d"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "expiration": "100d"}, false},
+
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "chain-complete": "true"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{chain(pki.leaf)}}, map[string]string{"ca-file": caBundle}, true},
+		{&tls.Config{Certificates: []tls.Certificate{chain(pki.leaf)}}, map[string]string{"ca-file": caBundle, "chain-complete": "true"}, false},
+		{&tls.Config{Certificates: []tls.Certificate{chain(pki.leaf, unrelated, pki.intermediate)}}, map[string]string{"ca-file": caFile, "chain-complete": "true"}, false},
+
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "san": "www.example.com,example.com"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "san": "www.example.com,mail.example.com"}, false},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "issuer": "Test Intermediate"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "issuer": "CN=Test Intermediate,O=Overseer"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "issuer": "Other CA"}, false},
+
+		{&tls.Config{Certificates: []tls.Certificate{full}}, map[string]string{"ca-file": caFile, "ocsp-stapling": "true"}, false},
+		{&tls.Config{Certificates: []tls.Certificate{stapled(ocsp.Good)}}, map[string]string{"ca-file": caFile, "ocsp-stapling": "true"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{stapled(ocsp.Revoked)}}, map[string]string{"ca-file": caFile, "ocsp-stapling": "true"}, false},
+
+		{&tls.Config{Certificates: []tls.Certificate{full}, MinVersion: tls.VersionTLS12}, map[string]string{"ca-file": caFile, "min-tls-version": "1.2"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}, MinVersion: tls.VersionTLS10}, map[string]string{"ca-file": caFile, "min-tls-version": "1.2"}, false},
+		{&tls.Config{Certificates: []tls.Certificate{full}, MaxVersion: tls.VersionTLS12}, map[string]string{"ca-file": caFile, "min-tls-version": "1.3"}, false},
+
+		{&tls.Config{Certificates: []tls.Certificate{full}, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}, map[string]string{"ca-file": caFile, "forbidden-ciphers": "CBC,RC4"}, true},
+		{&tls.Config{Certificates: []tls.Certificate{full}, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA}}, map[string]string{"ca-file": caFile, "forbidden-ciphers": "CBC,RC4"}, false},
+		{&tls.Config{Certificates: []tls.Certificate{full}, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}, map[string]string{"ca-file": caFile, "forbidden-ciphers": "RC4,EXPORT"}, false},
+	}
+
+	for _, tst := range tests {
+		port, shutdown := startTLSServer(t, tst.Config)
+		tst.Arguments["port"] = port
+
+		s := &SSLTest{}
+		details, err := s.RunTestWithDetails(test.Test{Target: "www.example.com", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+		shutdown()
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+		if tst.Valid && (details == nil || !strings.Contains(*details, "subject: CN=www.example.com, issuer: CN=Test Intermediate,O=Overseer")) {
+			t.Errorf("Unexpected details for test %v: %v", tst.Arguments, details)
+		}
+	}
+}
@@ -16,32 +16,78 @@
 //
 //    host.example.com must run tcp with port 655 with banner '0 \S+ 17'
 //
+// A short conversation can be held with the remote host, by sending
+// some data and expecting a reply matching a regular expression.  Up to
+// nine steps are supported, the first via send/expect, and the others
+// via send-N/expect-N:
+//
+//    host.example.com must run tcp with port 6379 with send 'PING\r\n' with expect '^\+PONG'
+//
+//    host.example.com must run tcp with port 6379 with send 'PING\r\n' with expect '^\+PONG' with send-2 'QUIT\r\n' with expect-2 '^\+OK'
+//
+// The data to send supports the escapes \r, \n, \t, \\ and \xHH.  Each
+// step must complete within the test timeout, unless a different one is
+// set via step-timeout, or step-timeout-N for a single step.
+//
+// The connection can be wrapped in TLS, optionally with a custom server
+// name to send via SNI and to validate the certificate against:
+//
+//    host.example.com must run tcp with port 6380 with tls true with sni 'redis.example.com'
+//
+// If you need to disable failures due to expired, broken, or
+// otherwise bogus SSL certificates you can do so via:
+//
+//    host.example.com must run tcp with port 6380 with tls insecure
+//
 
 package protocols
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/cmaster11/overseer/test"
 )
 
+// tcpMaxSteps is the maximum number of send/expect steps.
+const tcpMaxSteps = 9
+
 // TCPTest is our object
 type TCPTest struct {
 }
 
+// tcpStep is a single step of the conversation.
+type tcpStep struct {
+	send    []byte
+	pattern string
+	expect  *regexp.Regexp
+	timeout time.Duration
+}
+
 // Arguments returns the names of arguments which this protocol-test
 // understands, along with corresponding regular-expressions to validate
 // their values.
 func (s *TCPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port":   "^[0-9]+$",
-		"banner": ".*",
+		"port":         "^[0-9]+$",
+		"banner":       ".*",
+		"send":         ".*",
+		"expect":       ".*",
+		"step-timeout": `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+		"tls":          "^(true|insecure)$",
+		"sni":          ".*",
+	}
+	for step := 2; step <= tcpMaxSteps; step++ {
+		known[fmt.Sprintf("send-%d", step)] = ".*"
+		known[fmt.Sprintf("expect-%d", step)] = ".*"
+		known[fmt.Sprintf("step-timeout-%d", step)] = `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`
 	}
 	return known
 }
@@ -71,6 +117,29 @@ TCP Tester
  banner the remote host sends on connection:
 
     host.example.com must run tcp with port 655 with banner '0 \S+ 17'
+
+ A short conversation can be held with the remote host, by sending
+ some data and expecting a reply matching a regular expression.  Up to
+ nine steps are supported, the first via send/expect, and the others
+ via send-N/expect-N:
+
+    host.example.com must run tcp with port 6379 with send 'PING\r\n' with expect '^\+PONG'
+
+    host.example.com must run tcp with port 6379 with send 'PING\r\n' with expect '^\+PONG' with send-2 'QUIT\r\n' with expect-2 '^\+OK'
+
+ The data to send supports the escapes \r, \n, \t, \\ and \xHH.  Each
+ step must complete within the test timeout, unless a different one is
+ set via step-timeout, or step-timeout-N for a single step.
+
+ The connection can be wrapped in TLS, optionally with a custom server
+ name to send via SNI and to validate the certificate against:
+
+    host.example.com must run tcp with port 6380 with tls true with sni 'redis.example.com'
+
+ If you need to disable failures due to expired, broken, or
+ otherwise bogus SSL certificates you can do so via:
+
+    host.example.com must run tcp with port 6380 with tls insecure
 `
 	return str
 }
@@ -106,32 +175,52 @@ func (s *TCPTest) RunTest(tst test.Test, target string, opts test.Options) error
 	}
 
 	//
-	// Set an explicit timeout
-	//
-	d := net.Dialer{Timeout: opts.Timeout}
-
-	//
-	// Default to connecting to an IPv4-address
+	// Total test timeout, which is also the default for each step
 	//
-	address := fmt.Sprintf("%s:%d", target, port)
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
 
-	//
-	// If we find a ":" we know it is an IPv6 address though
-	//
-	if strings.Contains(target, ":") {
-		address = fmt.Sprintf("[%s]:%d", target, port)
+	steps, err := s.steps(tst, timeout)
+	if err != nil {
+		return err
 	}
 
 	//
 	// Make the TCP connection.
 	//
-	conn, err := d.Dial("tcp", address)
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.Dial("tcp", net.JoinHostPort(target, strconv.Itoa(port)))
 	if err != nil {
 		return err
 	}
 
 	defer conn.Close()
 
+	//
+	// Wrap the connection in TLS if required, using the original
+	// hostname for SNI and the certificate validation by default.
+	//
+	if tst.Arguments["tls"] != "" {
+		config := &tls.Config{
+			ServerName:         tst.Target,
+			InsecureSkipVerify: tst.Arguments["tls"] == "insecure",
+		}
+		if tst.Arguments["sni"] != "" {
+			config.ServerName = tst.Arguments["sni"]
+		}
+
+		tlsConn := tls.Client(conn, config)
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err = tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake failed: %s", err.Error())
+		}
+		conn = tlsConn
+	}
+
+	reader := bufio.NewReader(conn)
+
 	//
 	// If we're going to do a banner match then we should read a line
 	// from the host
@@ -145,7 +234,8 @@ func (s *TCPTest) RunTest(tst test.Test, target string, opts test.Options) error
 		}
 
 		// Read a single line of input
-		banner, errRead := bufio.NewReader(conn).ReadString('\n')
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		banner, errRead := reader.ReadString('\n')
 		if errRead != nil {
 			return errRead
 		}
@@ -159,9 +249,161 @@ func (s *TCPTest) RunTest(tst test.Test, target string, opts test.Options) error
 		}
 	}
 
+	//
+	// Now hold the conversation.
+	//
+	for i, step := range steps {
+		if err = s.runStep(conn, reader, step); err != nil {
+			return fmt.Errorf("step %d: %s", i+1, err.Error())
+		}
+	}
+
 	return nil
 }
 
+// steps returns the send/expect steps configured for the test.
+func (s *TCPTest) steps(tst test.Test, timeout time.Duration) ([]tcpStep, error) {
+	var steps []tcpStep
+
+	defaultTimeout := timeout
+	if tst.Arguments["step-timeout"] != "" {
+		var err error
+		defaultTimeout, err = time.ParseDuration(tst.Arguments["step-timeout"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for n := 1; n <= tcpMaxSteps; n++ {
+		suffix := ""
+		if n > 1 {
+			suffix = fmt.Sprintf("-%d", n)
+		}
+
+		send := tst.Arguments["send"+suffix]
+		expect := tst.Arguments["expect"+suffix]
+		if send == "" && expect == "" {
+			continue
+		}
+
+		step := tcpStep{timeout: defaultTimeout}
+
+		if send != "" {
+			data, err := unescapeData(send)
+			if err != nil {
+				return nil, fmt.Errorf("invalid send%s: %s", suffix, err.Error())
+			}
+			step.send = data
+		}
+
+		if expect != "" {
+			re, err := regexp.Compile("(?ms)" + expect)
+			if err != nil {
+				return nil, err
+			}
+			step.pattern = expect
+			step.expect = re
+		}
+
+		if n > 1 && tst.Arguments["step-timeout"+suffix] != "" {
+			var err error
+			step.timeout, err = time.ParseDuration(tst.Arguments["step-timeout"+suffix])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// runStep sends the data of the step, if any, and then reads until the
+// expected reply is received.
+func (s *TCPTest) runStep(conn net.Conn, reader *bufio.Reader, step tcpStep) error {
+	conn.SetDeadline(time.Now().Add(step.timeout))
+
+	if len(step.send) > 0 {
+		if _, err := conn.Write(step.send); err != nil {
+			return err
+		}
+	}
+
+	if step.expect == nil {
+		return nil
+	}
+
+	//
+	// Keep reading until the reply matches, as it might arrive in
+	// several pieces.
+	//
+	var reply []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := reader.Read(buf)
+		reply = append(reply, buf[:n]...)
+
+		if step.expect.Match(reply) {
+			return nil
+		}
+
+		if err != nil {
+			if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+				return fmt.Errorf("reply '%s' didn't match the regular expression '%s' within %s", reply, step.pattern, step.timeout)
+			}
+			if err == io.EOF {
+				return fmt.Errorf("connection closed, reply '%s' didn't match the regular expression '%s'", reply, step.pattern)
+			}
+			return err
+		}
+
+		if len(reply) > 1024*1024 {
+			return fmt.Errorf("reply didn't match the regular expression '%s'", step.pattern)
+		}
+	}
+}
+
+// unescapeData expands the escape-sequences in the data to send.
+func unescapeData(input string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(input); i++ {
+		if input[i] != '\\' {
+			out = append(out, input[i])
+			continue
+		}
+
+		i++
+		if i >= len(input) {
+			return nil, errors.New("trailing backslash")
+		}
+
+		switch input[i] {
+		case 'r':
+			out = append(out, '\r')
+		case 'n':
+			out = append(out, '\n')
+		case 't':
+			out = append(out, '\t')
+		case '\\':
+			out = append(out, '\\')
+		case 'x':
+			if i+2 >= len(input) {
+				return nil, errors.New("truncated \\x escape")
+			}
+			b, err := strconv.ParseUint(input[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid \\x escape '%s'", input[i+1:i+3])
+			}
+			out = append(out, byte(b))
+			i += 2
+		default:
+			return nil, fmt.Errorf("unknown escape '\\%c'", input[i])
+		}
+	}
+	return out, nil
+}
+
 func (s *TCPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
 	return nil
 }
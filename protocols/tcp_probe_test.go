@@ -0,0 +1,112 @@
+package protocols
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// serveTCP answers to a redis-like line protocol on the given listener,
+// after sending a banner.
+func serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			conn.Write([]byte("+HELLO overseer\r\n"))
+
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				switch strings.TrimSpace(line) {
+				case "PING":
+					// Reply in two pieces
+					conn.Write([]byte("+PO"))
+					time.Sleep(10 * time.Millisecond)
+					conn.Write([]byte("NG\r\n"))
+				case "SLOW":
+					time.Sleep(300 * time.Millisecond)
+					conn.Write([]byte("+DONE\r\n"))
+				case "QUIT":
+					conn.Write([]byte("+OK\r\n"))
+					return
+				default:
+					conn.Write([]byte("-ERR unknown command\r\n"))
+				}
+			}
+		}(conn)
+	}
+}
+
+// Test the TCP-probe conversations, in plain-text and over TLS
+func TestTCPConversation(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting listener: %s", err.Error())
+	}
+	defer listener.Close()
+	go serveTCP(listener)
+
+	//
+	// Borrow the certificate of a TLS test-server.
+	//
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	server.Close()
+
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: server.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("Error starting TLS listener: %s", err.Error())
+	}
+	defer tlsListener.Close()
+	go serveTCP(tlsListener)
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	tlsPort := strconv.Itoa(tlsListener.Addr().(*net.TCPAddr).Port)
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{"port": port, "banner": "HELLO"}, true},
+		{map[string]string{"port": port, "send": `PING\r\n`, "expect": `^\+PONG\r\n`}, true},
+		{map[string]string{"port": port, "send": `PING\r\n`, "expect": `^\+PANG`}, false},
+		{map[string]string{"port": port, "send": `PING\r\n`, "expect": `\+PONG`, "send-2": `QUIT\x0d\x0a`, "expect-2": `\+OK`}, true},
+		{map[string]string{"port": port, "send": `QUIT\r\n`, "expect": `\+OK`, "send-2": `PING\r\n`, "expect-2": `\+PONG`}, false},
+		{map[string]string{"port": port, "send": `SLOW\r\n`, "expect": `\+DONE`}, true},
+		{map[string]string{"port": port, "send": `SLOW\r\n`, "expect": `\+DONE`, "step-timeout": "100ms"}, false},
+		{map[string]string{"port": port, "send": `PING\r\n`, "expect": `\+PONG`, "send-2": `SLOW\r\n`, "expect-2": `\+DONE`, "step-timeout-2": "100ms"}, false},
+		{map[string]string{"port": port, "send": `PING\q`}, false},
+		{map[string]string{"port": tlsPort, "tls": "insecure", "banner": "HELLO", "send": `PING\r\n`, "expect": `\+PONG`}, true},
+		{map[string]string{"port": tlsPort, "tls": "true", "sni": "example.com", "banner": "HELLO"}, false},
+		{map[string]string{"port": tlsPort, "banner": "HELLO"}, false},
+	}
+
+	for _, tst := range tests {
+		s := &TCPTest{}
+		err = s.RunTest(test.Test{Target: "127.0.0.1", Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+	}
+}
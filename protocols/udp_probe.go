@@ -0,0 +1,219 @@
+// UDP Tester
+//
+// The UDP tester sends a datagram to a remote host, and optionally
+// checks the reply.
+//
+// This test is invoked via input like so:
+//
+//    host.example.com must run udp with port 514
+//
+// The port-setting is mandatory, such that the tests knows where to send
+// the datagram.
+//
+// The payload can be given as text, which supports the escapes \r, \n,
+// \t, \\ and \xHH, or as hex:
+//
+//    host.example.com must run udp with port 8125 with payload 'overseer.probe:1|c'
+//
+//    host.example.com must run udp with port 27015 with payload-hex 'ffffffff54536f7572636520456e67696e6520517565727900'
+//
+// If a pattern is given then a reply matching it must be received within
+// the test timeout:
+//
+//    host.example.com must run udp with port 27015 with payload-hex 'ffffffff54536f7572636520456e67696e6520517565727900' with pattern 'Counter-Strike'
+//
+// Otherwise, as many services never reply, the test fails only if the
+// remote host reports that the port is unreachable via ICMP, within a
+// second or a different duration set via "wait".  Note that firewalls
+// often drop these ICMP messages, so this is a weaker check.
+//
+
+package protocols
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// UDPTest is our object
+type UDPTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *UDPTest) Arguments() map[string]string {
+	known := map[string]string{
+		"port":        "^[0-9]+$",
+		"payload":     ".*",
+		"payload-hex": "^([0-9a-fA-F]{2})+$",
+		"pattern":     ".*",
+		"wait":        `^[+]?([0-9]*(\.[0-9]*)?[a-z]+)+$`,
+	}
+	return known
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *UDPTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *UDPTest) Example() string {
+	str := `
+UDP Tester
+----------
+ The UDP tester sends a datagram to a remote host, and optionally
+ checks the reply.
+
+ This test is invoked via input like so:
+
+    host.example.com must run udp with port 514
+
+ The port-setting is mandatory, such that the tests knows where to send
+ the datagram.
+
+ The payload can be given as text, which supports the escapes \r, \n,
+ \t, \\ and \xHH, or as hex:
+
+    host.example.com must run udp with port 8125 with payload 'overseer.probe:1|c'
+
+    host.example.com must run udp with port 27015 with payload-hex 'ffffffff54536f7572636520456e67696e6520517565727900'
+
+ If a pattern is given then a reply matching it must be received within
+ the test timeout:
+
+    host.example.com must run udp with port 27015 with payload-hex 'ffffffff54536f7572636520456e67696e6520517565727900' with pattern 'Counter-Strike'
+
+ Otherwise, as many services never reply, the test fails only if the
+ remote host reports that the port is unreachable via ICMP, within a
+ second or a different duration set via "wait".  Note that firewalls
+ often drop these ICMP messages, so this is a weaker check.
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// test against the given target.
+//
+// In this case we send a single datagram from a connected socket, so
+// that the kernel reports any ICMP port-unreachable as an error.
+func (s *UDPTest) RunTest(tst test.Test, target string, opts test.Options) error {
+	var err error
+
+	if tst.Arguments["port"] == "" {
+		return errors.New("you must specify the port when running a UDP test")
+	}
+	port, err := strconv.Atoi(tst.Arguments["port"])
+	if err != nil {
+		return err
+	}
+
+	if tst.Arguments["payload"] != "" && tst.Arguments["payload-hex"] != "" {
+		return errors.New("payload and payload-hex are mutually exclusive")
+	}
+
+	var payload []byte
+	if tst.Arguments["payload-hex"] != "" {
+		payload, err = hex.DecodeString(tst.Arguments["payload-hex"])
+	} else {
+		payload, err = unescapeData(tst.Arguments["payload"])
+	}
+	if err != nil {
+		return fmt.Errorf("invalid payload: %s", err.Error())
+	}
+
+	var re *regexp.Regexp
+	if tst.Arguments["pattern"] != "" {
+		re, err = regexp.Compile("(?ms)" + tst.Arguments["pattern"])
+		if err != nil {
+			return err
+		}
+	}
+
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	//
+	// Without a pattern we only wait for a possible ICMP error.
+	//
+	wait := timeout
+	if re == nil {
+		wait = time.Second
+		if tst.Arguments["wait"] != "" {
+			wait, err = time.ParseDuration(tst.Arguments["wait"])
+			if err != nil {
+				return err
+			}
+		}
+		if wait > timeout {
+			wait = timeout
+		}
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(target, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(wait))
+
+	if _, err = conn.Write(payload); err != nil {
+		return s.checkError(err)
+	}
+
+	reply := make([]byte, 65535)
+	n, err := conn.Read(reply)
+	if err != nil {
+		if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
+			if re != nil {
+				return fmt.Errorf("no reply received within %s", wait)
+			}
+			return nil
+		}
+		return s.checkError(err)
+	}
+	reply = reply[:n]
+
+	if opts.Verbose {
+		fmt.Printf("\tUDP reply: %q\n", reply)
+	}
+
+	if re != nil && !re.Match(reply) {
+		return fmt.Errorf("reply %q didn't match the regular expression '%s'", reply, tst.Arguments["pattern"])
+	}
+
+	return nil
+}
+
+// checkError makes the error caused by an ICMP port-unreachable explicit.
+func (s *UDPTest) checkError(err error) error {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return errors.New("port unreachable, the remote host rejected the datagram")
+	}
+	return err
+}
+
+func (s *UDPTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+//
+// Register our protocol-tester.
+//
+func init() {
+	Register("udp", func() ProtocolTest {
+		return &UDPTest{}
+	})
+}
@@ -0,0 +1,72 @@
+package protocols
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+)
+
+// Test the UDP-probe against a local echo service, and a closed port
+func TestUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting UDP service: %s", err.Error())
+	}
+	defer conn.Close()
+
+	// Echo everything, except the "silent" payload
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, errRead := conn.ReadFrom(buf)
+			if errRead != nil {
+				return
+			}
+			if bytes.Equal(buf[:n], []byte("silent")) {
+				continue
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	//
+	// Find a closed port, by closing a socket.
+	//
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error finding a closed port: %s", err.Error())
+	}
+	closedPort := strconv.Itoa(closed.LocalAddr().(*net.UDPAddr).Port)
+	closed.Close()
+
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	tests := []struct {
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{map[string]string{"port": port, "payload": `hello\r\n`, "pattern": "^hello\r\n$"}, true},
+		{map[string]string{"port": port, "payload-hex": "68656c6c6f", "pattern": "^hello$"}, true},
+		{map[string]string{"port": port, "payload": "hello", "pattern": "^bye$"}, false},
+		{map[string]string{"port": port, "payload": "silent", "pattern": "."}, false},
+		{map[string]string{"port": port, "payload": "silent", "wait": "100ms"}, true},
+		{map[string]string{"port": closedPort, "payload": "hello", "wait": "100ms"}, false},
+		{map[string]string{"port": port, "payload": "hello", "payload-hex": "00"}, false},
+	}
+
+	for _, tst := range tests {
+		s := &UDPTest{}
+		err = s.RunTest(test.Test{Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: 500 * time.Millisecond})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected test %v to pass, got error: %s", tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected test %v to fail", tst.Arguments)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// WebSocket Tester
+//
+// The WebSocket tester allows you to confirm that a remote server
+// completes the WebSocket upgrade handshake, and optionally that it
+// replies as expected to a message.
+//
+// This test is invoked via input like so:
+//
+//    wss://example.com/socket must run websocket
+//
+// Extra headers can be sent along with the handshake request, for example
+// to satisfy an origin-check, and the header setting may be repeated:
+//
+//    wss://example.com/socket must run websocket with header 'Origin: https://example.com'
+//
+// A sub-protocol can be requested via:
+//
+//    wss://example.com/socket must run websocket with subprotocol 'graphql-ws'
+//
+// Once connected a message can be sent, in which case the first reply
+// is read and can be tested like the body of the HTTP-test:
+//
+//    wss://example.com/socket must run websocket with send 'ping' with content 'pong'
+//
+//    wss://example.com/socket must run websocket with send '{"type":"ping"}' with pattern '"type":\s*"pong"'
+//
+// If you need to disable failures due to expired, broken, or
+// otherwise bogus SSL certificates you can do so via the tls setting:
+//
+//    wss://expired.badssl.com/ must run websocket with tls insecure
+//
+
+package protocols
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketTest is our object.
+type WebSocketTest struct {
+}
+
+// Arguments returns the names of arguments which this protocol-test
+// understands, along with corresponding regular-expressions to validate
+// their values.
+func (s *WebSocketTest) Arguments() map[string]string {
+	known := map[string]string{
+		"header":      `^[A-Za-z0-9-]+:\s*.*$`,
+		"subprotocol": ".*",
+		"send":        ".*",
+		"content":     ".*",
+		"pattern":     ".*",
+		"tls":         "insecure",
+	}
+	return known
+}
+
+// RepeatableArguments returns the names of the arguments which may be
+// given more than once.
+func (s *WebSocketTest) RepeatableArguments() []string {
+	return []string{"header"}
+}
+
+// ShouldResolveHostname returns if this protocol requires the hostname resolution of the first test argument
+func (s *WebSocketTest) ShouldResolveHostname() bool {
+	return true
+}
+
+// Example returns sample usage-instructions for self-documentation purposes.
+func (s *WebSocketTest) Example() string {
+	str := `
+WebSocket Tester
+----------------
+ The WebSocket tester allows you to confirm that a remote server
+ completes the WebSocket upgrade handshake, and optionally that it
+ replies as expected to a message.
+
+ This test is invoked via input like so:
+
+    wss://example.com/socket must run websocket
+
+ Extra headers can be sent along with the handshake request, for example
+ to satisfy an origin-check, and the header setting may be repeated:
+
+    wss://example.com/socket must run websocket with header 'Origin: https://example.com'
+
+ A sub-protocol can be requested via:
+
+    wss://example.com/socket must run websocket with subprotocol 'graphql-ws'
+
+ Once connected a message can be sent, in which case the first reply
+ is read and can be tested like the body of the HTTP-test:
+
+    wss://example.com/socket must run websocket with send 'ping' with content 'pong'
+
+    wss://example.com/socket must run websocket with send '{"type":"ping"}' with pattern '"type":\s*"pong"'
+
+ If you need to disable failures due to expired, broken, or
+ otherwise bogus SSL certificates you can do so via the tls setting:
+
+    wss://expired.badssl.com/ must run websocket with tls insecure
+`
+	return str
+}
+
+// RunTest is the part of our API which is invoked to actually execute a
+// WebSocket-test against the given URL.
+//
+// Like the HTTP-test, the `test.Test` structure contains our raw test, and
+// the `target` variable contains the IP address against which to make
+// the request.
+func (s *WebSocketTest) RunTest(tst test.Test, target string, opts test.Options) error {
+
+	//
+	// Determine the port to connect to, initially via the protocol
+	// in the string, but allow the URI to override that.
+	//
+	u, err := url.Parse(tst.Target)
+	if err != nil {
+		return err
+	}
+
+	port := ""
+	switch u.Scheme {
+	case "ws":
+		port = "80"
+	case "wss":
+		port = "443"
+	default:
+		return fmt.Errorf("unsupported scheme '%s', expected ws:// or wss://", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	//
+	// Total test timeout
+	//
+	timeout := opts.Timeout
+	if tst.Timeout != nil {
+		timeout = *tst.Timeout
+	}
+
+	//
+	// As for the HTTP-test, we connect to the resolved IP address
+	// while leaving the URL untouched, so that the Host header and
+	// SNI still use the original hostname.
+	//
+	netDialer := &net.Dialer{}
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return netDialer.DialContext(ctx, network, net.JoinHostPort(target, port))
+		},
+		HandshakeTimeout: timeout,
+	}
+
+	//
+	// If we're running insecurely then ignore SSL errors
+	//
+	if tst.Arguments["tls"] == "insecure" {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if tst.Arguments["subprotocol"] != "" {
+		dialer.Subprotocols = []string{tst.Arguments["subprotocol"]}
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", "overseer/probe")
+	extra := http.Header{}
+	for _, value := range ArgumentValues(tst, "header") {
+		parts := strings.SplitN(value, ":", 2)
+		extra.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	for name, values := range extra {
+		header[name] = values
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, response, err := dialer.DialContext(ctx, tst.Target, header)
+	if err != nil {
+		if response != nil {
+			return fmt.Errorf("websocket handshake failed with status code %d: %s", response.StatusCode, err.Error())
+		}
+		return err
+	}
+	defer conn.Close()
+
+	if tst.Arguments["subprotocol"] != "" && conn.Subprotocol() != tst.Arguments["subprotocol"] {
+		return fmt.Errorf("server didn't accept the sub-protocol '%s'", tst.Arguments["subprotocol"])
+	}
+
+	//
+	// If there's nothing to send, we're done.
+	//
+	if tst.Arguments["send"] == "" {
+		if tst.Arguments["content"] != "" || tst.Arguments["pattern"] != "" {
+			return errors.New("content and pattern require a message to send")
+		}
+		return conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(timeout))
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err = conn.WriteMessage(websocket.TextMessage, []byte(tst.Arguments["send"])); err != nil {
+		return err
+	}
+
+	//
+	// Read the first reply.
+	//
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	if opts.Verbose {
+		fmt.Printf("\tWebSocket reply: %s\n", reply)
+	}
+
+	//
+	// Is the user looking for a literal match?
+	//
+	if tst.Arguments["content"] != "" {
+		if !strings.Contains(string(reply), tst.Arguments["content"]) {
+			return fmt.Errorf("reply didn't contain '%s'", tst.Arguments["content"])
+		}
+	}
+
+	//
+	// Is the user expecting a regular expression to match the reply?
+	//
+	if tst.Arguments["pattern"] != "" {
+		re, errCompile := regexp.Compile("(?ms)" + tst.Arguments["pattern"])
+		if errCompile != nil {
+			return errCompile
+		}
+
+		if !re.Match(reply) {
+			return fmt.Errorf("reply didn't match the regular expression '%s'", tst.Arguments["pattern"])
+		}
+	}
+
+	return nil
+}
+
+func (s *WebSocketTest) GetUniqueHashForTest(tst test.Test, opts test.Options) *string {
+	return nil
+}
+
+// init is used to dynamically register our protocol-tester.
+func init() {
+	Register("websocket", func() ProtocolTest {
+		return &WebSocketTest{}
+	})
+}
@@ -0,0 +1,101 @@
+package protocols
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmaster11/overseer/test"
+	"github.com/gorilla/websocket"
+)
+
+// startWebSocketServer starts a server which replies "pong" to "ping",
+// and echoes any other message.
+//
+// Handshakes to /private must come from the https://example.com origin.
+func startWebSocketServer(tls bool) *httptest.Server {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"chat"},
+		CheckOrigin: func(r *http.Request) bool {
+			return r.URL.Path != "/private" || r.Header.Get("Origin") == "https://example.com"
+		},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			kind, message, errRead := conn.ReadMessage()
+			if errRead != nil {
+				return
+			}
+			if string(message) == "ping" {
+				message = []byte("pong")
+			}
+			if conn.WriteMessage(kind, message) != nil {
+				return
+			}
+		}
+	})
+
+	if tls {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+// Test the WebSocket-probe against a local server
+func TestWebSocket(t *testing.T) {
+	server := startWebSocketServer(false)
+	defer server.Close()
+
+	secure := startWebSocketServer(true)
+	defer secure.Close()
+
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+	wss := "wss" + strings.TrimPrefix(secure.URL, "https")
+
+	tests := []struct {
+		URL       string
+		Arguments map[string]string
+		Valid     bool
+	}{
+		{ws + "/", map[string]string{}, true},
+		{ws + "/missing", map[string]string{}, false},
+		{server.URL + "/", map[string]string{}, false},
+		{ws + "/", map[string]string{"send": "ping", "content": "pong"}, true},
+		{ws + "/", map[string]string{"send": "ping", "content": "ping"}, false},
+		{ws + "/", map[string]string{"send": `{"type": "hello"}`, "pattern": `"type":\s*"hello"`}, true},
+		{ws + "/", map[string]string{"send": "hello", "pattern": "^bye$"}, false},
+		{ws + "/", map[string]string{"content": "pong"}, false},
+		{ws + "/", map[string]string{"subprotocol": "chat"}, true},
+		{ws + "/", map[string]string{"subprotocol": "graphql-ws"}, false},
+		{ws + "/private", map[string]string{}, false},
+		{ws + "/private", map[string]string{"header": "Origin: https://example.com"}, true},
+		{wss + "/", map[string]string{"tls": "insecure", "send": "ping", "content": "pong"}, true},
+		{wss + "/", map[string]string{}, false},
+	}
+
+	for _, tst := range tests {
+		s := &WebSocketTest{}
+		err := s.RunTest(test.Test{Target: tst.URL, Arguments: tst.Arguments}, "127.0.0.1", test.Options{Timeout: time.Second})
+
+		if tst.Valid && err != nil {
+			t.Errorf("Expected %s %v to pass, got error: %s", tst.URL, tst.Arguments, err.Error())
+		}
+		if !tst.Valid && err == nil {
+			t.Errorf("Expected %s %v to fail", tst.URL, tst.Arguments)
+		}
+	}
+}
@@ -7,6 +7,15 @@
 //
 //    host.example.com must run xmpp [with port 5222]
 //
+// The certificate offered via STARTTLS can be checked to not expire
+// within the given period, as for the ssl test:
+//
+//    host.example.com must run xmpp with expiration 14d
+//
+// If the certificate is self-signed or otherwise non-trusted, only its
+// expiration is checked when using `with tls insecure`, and neither
+// its chain nor its hostname.
+//
 
 package protocols
 
@@ -29,7 +38,9 @@ type XMPPTest struct {
 // their values.
 func (s *XMPPTest) Arguments() map[string]string {
 	known := map[string]string{
-		"port": "^[0-9]+$",
+		"port":       "^[0-9]+$",
+		"expiration": "^([0-9]+[hd]?)$",
+		"tls":        "insecure",
 	}
 	return known
 }
@@ -50,6 +61,15 @@ XMPP Tester
  This test is invoked via input like so:
 
     host.example.com must run xmpp
+
+ The certificate offered via STARTTLS can be checked to not expire
+ within the given period, as for the ssl test:
+
+    host.example.com must run xmpp with expiration 14d
+
+ If the certificate is self-signed or otherwise non-trusted, only its
+ expiration is checked when using 'with tls insecure', and neither
+ its chain nor its hostname.
 `
 	return str
 }
@@ -130,6 +150,15 @@ func (s *XMPPTest) RunTest(tst test.Test, target string, opts test.Options) erro
 		return fmt.Errorf("banner doesn't look like an XMPP-banner '%s'", banner)
 	}
 
+	//
+	// Check the certificate offered via STARTTLS.
+	//
+	if tst.Arguments["expiration"] != "" {
+		if err = checkSTARTTLSExpiration("xmpp", address, tst, opts, tst.Arguments["tls"] == "insecure", true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -20,6 +20,7 @@ package test
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -98,13 +99,19 @@ func (obj *Test) Sanitize() string {
 	for _, k := range keys {
 		tmp := ""
 
-		// Censor passwords
-		if k == "password" {
-			tmp = " with password 'CENSORED'"
+		// Censor passwords, and other secrets
+		if isSecretArgument(k) {
+			tmp = fmt.Sprintf(" with %s 'CENSORED'", k)
 		} else {
 
-			// Otherwise leave alone.
-			tmp = fmt.Sprintf(" with %s '%s'", k, obj.Arguments[k])
+			// Otherwise leave alone, repeating the arguments
+			// which were given more than once.
+			for _, v := range strings.Split(obj.Arguments[k], "\n") {
+				if k == "header" && isSecretHeader(v) {
+					v = strings.SplitN(v, ":", 2)[0] + ": CENSORED"
+				}
+				tmp += fmt.Sprintf(" with %s '%s'", k, v)
+			}
 		}
 		res += tmp
 	}
@@ -112,6 +119,25 @@ func (obj *Test) Sanitize() string {
 	return res
 }
 
+// isSecretArgument returns true if the value of the named argument
+// must not be shown.
+func isSecretArgument(name string) bool {
+	return name == "password" ||
+		name == "community" ||
+		name == "bearer-token" ||
+		strings.HasSuffix(name, "-password") ||
+		strings.HasSuffix(name, "-secret")
+}
+
+// isSecretHeader returns true if the given "Name: value" header carries
+// credentials, and so its value must be censored.
+func isSecretHeader(header string) bool {
+	name := strings.TrimSpace(strings.SplitN(header, ":", 2)[0])
+	return strings.EqualFold(name, "Authorization") ||
+		strings.EqualFold(name, "Proxy-Authorization") ||
+		strings.EqualFold(name, "Cookie")
+}
+
 // Options are options which are passed to every test-handler.
 //
 // The options might change the way the test operates.
@@ -125,4 +151,19 @@ type Options struct {
 	// If this is a period test, we may want to replace vars in the target address
 	PeriodTestIndex     int
 	PeriodTestStartTime int64
+
+	// The directory containing the plugins which the exec-test is allowed to run
+	ExecPluginDir string
+
+	// The directory containing the secrets which tests may read via "file:"
+	SecretsDir string
+
+	// Should the protocol-tests use IPv4 and IPv6 addresses, when they
+	// resolve further hosts themselves?  Both are used if neither is set.
+	IPv4 bool
+	IPv6 bool
+
+	// If set, protocol-tests can submit extra measurements, in
+	// milliseconds, such as the phases of a HTTP request
+	Metric func(name string, value float64) `json:"-"`
 }
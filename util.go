@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
+
+	"github.com/cmaster11/overseer/protocols"
 )
 
 func waitForSignalInterrupt() {
@@ -48,3 +51,21 @@ func indent(text, indent string) string {
 	}
 	return result[:len(result)-1]
 }
+
+// loadProbePlugins registers the external probe plugins found in the
+// given directory, if one is configured.
+func loadProbePlugins(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	names, err := protocols.LoadPlugins(dir)
+	if err != nil {
+		return fmt.Errorf("Error loading probe plugins: %s", err.Error())
+	}
+
+	for _, name := range names {
+		fmt.Printf("Loaded probe plugin %s\n", name)
+	}
+	return nil
+}